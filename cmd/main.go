@@ -1,55 +1,135 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/arnavsurve/promise/pkg/ai"
+	"github.com/arnavsurve/promise/pkg/auth"
 	"github.com/arnavsurve/promise/pkg/db"
 	"github.com/arnavsurve/promise/pkg/handlers"
+	"github.com/arnavsurve/promise/pkg/logging"
+	"github.com/arnavsurve/promise/pkg/metrics"
+	"github.com/arnavsurve/promise/pkg/tracing"
+	"github.com/arnavsurve/promise/pkg/webhooks"
 	"github.com/arnavsurve/promise/pkg/workers"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// shutdownTimeout bounds how long in-flight requests and subtasks get to finish on SIGTERM
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	// numWorkers := flag.Int("w", 0, "Size of worker pool to initialize")
 	// retryCount := flag.Int("retry", 3, "Retry limit for jobs on failure")
 
 	// flag.Parse()
 
-	if err := godotenv.Load(); err != nil {
-		log.Printf("error %s", err)
+	envErr := godotenv.Load()
+
+	// Build the logger after .env is loaded so LOG_* settings apply
+	logger := logging.New(os.Stderr, logging.ConfigFromEnv())
+	slog.SetDefault(logger)
+	if envErr != nil {
+		logger.Warn("Could not load .env file", "error", envErr)
+	}
+
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		logger.Error("Failed to initialize tracing", "error", err)
+		os.Exit(1)
 	}
 
-	store, err := db.NewStore()
+	store, err := db.NewStore(logger)
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("Failed to connect to the database", "error", err)
+		os.Exit(1)
 	}
 	store.InitJobsTable()
 
+	// ADMIN_API_KEY seeds the first admin key; further keys are issued through /v1/admin/keys
+	if key := os.Getenv("ADMIN_API_KEY"); key != "" {
+		if err := auth.EnsureBootstrapKey(store, key); err != nil {
+			logger.Error("Failed to register ADMIN_API_KEY", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	ai.InitClient(store.Rdb, ai.ClientConfigFromEnv())
+	ai.LoadPricesFromEnv()
+	if err := ai.LoadRiskRulesFromEnv(); err != nil {
+		logger.Error("Invalid risk rule configuration", "error", err)
+		os.Exit(1)
+	}
+
 	// go workers.InitWorkerPool(store, *numWorkers, *retryCount)
 
-	go workers.WorkerManager(store)
+	workers.SetConcurrencyLimits(workers.ConcurrencyLimitsFromEnv())
+	go workers.WorkerManager(store, workers.AutoscaleConfigFromEnv())
+	go workers.Reaper(store)
+	go webhooks.Run(store)
 
-	http.HandleFunc("/job", requestHandler(map[string]http.HandlerFunc{
-		http.MethodPost: handlers.EnqueueJob(store),
-	}))
+	mux := http.NewServeMux()
+	handlers.RegisterV1(mux, store)
 
-	http.HandleFunc("/job/status", handlers.GetJobStatus(store))
+	// Unversioned routes kept for existing clients; new integrations should use /v1
+	mux.HandleFunc("POST /job", handlers.RequireScope(store, auth.ScopeSubmit, handlers.RejectWhenDraining(handlers.EnqueueJob(store))))
+	mux.HandleFunc("GET /job/status", handlers.RequireScope(store, auth.ScopeRead, handlers.GetJobStatus(store)))
+	mux.HandleFunc("POST /job/decompose", handlers.RequireScope(store, auth.ScopeDecompose, handlers.RejectWhenDraining(handlers.EnqueueJobWithDecomposition(store))))
+	mux.HandleFunc("GET /job/budget", handlers.RequireScope(store, auth.ScopeRead, handlers.GetTaskBudget(store)))
+	mux.HandleFunc("POST /job/budget", handlers.RequireScope(store, auth.ScopeDecompose, handlers.UpdateTaskBudget(store)))
+	mux.HandleFunc("GET /jobs", handlers.RequireScope(store, auth.ScopeRead, handlers.ListJobs(store)))
+	mux.HandleFunc("GET /tasks", handlers.RequireScope(store, auth.ScopeRead, handlers.ListTasks(store)))
+	mux.HandleFunc("GET /costs", handlers.RequireScope(store, auth.ScopeRead, handlers.GetCostReport(store)))
+	mux.HandleFunc("GET /workers", handlers.RequireScope(store, auth.ScopeAdmin, handlers.ListWorkers(store)))
+	mux.HandleFunc("GET /workers/autoscale", handlers.RequireScope(store, auth.ScopeAdmin, handlers.GetAutoscaleStatus(store)))
+	mux.HandleFunc("GET /workers/resources", handlers.RequireScope(store, auth.ScopeAdmin, handlers.ListResourceUsage(store)))
+	mux.HandleFunc("GET /admin/drain", handlers.RequireScope(store, auth.ScopeAdmin, handlers.GetDrainStatus()))
+	mux.HandleFunc("POST /admin/drain", handlers.RequireScope(store, auth.ScopeAdmin, handlers.SetDrainMode()))
 
-	http.HandleFunc("/job/decompose", handlers.EnqueueJobWithDecomposition(store))
+	metrics.RegisterQueueCollector(store)
+	metrics.RegisterActiveWorkers(func() float64 { return float64(workers.ActiveWorkers()) })
+	mux.Handle("GET /metrics", promhttp.Handler())
 
-	fmt.Print("Server running on :8080\n\n")
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
+	server := &http.Server{Addr: ":8080", Handler: metrics.InstrumentHandler(mux)}
 
-// requestHandler handles incoming requests and calls the handler associated with a particular HTTP request method
-func requestHandler(handlers map[string]http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if handler, exists := handlers[r.Method]; exists {
-			handler(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	go func() {
+		logger.Info("Server running", "addr", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("HTTP server failed", "error", err)
+			os.Exit(1)
 		}
+	}()
+
+	// Wait for SIGINT/SIGTERM, then stop accepting requests and drain workers
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-sigCtx.Done()
+
+	logger.Info("Shutdown signal received, draining node")
+	workers.SetDraining(true)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("HTTP server shutdown error", "error", err)
+	}
+
+	deadline, _ := shutdownCtx.Deadline()
+	workers.Shutdown(time.Until(deadline))
+
+	// Flush buffered spans with a fresh deadline; the shutdown one may already be spent
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Error("Tracing shutdown error", "error", err)
 	}
+	logger.Info("Shutdown complete")
 }
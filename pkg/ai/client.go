@@ -0,0 +1,306 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/env"
+	"github.com/arnavsurve/promise/pkg/logging"
+	"github.com/arnavsurve/promise/pkg/metrics"
+	"github.com/arnavsurve/promise/pkg/tracing"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const groqURL = "https://api.groq.com/openai/v1/chat/completions"
+const defaultModel = "llama-3.3-70b-versatile"
+
+// ClientConfig controls the shared LLM client. Rate limits are enforced across all nodes through Redis.
+type ClientConfig struct {
+	RequestsPerMinute int64
+	TokensPerMinute   int64
+	CompletionTokens  int64 // Estimated completion size charged against the token bucket for each request
+	Timeout           time.Duration
+	MaxRetries        int
+	MaxBackoff        time.Duration
+}
+
+// llmClient sends chat completion requests through a distributed token-bucket limiter
+type llmClient struct {
+	http *http.Client
+	rdb  *redis.Client
+	cfg  ClientConfig
+}
+
+// client is shared by every LLM call in the process. InitClient attaches the Redis limiter.
+var client = &llmClient{
+	http: &http.Client{Timeout: 60 * time.Second},
+	cfg:  ClientConfigFromEnv(),
+}
+
+// takeScript is a token bucket stored as a hash of {tokens, updated_at}.
+// KEYS[1] = bucket key, ARGV = capacity, refill per ms, cost. The time comes from Redis so clock skew between nodes
+// cannot refill the bucket early.
+// Returns 0 when the cost was taken, otherwise the number of ms to wait before retrying.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated_at')
+local tokens = tonumber(bucket[1]) or capacity
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + (now - updated) * rate)
+if cost > capacity then cost = capacity end
+if tokens >= cost then
+	redis.call('HSET', KEYS[1], 'tokens', tokens - cost, 'updated_at', now)
+	redis.call('PEXPIRE', KEYS[1], 120000)
+	return 0
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'updated_at', now)
+redis.call('PEXPIRE', KEYS[1], 120000)
+return math.ceil((cost - tokens) / rate)
+`)
+
+// ClientConfigFromEnv reads LLM_* rate limit and retry settings from the environment
+func ClientConfigFromEnv() ClientConfig {
+	return ClientConfig{
+		RequestsPerMinute: env.Int64("LLM_REQUESTS_PER_MINUTE", 30),
+		TokensPerMinute:   env.Int64("LLM_TOKENS_PER_MINUTE", 6000),
+		CompletionTokens:  env.Int64("LLM_COMPLETION_TOKENS_ESTIMATE", 1024),
+		Timeout:           env.Duration("LLM_TIMEOUT", 60*time.Second),
+		MaxRetries:        int(env.Int64("LLM_MAX_RETRIES", 5)),
+		MaxBackoff:        env.Duration("LLM_MAX_BACKOFF", 60*time.Second),
+	}
+}
+
+// InitClient configures the shared LLM client and attaches the Redis connection used for distributed rate limiting.
+// Without it requests are sent unthrottled.
+func InitClient(rdb *redis.Client, cfg ClientConfig) {
+	client = &llmClient{
+		http: &http.Client{Timeout: cfg.Timeout},
+		rdb:  rdb,
+		cfg:  cfg,
+	}
+}
+
+// estimateTokens approximates the token cost of a request at ~4 characters per token plus the expected completion
+func (c *llmClient) estimateTokens(payload RequestPayload) int64 {
+	chars := 0
+	for _, message := range payload.Messages {
+		chars += len(message.Content)
+	}
+	return int64(chars/4) + c.cfg.CompletionTokens
+}
+
+// take blocks until the named bucket can pay cost, or ctx is done
+func (c *llmClient) take(ctx context.Context, bucket string, perMinute int64, cost int64) error {
+	if c.rdb == nil || perMinute <= 0 {
+		return nil
+	}
+	rate := float64(perMinute) / float64(time.Minute/time.Millisecond)
+
+	for {
+		wait, err := takeScript.Run(ctx, c.rdb, []string{bucket}, perMinute, rate, cost).Int64()
+		if err != nil {
+			return err
+		}
+		if wait == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(wait) * time.Millisecond):
+		}
+	}
+}
+
+// backoff returns the delay before retry attempt n, honouring the provider's Retry-After header when present.
+// Every delay is capped at MaxBackoff.
+func (c *llmClient) backoff(attempt int, retryAfter string) time.Duration {
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		if time.Duration(seconds) > c.cfg.MaxBackoff/time.Second {
+			return c.cfg.MaxBackoff
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(retryAfter); err == nil {
+		return max(0, min(time.Until(at), c.cfg.MaxBackoff))
+	}
+
+	// Exponential backoff with jitter: 1s, 2s, 4s, ... capped at MaxBackoff
+	delay := time.Second << attempt
+	if delay > c.cfg.MaxBackoff || delay <= 0 {
+		delay = c.cfg.MaxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// ChatCompletion sends a rate-limited chat completion request, retrying on 429 and 5xx responses
+func (c *llmClient) ChatCompletion(ctx context.Context, payload RequestPayload) (*GroqResponse, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "llm.chat_completion", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("llm.model", payload.Model)))
+	defer span.End()
+
+	resp, err := c.chatCompletion(ctx, payload)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "LLM request failed")
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.Int64("llm.prompt_tokens", resp.Usage.PromptTokens),
+		attribute.Int64("llm.completion_tokens", resp.Usage.CompletionTokens),
+	)
+	return resp, nil
+}
+
+func (c *llmClient) chatCompletion(ctx context.Context, payload RequestPayload) (*GroqResponse, error) {
+	apiKey := os.Getenv("GROQ_API_KEY")
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := c.take(ctx, "llm_ratelimit:requests", c.cfg.RequestsPerMinute, 1); err != nil {
+			return nil, err
+		}
+		if err := c.take(ctx, "llm_ratelimit:tokens", c.cfg.TokensPerMinute, c.estimateTokens(payload)); err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", groqURL, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+
+		sentAt := time.Now()
+		resp, err := c.http.Do(req)
+		if err != nil {
+			metrics.LLMRequests.WithLabelValues(payload.Model, "transport_error").Inc()
+			if ctx.Err() != nil || attempt >= c.cfg.MaxRetries {
+				return nil, err
+			}
+			delay := c.backoff(attempt, "")
+			logging.FromContext(ctx).Warn("LLM request failed, retrying", "error", err, "delay", delay, "attempt", attempt+1, "max_retries", c.cfg.MaxRetries)
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			outcome := "server_error"
+			if resp.StatusCode == http.StatusTooManyRequests {
+				outcome = "rate_limited"
+			}
+			metrics.LLMRequests.WithLabelValues(payload.Model, outcome).Inc()
+			if attempt >= c.cfg.MaxRetries {
+				return nil, fmt.Errorf("LLM request failed after %d retries: %s", attempt, describeError(resp.StatusCode, body))
+			}
+			delay := c.backoff(attempt, resp.Header.Get("Retry-After"))
+			logging.FromContext(ctx).Warn("LLM request throttled or failed, retrying", "status", resp.StatusCode, "delay", delay, "attempt", attempt+1, "max_retries", c.cfg.MaxRetries)
+			trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+				attribute.Int("http.status_code", resp.StatusCode),
+				attribute.String("retry.delay", delay.String()),
+			))
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			metrics.LLMRequests.WithLabelValues(payload.Model, "client_error").Inc()
+			return nil, fmt.Errorf("LLM response was unsuccessful: %s", describeError(resp.StatusCode, body))
+		}
+
+		var groqResponse GroqResponse
+		if err := json.Unmarshal(body, &groqResponse); err != nil {
+			return nil, err
+		}
+		if len(groqResponse.Choices) == 0 {
+			return nil, fmt.Errorf("LLM response was unsuccessful: no choices returned")
+		}
+
+		model := groqResponse.Model
+		if model == "" {
+			model = payload.Model
+		}
+		usage := Usage{
+			Model:            model,
+			PromptTokens:     groqResponse.Usage.PromptTokens,
+			CompletionTokens: groqResponse.Usage.CompletionTokens,
+			Latency:          time.Since(sentAt),
+			Cost:             Cost(model, groqResponse.Usage.PromptTokens, groqResponse.Usage.CompletionTokens),
+		}
+		recordUsage(ctx, usage)
+
+		metrics.LLMRequests.WithLabelValues(payload.Model, "success").Inc()
+		metrics.LLMLatency.WithLabelValues(payload.Model).Observe(usage.Latency.Seconds())
+		metrics.LLMTokens.WithLabelValues(payload.Model, "prompt").Add(float64(usage.PromptTokens))
+		metrics.LLMTokens.WithLabelValues(payload.Model, "completion").Add(float64(usage.CompletionTokens))
+		metrics.LLMCost.WithLabelValues(payload.Model).Add(usage.Cost)
+
+		return &groqResponse, nil
+	}
+}
+
+// describeError renders a provider error body, falling back to the raw status when it is not a Groq error
+func describeError(status int, body []byte) string {
+	var groqError GroqErrorResponse
+	if err := json.Unmarshal(body, &groqError); err == nil && groqError.Error.Message != "" {
+		return fmt.Sprintf("%d %s: %s", status, groqError.Error.Type, groqError.Error.Message)
+	}
+	return fmt.Sprintf("%d %s", status, http.StatusText(status))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// completeJSON sends a single user prompt and returns the model's JSON message content
+func completeJSON(ctx context.Context, prompt string) (string, error) {
+	resp, err := client.ChatCompletion(ctx, RequestPayload{
+		Model: defaultModel,
+		Messages: []Message{
+			{
+				Role:    "user",
+				Content: prompt,
+			},
+		},
+		ResponseFormat: map[string]string{"type": "json_object"},
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Choices[0].Message.Content, nil
+}
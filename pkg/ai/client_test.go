@@ -0,0 +1,37 @@
+package ai
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	c := &llmClient{cfg: ClientConfig{MaxBackoff: 30 * time.Second}}
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{"retry-after seconds", 0, "5", 5 * time.Second, 5 * time.Second},
+		{"retry-after zero", 3, "0", 0, 0},
+		{"retry-after seconds over the cap", 0, "3600", 30 * time.Second, 30 * time.Second},
+		{"retry-after seconds that overflow", 0, "9223372036854775807", 30 * time.Second, 30 * time.Second},
+		{"retry-after date", 0, time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 8 * time.Second, 10 * time.Second},
+		{"retry-after date over the cap", 0, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 30 * time.Second, 30 * time.Second},
+		{"retry-after date in the past", 0, time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+		{"exponential first attempt", 0, "", 500 * time.Millisecond, time.Second},
+		{"exponential third attempt", 2, "", 2 * time.Second, 4 * time.Second},
+		{"exponential capped", 10, "", 15 * time.Second, 30 * time.Second},
+		{"exponential overflow", 80, "", 15 * time.Second, 30 * time.Second},
+		{"negative retry-after ignored", 0, "-5", 500 * time.Millisecond, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.backoff(tt.attempt, tt.retryAfter); got < tt.min || got > tt.max {
+				t.Errorf("backoff(%d, %q) = %v, want between %v and %v", tt.attempt, tt.retryAfter, got, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -1,21 +1,90 @@
 package ai
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
+	"slices"
+	"strings"
 
+	"github.com/arnavsurve/promise/pkg/env"
 	"github.com/arnavsurve/promise/pkg/models"
 	"github.com/google/uuid"
 )
 
-func LLMDecompositionQuery(taskDescription string) ([]models.TaskResponse, error) {
-	apiKey := os.Getenv("GROQ_API_KEY")
-	url := "https://api.groq.com/openai/v1/chat/completions"
+// MaxDecompositionDepth reads MAX_DECOMPOSITION_DEPTH: how many levels of composite subtasks may be decomposed
+// below the top-level decomposition. 0 disables composite subtasks.
+func MaxDecompositionDepth() int {
+	return int(env.Int64("MAX_DECOMPOSITION_DEPTH", 2))
+}
+
+// compositeTypeNote is added to the decomposition prompt when the subtasks may themselves be decomposed
+const compositeTypeNote = `
+            composite (a subtask too large for one agent to do in one step; it is broken down again when it runs, so describe its goal fully),`
+
+// resourcesNote is added to the decomposition prompt when named resources are configured
+const resourcesNote = `
+
+        A subtask may list in "resources" the named resources it needs, which limit how many subtasks use them at once.
+        Only these resources exist: %s. Leave "resources" empty unless the subtask clearly uses one of them.`
+
+// LLMDecompositionQuery asks the LLM to break a task description into a graph of subtasks sharing a new task ID.
+// resources are the names of the configured resources subtasks may declare.
+func LLMDecompositionQuery(ctx context.Context, taskDescription string, resources []string) ([]models.TaskResponse, error) {
+	subtasks, err := decompose(ctx, taskDescription, MaxDecompositionDepth() > 0, resources)
+	if err != nil {
+		return nil, err
+	}
+
+	taskId := uuid.New()
+	var tasks []models.TaskResponse
+
+	for _, subtask := range subtasks {
+		// Convert []int dependencies to []Dependency
+		var dependencies []models.Dependency
+		for _, depId := range subtask.Dependencies {
+			dependencies = append(dependencies, models.Dependency{
+				TaskId:    taskId, // Share the same task_id generated above
+				SubtaskId: depId,
+			})
+		}
 
+		// Append task AFTER processing dependencies
+		tasks = append(tasks, models.TaskResponse{
+			TaskId:       taskId,
+			SubtaskId:    subtask.SubtaskId,
+			Type:         subtask.Type,
+			Description:  subtask.Description,
+			Dependencies: dependencies,
+			Resources:    subtask.Resources,
+			Status:       "pending",
+		})
+	}
+
+	return tasks, nil
+}
+
+// DecomposeSubtask breaks a composite subtask into a graph of children at run time, telling the LLM what its
+// dependencies handed over. Children may only be composite themselves if allowComposite is set.
+func DecomposeSubtask(ctx context.Context, task models.Task, inputs []Input, allowComposite bool, resources []string) ([]models.Subtask, error) {
+	description := task.Description
+	if len(inputs) > 0 {
+		description += "\n\nContext from the subtasks this one depends on:\n" + renderInputs(inputs)
+	}
+	return decompose(ctx, description, allowComposite, resources)
+}
+
+// decompose asks the LLM for the subtasks of a description. Resources the LLM names that are not among resources are
+// dropped, since nothing limits them.
+func decompose(ctx context.Context, taskDescription string, allowComposite bool, resources []string) ([]models.Subtask, error) {
+	compositeType := ""
+	if allowComposite {
+		compositeType = compositeTypeNote
+	}
+	resourceNote := ""
+	if len(resources) > 0 {
+		resourceNote = fmt.Sprintf(resourcesNote, strings.Join(resources, ", "))
+	}
 	prompt := fmt.Sprintf(`You are a task decomposition engine that outputs JSON. Break down the following task into an array of JSON formatted subtasks that individual AI agents can accomplish and integrate into a final solution:
 
         Task: "%s"
@@ -26,25 +95,29 @@ func LLMDecompositionQuery(taskDescription string) ([]models.TaskResponse, error
                 "subtask_id": 1
 	            "description": "Subtask description",
 	            "type": "code_generation",
-	            "dependencies": []
+	            "dependencies": [],
+	            "resources": []
 	        }
             {
                 "subtask_id": 2
 	            "description": "Subtask description",
 	            "type": "code_generation",
-	            "dependencies": [1]
+	            "dependencies": [1],
+	            "resources": []
 	        }
             {
                 "subtask_id": 3
 	            "description": "Subtask description",
 	            "type": "command_execution",
-	            "dependencies": [1, 2]
+	            "dependencies": [1, 2],
+	            "resources": []
 	        }
             {
                 "subtask_id": 4
 	            "description": "Subtask description",
 	            "type": "command_execution",
-	            "dependencies": [1, 2, 3]
+	            "dependencies": [1, 2, 3],
+	            "resources": []
 	        }
 	    ]
 
@@ -53,7 +126,7 @@ func LLMDecompositionQuery(taskDescription string) ([]models.TaskResponse, error
         If the task can be accomplished in one shell command, only output one subtask describing what needs to be done.
 
         Note that a subtask can only be of type:
-            command_execution (a task involving shell commands to be run),
+            command_execution (a task involving shell commands to be run),%s
             code_generation (generating code),
             prose_generation (generating, editing, or combining plaintext NOT CODE). 
 
@@ -61,92 +134,32 @@ func LLMDecompositionQuery(taskDescription string) ([]models.TaskResponse, error
         Code generation will create a file automatically and handoff the file location and execution instructions to the next dependent task. Do not create a subtask for saving generated code to a file.
 
         Dependencies are determined by which subtasks are required to be complete before work begins on the dependent subtask.
-        Assign this based on what best fits the subtask.
-
-	    Only output valid JSON as per the expected output denoted above.`, taskDescription)
-
-	requestData := RequestPayload{
-		Model: "llama-3.3-70b-versatile",
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-		ResponseFormat: map[string]string{"type": "json_object"},
-	}
-
-	jsonData, err := json.Marshal(requestData)
-	if err != nil {
-		return nil, err
-	}
-
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+        Assign this based on what best fits the subtask.%s
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+	    Only output valid JSON as per the expected output denoted above.`, taskDescription, compositeType, resourceNote)
 
-	var groqResponse GroqResponse
-	err = json.Unmarshal(body, &groqResponse)
+	subtasksJSONString, err := completeJSON(ctx, prompt)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(groqResponse.Choices) == 0 {
-		return nil, fmt.Errorf("LLM response was unsuccessful")
-	}
-
-	subtasksJSONString := groqResponse.Choices[0].Message.Content
-
-	// Parse response JSON string into TaskResponse struct
+	// Parse response JSON string into subtasks
 	var taskResponse struct {
-		Subtasks []struct {
-			SubtaskId    int    `json:"subtask_id"`
-			Description  string `json:"description"`
-			Type         string `json:"type"`
-			Dependencies []int  `json:"dependencies"`
-		} `json:"subtasks"`
+		Subtasks []models.Subtask `json:"subtasks"`
 	}
 
 	err = json.Unmarshal([]byte(subtasksJSONString), &taskResponse)
 	if err != nil {
 		return nil, err
 	}
-
-	taskId := uuid.New()
-	var tasks []models.TaskResponse
-
-	for _, subtask := range taskResponse.Subtasks {
-		// Convert []int dependencies to []Dependency
-		var dependencies []models.Dependency
-		for _, depId := range subtask.Dependencies {
-			dependencies = append(dependencies, models.Dependency{
-				TaskId:    taskId, // Share the same task_id generated above
-				SubtaskId: depId,
-			})
+	for i, subtask := range taskResponse.Subtasks {
+		known := subtask.Resources[:0]
+		for _, resource := range subtask.Resources {
+			if slices.Contains(resources, resource) && !slices.Contains(known, resource) {
+				known = append(known, resource)
+			}
 		}
-
-		// Append task AFTER processing dependencies
-		tasks = append(tasks, models.TaskResponse{
-			TaskId:       taskId,
-			SubtaskId:    subtask.SubtaskId,
-			Type:         subtask.Type,
-			Description:  subtask.Description,
-			Dependencies: dependencies,
-			Status:       "pending",
-		})
+		taskResponse.Subtasks[i].Resources = known
 	}
-
-	return tasks, nil
+	return taskResponse.Subtasks, nil
 }
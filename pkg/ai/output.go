@@ -0,0 +1,144 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workflow"
+	"github.com/arnavsurve/promise/pkg/workspace"
+	"github.com/google/uuid"
+)
+
+// maxExtractResult bounds how much of a subtask's result is shown to the LLM when extracting its structured output
+const maxExtractResult = 8000
+
+// Input is what one dependency handed to a subtask. Inputs are always ordered by subtask ID.
+type Input struct {
+	TaskId    uuid.UUID       `json:"task_id"`
+	SubtaskId int             `json:"subtask_id"`
+	Name      string          `json:"name,omitempty"`
+	Context   string          `json:"context"`             // Plain-text handoff for LLM prompts
+	Output    json.RawMessage `json:"output,omitempty"`    // Structured output, validated against the dependency's schema
+	Artifacts []string        `json:"artifacts,omitempty"` // Files the dependency produced, staged read-only, relative to the subtask's workspace
+}
+
+// renderInputs formats inputs for a prompt, one dependency after another
+func renderInputs(inputs []Input) string {
+	var rendered strings.Builder
+	for _, input := range inputs {
+		fmt.Fprintf(&rendered, "%s:%d: %s\n", input.TaskId, input.SubtaskId, input.Context)
+		if len(input.Output) > 0 {
+			fmt.Fprintf(&rendered, "Structured output of %s:%d: %s\n", input.TaskId, input.SubtaskId, input.Output)
+		}
+		if len(input.Artifacts) > 0 {
+			fmt.Fprintf(&rendered, "Files produced by %s:%d: %s\n", input.TaskId, input.SubtaskId, strings.Join(input.Artifacts, ", "))
+		}
+	}
+	return rendered.String()
+}
+
+// commandEnv passes inputs to shell commands as JSON in PROMISE_INPUTS, and the subtask's workspace, which is also
+// their working directory, in PROMISE_WORKSPACE
+func commandEnv(task models.Task, inputs []Input) []string {
+	var env []string
+	if inputs == nil {
+		inputs = []Input{}
+	}
+	if inputsJSON, err := json.Marshal(inputs); err == nil {
+		env = append(env, "PROMISE_INPUTS="+string(inputsJSON))
+	}
+	if dir, err := workspace.SubtaskDir(task.Tenant, task.TaskId, task.SubtaskId); err == nil {
+		env = append(env, "PROMISE_WORKSPACE="+dir)
+	}
+	return env
+}
+
+// declaredArtifacts resolves the artifacts a subtask declares to paths in its workspace. A declared artifact the
+// subtask did not produce, or that is not a regular file, is an error: a symlink could otherwise smuggle files from
+// outside the workspace into the artifact store.
+func declaredArtifacts(task models.Task) ([]string, error) {
+	if len(task.Artifacts) == 0 {
+		return nil, nil
+	}
+	dir, err := workspace.SubtaskDir(task.Tenant, task.TaskId, task.SubtaskId)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(task.Artifacts))
+	for _, artifact := range task.Artifacts {
+		path := filepath.Join(dir, artifact)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return nil, fmt.Errorf("declared artifact %s was not produced", artifact)
+		}
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("declared artifact %s is not a regular file", artifact)
+		}
+		// A symlinked parent directory would also lead outside the workspace
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+			return nil, fmt.Errorf("declared artifact %s resolves outside the workspace", artifact)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// structureOutput produces the structured output of a finished subtask that declares an output schema. Output that is
+// already JSON matching the schema is used as-is; otherwise the LLM extracts a value from the subtask's result.
+func structureOutput(ctx context.Context, task models.Task, result Result) (json.RawMessage, error) {
+	schema, err := workflow.CompileSchema(task.OutputSchema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output schema: %v", err)
+	}
+
+	if output := strings.TrimSpace(result.Output); json.Valid([]byte(output)) {
+		if err := workflow.ValidateOutput(schema, json.RawMessage(output)); err == nil {
+			return json.RawMessage(output), nil
+		}
+	}
+
+	schemaJSON, _ := json.Marshal(task.OutputSchema)
+	resultText := result.Context
+	if len(resultText) > maxExtractResult {
+		resultText = resultText[:maxExtractResult] + "..."
+	}
+	prompt := fmt.Sprintf(`You are extracting the structured result of a finished subtask.
+
+Subtask: %s
+
+Result:
+%s
+
+Return a JSON object with a single field "output" whose value conforms to this JSON schema:
+%s
+
+Only use information present in the result. Only output valid JSON.`, task.Description, resultText, schemaJSON)
+
+	content, err := completeJSON(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	var extracted struct {
+		Output json.RawMessage `json:"output"`
+	}
+	if err := json.Unmarshal([]byte(content), &extracted); err != nil {
+		return nil, err
+	}
+	if err := workflow.ValidateOutput(schema, extracted.Output); err != nil {
+		return nil, fmt.Errorf("output does not match the schema: %v", err)
+	}
+	return extracted.Output, nil
+}
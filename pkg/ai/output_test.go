@@ -0,0 +1,85 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/arnavsurve/promise/pkg/models"
+)
+
+// roundTripFunc serves LLM requests in-process
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// fakeLLM makes the shared client answer every completion with reply, or fail the test if reply is empty.
+// It returns a pointer to the number of requests made.
+func fakeLLM(t *testing.T, reply string) *int {
+	t.Helper()
+	calls := 0
+	saved := client
+	client = &llmClient{
+		http: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			calls++
+			if reply == "" {
+				t.Error("unexpected LLM request")
+			}
+			body, _ := json.Marshal(GroqResponse{Model: defaultModel, Choices: []Choice{{Message: MessageResponse{Role: "assistant", Content: reply}}}})
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(string(body)))}, nil
+		})},
+		cfg: ClientConfig{MaxRetries: 0},
+	}
+	t.Cleanup(func() { client = saved })
+	return &calls
+}
+
+func TestStructureOutput(t *testing.T) {
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           map[string]interface{}{"count": map[string]interface{}{"type": "integer"}},
+		"required":             []interface{}{"count"},
+		"additionalProperties": false,
+	}
+	tests := []struct {
+		name      string
+		schema    map[string]interface{}
+		output    string
+		llmReply  string // Empty when the LLM must not be asked
+		want      string
+		wantErr   string
+		wantCalls int
+	}{
+		{name: "matching output is used as-is", schema: schema, output: ` {"count": 3} `, want: `{"count": 3}`},
+		{name: "prose is extracted", schema: schema, output: "found 3 files", llmReply: `{"output": {"count": 3}}`, want: `{"count": 3}`, wantCalls: 1},
+		{name: "non-matching JSON is extracted", schema: schema, output: `{"count": "3"}`, llmReply: `{"output": {"count": 3}}`, want: `{"count": 3}`, wantCalls: 1},
+		{name: "extraction that does not match fails", schema: schema, output: "three", llmReply: `{"output": {"count": "three"}}`, wantErr: "does not match the schema", wantCalls: 1},
+		{name: "extraction with extra fields fails", schema: schema, output: "3", llmReply: `{"output": {"count": 3, "files": []}}`, wantErr: "does not match the schema", wantCalls: 1},
+		{name: "extraction that is not JSON fails", schema: schema, output: "3", llmReply: `not json`, wantErr: "invalid character", wantCalls: 1},
+		{name: "invalid schema fails", schema: map[string]interface{}{"type": "bogus"}, output: `{"count": 3}`, wantErr: "invalid output schema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeLLM(t, tt.llmReply)
+			task := models.Task{Description: "count files", OutputSchema: tt.schema}
+			got, err := structureOutput(context.Background(), task, Result{Output: tt.output, Context: tt.output})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("structureOutput() error = %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("structureOutput() error = %v", err)
+			} else if string(got) != tt.want {
+				t.Errorf("structureOutput() = %s, want %s", got, tt.want)
+			}
+			if *calls != tt.wantCalls {
+				t.Errorf("LLM asked %d times, want %d", *calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -1,51 +1,131 @@
 package ai
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 
+	"github.com/arnavsurve/promise/pkg/logging"
 	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/tracing"
+	"github.com/arnavsurve/promise/pkg/workspace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func ProcessTask(task models.Task, depsContext map[string]string) (string, error) {
+// Result is what a subtask hands to its dependents
+type Result struct {
+	Context    string          // Documentation of the subtask for the next worker's prompt
+	Output     string          // Raw command output, or the path of the generated file
+	Structured json.RawMessage // Value matching the subtask's output schema, if it declares one
+	Artifacts  []string        // Files the subtask produced, to be registered in the artifact store
+}
+
+// ProcessTask runs a subtask with the inputs its dependencies handed over. Cancelling ctx aborts any in-flight LLM
+// request or shell command. Subtasks that declare an output schema fail if no matching structured output can be produced,
+// and subtasks that declare artifacts fail if one of them is missing.
+func ProcessTask(ctx context.Context, task models.Task, inputs []Input) (Result, error) {
+	var result Result
+	var err error
 	switch task.Type {
 	case "command_execution":
-		return processCommand(task, depsContext)
+		result, err = processCommand(ctx, task, inputs)
 	case "code_generation":
-		return processCodeGeneration(task, depsContext)
+		result, err = processCodeGeneration(ctx, task, inputs)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	declared, err := declaredArtifacts(task)
+	if err != nil {
+		return Result{}, err
+	}
+	result.Artifacts = append(result.Artifacts, declared...)
+	if task.OutputSchema == nil {
+		return result, nil
 	}
-	return "", nil
+
+	result.Structured, err = structureOutput(ctx, task, result)
+	if err != nil {
+		return Result{}, fmt.Errorf("structured output: %v", err)
+	}
+	return result, nil
+}
+
+// commandEnvAllowlist names the variables of the server's environment a subtask command inherits. Everything else,
+// such as ADMIN_API_KEY or ARTIFACT_S3_SECRET_KEY, stays out of reach of generated commands and their output.
+var commandEnvAllowlist = []string{"PATH", "HOME", "USER", "LANG", "LC_ALL", "LC_CTYPE", "TZ", "TMPDIR", "TERM"}
+
+// baseEnv returns the allowlisted variables of the server's environment and any PROMISE_* variables
+func baseEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.Contains(commandEnvAllowlist, name) || strings.HasPrefix(name, "PROMISE_") {
+			env = append(env, kv)
+		}
+	}
+	return env
 }
 
-// executeCommand runs a shell command and returns the result
-func executeCommand(command string, args []string) (string, error) {
-	cmd := exec.Command(command, args...)
+// executeCommand runs a shell command in dir with env added to an allowlisted environment and returns the result
+func executeCommand(ctx context.Context, command string, args []string, dir string, env []string) (string, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "exec", trace.WithAttributes(attribute.String("exec.command", logging.Redact("exec.command", command))))
+	defer span.End()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = dir
+	cmd.Env = append(baseEnv(), env...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "command failed")
 		return "", fmt.Errorf("command execution failed: %v, output: %s", err, string(output))
 	}
 	return string(output), err
 }
 
-func processCommand(task models.Task, depsContext map[string]string) (string, error) {
-	apiKey := os.Getenv("GROQ_API_KEY")
-	url := "https://api.groq.com/openai/v1/chat/completions"
+func processCommand(ctx context.Context, task models.Task, inputs []Input) (Result, error) {
+	// Commands run inside the subtask's own workspace
+	dirPath, err := workspace.SubtaskDir(task.Tenant, task.TaskId, task.SubtaskId)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to resolve workspace directory: %v", err)
+	}
 
-	// Construct dependency context from handoff
-	depsInfo := ""
-	for i, context := range depsContext {
-		depsInfo += fmt.Sprintf("%s: %s\n", i, context)
+	// Workflow steps may give the command explicitly, in which case no LLM is involved
+	if task.Command != "" {
+		logging.FromContext(ctx).Info("Executing workflow command", "command", task.Command)
+		output, err := executeCommand(ctx, "sh", []string{"-c", task.Command}, dirPath, commandEnv(task, inputs))
+		if err != nil {
+			return Result{}, fmt.Errorf("error executing command: %v", err)
+		}
+		return Result{Context: fmt.Sprintf("Command Output:\n%s", output), Output: output}, nil
+	}
+
+	// A reviewer approved this exact command after it matched a risk rule
+	if approved := task.ApprovedCommand; approved != nil {
+		logging.FromContext(ctx).Info("Executing approved command", "command", approved.Command, "args", approved.Args)
+		output, err := executeCommand(ctx, approved.Command, approved.Args, dirPath, commandEnv(task, inputs))
+		if err != nil {
+			return Result{}, fmt.Errorf("error executing command: %v", err)
+		}
+		return Result{Context: fmt.Sprintf("%s\nCommand Output:\n%s", approved.Context, output), Output: output}, nil
 	}
 
+	// Construct dependency context from handoff
+	depsInfo := renderInputs(inputs)
+
 	prompt := fmt.Sprintf(`You are an intelligent command execution agent.
 Your task is to convert the following task description into a safe, valid Bash command,
-and to produce a "context" documentation that provides key information from this subtask that will be useful for handoff to subsequent agents. When creating the command, be sure to use the absolute path.
+and to produce a "context" documentation that provides key information from this subtask that will be useful for handoff to subsequent agents.
+The command runs in this subtask's own working directory, %s. Create files there using relative paths. Files produced by dependencies are available read-only at the relative paths listed below.
 
 Dependency Context from previous workers:
 %s
@@ -60,96 +140,57 @@ Return a JSON object with the following fields:
 Example:
 {
   "command": "ls",
-  "args": ["-l", "/home/user"],
-  "context": "Lists all files with detailed info in /home/user"
-}`, depsInfo, task.Description)
-
-	requestData := RequestPayload{
-		Model: "llama-3.3-70b-versatile",
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-		ResponseFormat: map[string]string{"type": "json_object"},
-	}
-
-	jsonData, err := json.Marshal(requestData)
-	if err != nil {
-		return "", err
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+  "args": ["-lR", "inputs"],
+  "context": "Lists the files staged from dependencies with detailed info"
+}`, dirPath, depsInfo, task.Description)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	content, err := completeJSON(ctx, prompt)
 	if err != nil {
-		return "", err
+		return Result{}, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	var groqResponse GroqResponse
-	err = json.Unmarshal(body, &groqResponse)
+	var cmdResp CommandResponse
+	err = json.Unmarshal([]byte(content), &cmdResp)
 	if err != nil {
-		return "", err
+		return Result{}, err
 	}
 
-	if len(groqResponse.Choices) == 0 {
-		return "", fmt.Errorf("LLM response was unsuccessful")
-	}
-
-	var cmdResp CommandResponse
-	err = json.Unmarshal([]byte(groqResponse.Choices[0].Message.Content), &cmdResp)
-	if err != nil {
-		return "", err
+	// Hold commands that match a risk rule until a reviewer approves them
+	if rules := MatchRiskRules(cmdResp.Command, cmdResp.Args, dirPath); len(rules) > 0 {
+		return Result{}, &ApprovalRequiredError{
+			Command: models.GeneratedCommand{Command: cmdResp.Command, Args: cmdResp.Args, Context: cmdResp.Context},
+			Rules:   rules,
+		}
 	}
 
-	log.Printf("Original command: %s %v", cmdResp.Command, cmdResp.Args)
+	logging.FromContext(ctx).Info("Executing generated command", "command", cmdResp.Command, "args", cmdResp.Args)
 
 	// Execute the command
-	output, err := executeCommand(cmdResp.Command, cmdResp.Args)
+	output, err := executeCommand(ctx, cmdResp.Command, cmdResp.Args, dirPath, commandEnv(task, inputs))
 	if err != nil {
-		return "", fmt.Errorf("error executing command: %v", err)
+		return Result{}, fmt.Errorf("error executing command: %v", err)
 	}
 
 	// Combine LLM generated context with command output
 	combinedContext := fmt.Sprintf("%s\nCommand Output:\n%s", cmdResp.Context, output)
-	log.Printf("Passing context: %s\n", combinedContext)
+	logging.FromContext(ctx).Debug("Passing context", "context", combinedContext)
 
-	return combinedContext, nil
+	return Result{Context: combinedContext, Output: output}, nil
 }
 
-func processCodeGeneration(task models.Task, depsContext map[string]string) (string, error) {
-	apiKey := os.Getenv("GROQ_API_KEY")
-	url := "https://api.groq.com/openai/v1/chat/completions"
-
+func processCodeGeneration(ctx context.Context, task models.Task, inputs []Input) (Result, error) {
 	// Construct dependency context from handoff
-	depsInfo := ""
-	for i, context := range depsContext {
-		depsInfo += fmt.Sprintf("%s: %s\n", i, context)
-	}
+	depsInfo := renderInputs(inputs)
 
-	// Construct the absolute path using the task ID and promise base directory
-	userHome, err := os.UserHomeDir()
+	// Generated files are saved in the subtask's own workspace
+	dirPath, err := workspace.SubtaskDir(task.Tenant, task.TaskId, task.SubtaskId)
 	if err != nil {
-		return "", fmt.Errorf("failed to get user home directory: %v", err)
+		return Result{}, fmt.Errorf("failed to resolve workspace directory: %v", err)
 	}
-	dirPath := fmt.Sprintf("%s/promise/%s/", userHome, task.TaskId)
 
 	prompt := fmt.Sprintf(`You are an intelligent code generation agent.
 Your task is to generate executable code based on the following task description.
-        The code should be safe and self-contained. Produce a "context" documentation that provides key information from this subtask that will be useful for handoff to subsequent agents. Make sure to refer to any files created using the absolute path: %s when writing the context documentation. When writing instructions, ensure you refer to the file with the name you have selected for it, rather than a placeholder.
+        The code should be safe and self-contained. Produce a "context" documentation that provides key information from this subtask that will be useful for handoff to subsequent agents. The file is saved in this subtask's own working directory; dependents receive it read-only at inputs/%d/<filename> in theirs. When writing instructions, ensure you refer to the file with the name you have selected for it, rather than a placeholder.
 
 Dependency context from previous workers:
 %s
@@ -166,85 +207,34 @@ Example:
     "code": "#!/bin/bash\necho 'Hello, World!'",
     "filename": "filename.sh",
     "context": "Generates a bash script that prints Hello, World!"
-}`, depsInfo, dirPath, task.Description)
-
-	requestData := RequestPayload{
-		Model: "llama-3.3-70b-versatile",
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-		ResponseFormat: map[string]string{"type": "json_object"},
-	}
-
-	jsonData, err := json.Marshal(requestData)
-	if err != nil {
-		return "", err
-	}
+}`, task.SubtaskId, depsInfo, task.Description)
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	content, err := completeJSON(ctx, prompt)
 	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	var groqResponse GroqResponse
-	err = json.Unmarshal(body, &groqResponse)
-	if err != nil {
-		return "", err
-	}
-
-	if len(groqResponse.Choices) == 0 {
-		var groqError GroqErrorResponse
-		err = json.Unmarshal(body, &groqError)
-		if err != nil {
-			return "", err
-		}
-		fmt.Println(groqError)
-		return "", fmt.Errorf("LLM response was unsuccessful")
+		return Result{}, err
 	}
 
 	var codeResp CodeResponse
-	err = json.Unmarshal([]byte(groqResponse.Choices[0].Message.Content), &codeResp)
+	err = json.Unmarshal([]byte(content), &codeResp)
 	if err != nil {
-		return "", err
+		return Result{}, err
 	}
 
-	// Define the directory where code will be saved.
-	// We use task.TaskId to create a unique folder per task
-	userHome, err = os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("failed to get user home directory: %v", err)
-	}
-	dirPath = fmt.Sprintf("%s/promise/%s/", userHome, task.TaskId)
+	// Save the code in the subtask's workspace
 	if err = os.MkdirAll(dirPath, 0755); err != nil {
-		return "", fmt.Errorf("failed to create directory: %v", err)
+		return Result{}, fmt.Errorf("failed to create directory: %v", err)
 	}
 
-	// Define the file path for generated code.
-	filePath := dirPath + codeResp.Filename
+	// Define the file path for generated code. Only the base name is used so the file cannot escape the workspace.
+	filePath := filepath.Join(dirPath, filepath.Base(codeResp.Filename))
 	if err := os.WriteFile(filePath, []byte(codeResp.Code), 0755); err != nil {
-		return "", fmt.Errorf("failed to write code to file: %v", err)
+		return Result{}, fmt.Errorf("failed to write code to file: %v", err)
 	}
 
 	// Combine the location information with the LLM-generated context.
-	combinedContext := fmt.Sprintf("Code generated and saved to %s.\n%s", filePath, codeResp.Context)
-	log.Printf("Passing context: %s\n", combinedContext)
+	combinedContext := fmt.Sprintf("Code generated and saved as %s.\n%s", filepath.Base(filePath), codeResp.Context)
+	logging.FromContext(ctx).Info("Code generated", "file", filePath)
+	logging.FromContext(ctx).Debug("Passing context", "context", combinedContext)
 
-	return combinedContext, nil
+	return Result{Context: combinedContext, Output: filePath, Artifacts: []string{filePath}}, nil
 }
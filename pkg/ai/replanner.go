@@ -0,0 +1,97 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/arnavsurve/promise/pkg/models"
+)
+
+// maxReplanResult bounds how much of each subtask's result is shown to the replanner
+const maxReplanResult = 1500
+
+// GraphNode is a subtask as the replanner sees it
+type GraphNode struct {
+	SubtaskId    int
+	Type         string
+	Description  string
+	Status       string
+	Dependencies []int
+	Result       string // Context handed over by a completed subtask
+}
+
+// Revision is the LLM's replacement for a task's remaining subtasks
+type Revision struct {
+	Rationale string           `json:"rationale"`
+	Subtasks  []models.Subtask `json:"subtasks"`
+}
+
+// ReplanQuery asks the LLM to revise the subtasks of a task that have not started, given what the task is meant to
+// achieve and how its graph has gone so far. New subtasks are numbered from nextId.
+func ReplanQuery(ctx context.Context, description string, reason string, graph []GraphNode, nextId int, allowComposite bool) (*Revision, error) {
+	var state strings.Builder
+	for _, node := range graph {
+		fmt.Fprintf(&state, "- subtask %d (%s, %s): %s\n", node.SubtaskId, node.Type, node.Status, node.Description)
+		if len(node.Dependencies) > 0 {
+			fmt.Fprintf(&state, "  depends on: %v\n", node.Dependencies)
+		}
+		if result := node.Result; result != "" {
+			if len(result) > maxReplanResult {
+				result = result[:maxReplanResult] + "..."
+			}
+			fmt.Fprintf(&state, "  result: %s\n", strings.ReplaceAll(result, "\n", "\n    "))
+		}
+	}
+	if reason == "" {
+		reason = "not given"
+	}
+	compositeType := ""
+	if allowComposite {
+		compositeType = compositeTypeNote
+	}
+
+	prompt := fmt.Sprintf(`You are a task planning engine that outputs JSON. A task was decomposed into subtasks run by individual AI agents, but the plan may no longer fit what has happened. Revise it.
+
+        Task: "%s"
+
+        Reason for replanning: %s
+
+        Current subtasks:
+%s
+        Subtasks that are pending or skipped have not started and will be discarded; return whatever should run in their place.
+        Completed and running subtasks stay as they are, and new subtasks may depend on them by their subtask_id.
+        Failed subtasks stay failed; the subtasks you return are expected to work around or redo them.
+        Number new subtasks from %d upwards. Return an empty subtasks array if nothing more needs to be done.
+
+        Expected JSON output format:
+        {
+            "rationale": "Why the plan changed",
+            "subtasks": [
+                {
+                    "subtask_id": %d,
+                    "description": "Subtask description",
+                    "type": "command_execution",
+                    "dependencies": [1]
+                }
+            ]
+        }
+
+        Note that a subtask can only be of type:
+            command_execution (a task involving shell commands to be run),%s
+            code_generation (generating code).
+
+        Only output valid JSON as per the expected output denoted above.`, description, reason, state.String(), nextId, nextId, compositeType)
+
+	content, err := completeJSON(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	var revision Revision
+	if err := json.Unmarshal([]byte(content), &revision); err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
@@ -0,0 +1,347 @@
+package ai
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/arnavsurve/promise/pkg/models"
+)
+
+// ruleOutsideWorkspace is the built-in rule that inspects paths rather than matching a pattern
+const ruleOutsideWorkspace = "write_outside_workspace"
+
+// builtinRiskPatterns are the pattern rules enabled unless RISK_RULES says otherwise
+var builtinRiskPatterns = map[string]string{
+	"package_install": `\b(apt-get|apt|yum|dnf|apk|zypper|brew|pip3?|pipx|npm|yarn|pnpm|gem|cargo|conda)\s+(\S+\s+)*?(install|add)\b|\bgo\s+(install|get)\b`,
+	"network_tool":    `\b(curl|wget|nc|ncat|netcat|socat|ssh|scp|sftp|rsync|telnet|ftp|nmap)\b`,
+}
+
+// writeCommands modify the paths given to them
+var writeCommands = []string{"rm", "rmdir", "mv", "cp", "tee", "touch", "mkdir", "chmod", "chown", "ln", "dd", "install", "truncate", "shred"}
+
+type riskRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// Every built-in rule applies until LoadRiskRulesFromEnv runs
+var (
+	riskMu         sync.RWMutex
+	riskRules      = builtinRiskRules()
+	checkWorkspace = true
+)
+
+func builtinRiskRules() []riskRule {
+	rules := make([]riskRule, 0, len(builtinRiskPatterns))
+	for name, pattern := range builtinRiskPatterns {
+		rules = append(rules, riskRule{name: name, pattern: regexp.MustCompile(pattern)})
+	}
+	return rules
+}
+
+// LoadRiskRulesFromEnv configures which generated commands need approval before they run.
+// RISK_RULES lists the built-in rules to enable (package_install, network_tool, write_outside_workspace), "all" by
+// default or "none"; each RISK_PATTERN_<NAME>=regexp adds a custom rule named <name>.
+func LoadRiskRulesFromEnv() error {
+	enabled := strings.TrimSpace(os.Getenv("RISK_RULES"))
+	if enabled == "" {
+		enabled = "all"
+	}
+
+	var rules []riskRule
+	workspace := false
+	for _, name := range strings.Split(enabled, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "none" || name == "":
+		case name == "all":
+			workspace = true
+			rules = append(rules, builtinRiskRules()...)
+		case name == ruleOutsideWorkspace:
+			workspace = true
+		case builtinRiskPatterns[name] != "":
+			rules = append(rules, riskRule{name: name, pattern: regexp.MustCompile(builtinRiskPatterns[name])})
+		default:
+			return fmt.Errorf("RISK_RULES: unknown rule %q", name)
+		}
+	}
+
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		name, found := strings.CutPrefix(key, "RISK_PATTERN_")
+		if !found || name == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		rules = append(rules, riskRule{name: strings.ToLower(name), pattern: pattern})
+	}
+
+	riskMu.Lock()
+	riskRules, checkWorkspace = rules, workspace
+	riskMu.Unlock()
+	return nil
+}
+
+// MatchRiskRules returns the names of the risk rules a command matches. workspaceDir is the only directory
+// the command may write to without approval.
+func MatchRiskRules(command string, args []string, workspaceDir string) []string {
+	riskMu.RLock()
+	rules, workspace := riskRules, checkWorkspace
+	riskMu.RUnlock()
+
+	line := strings.TrimSpace(command + " " + strings.Join(args, " "))
+	var matched []string
+	for _, rule := range rules {
+		if rule.pattern.MatchString(line) {
+			matched = append(matched, rule.name)
+		}
+	}
+	if workspace && writesOutside(line, workspaceDir) {
+		matched = append(matched, ruleOutsideWorkspace)
+	}
+	slices.Sort(matched)
+	return matched
+}
+
+// writesOutside reports whether a command line writes to a path that is not inside dir: the target of an output
+// redirection, or a destination operand of a write command. Paths it only reads, such as the source of cp, are not
+// checked. Relative paths resolve against the working directory and are not flagged, unless they climb out with "..".
+func writesOutside(line string, dir string) bool {
+	home, _ := os.UserHomeDir()
+	for _, command := range parseCommandLine(line) {
+		for _, path := range writeTargets(command) {
+			if outsideDir(path, dir, home) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shellCommand is one simple command of a command line
+type shellCommand struct {
+	words   []string
+	outputs []string // Files its output is redirected to
+	inputs  []string // Files its input is redirected from
+}
+
+// parseCommandLine splits a command line into simple commands at ;, &, |, newlines and parentheses, and separates
+// redirections from words. Quotes group a word but are otherwise not interpreted.
+func parseCommandLine(line string) []shellCommand {
+	var commands []shellCommand
+	var current shellCommand
+	var word strings.Builder
+	inWord := false
+	var target *[]string // Where the next word goes when it is the file of a redirection
+
+	endWord := func() {
+		if !inWord {
+			return
+		}
+		if target != nil {
+			*target = append(*target, word.String())
+			target = nil
+		} else {
+			current.words = append(current.words, word.String())
+		}
+		word.Reset()
+		inWord = false
+	}
+	endCommand := func() {
+		endWord()
+		if len(current.words) > 0 || len(current.outputs) > 0 {
+			commands = append(commands, current)
+		}
+		current = shellCommand{}
+		target = nil
+	}
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != c {
+				end++
+			}
+			word.WriteString(string(runes[i+1 : min(end, len(runes))]))
+			inWord = true
+			i = end
+		case c == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case c == ' ' || c == '\t':
+			endWord()
+		case c == '&' && i+1 < len(runes) && runes[i+1] == '>':
+			endWord() // &> redirects both stdout and stderr
+		case c == ';' || c == '&' || c == '|' || c == '\n' || c == '(' || c == ')':
+			endCommand()
+		case c == '>':
+			// Digits right before > name the descriptor being redirected, as in 2>errors.log
+			if inWord && strings.Trim(word.String(), "0123456789") == "" {
+				word.Reset()
+				inWord = false
+			}
+			endWord()
+			if i+1 < len(runes) && (runes[i+1] == '>' || runes[i+1] == '|') {
+				i++
+			}
+			if i+1 < len(runes) && runes[i+1] == '&' {
+				// >&2 and 2>&1 duplicate a descriptor rather than naming a file
+				j := i + 2
+				for j < len(runes) && (('0' <= runes[j] && runes[j] <= '9') || runes[j] == '-') {
+					j++
+				}
+				if j > i+2 {
+					i = j - 1
+					continue
+				}
+				i++
+			}
+			target = &current.outputs
+		case c == '<':
+			endWord()
+			for i+1 < len(runes) && (runes[i+1] == '<' || runes[i+1] == '&' || runes[i+1] == '>') {
+				i++
+			}
+			target = &current.inputs
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	endCommand()
+	return commands
+}
+
+// commandPrefixes run the command that follows them
+var commandPrefixes = []string{"sudo", "env", "nohup", "time", "nice", "exec", "command", "xargs"}
+
+// shells run the script given with -c
+var shells = []string{"sh", "bash", "zsh", "dash"}
+
+// writeTargets lists the paths a simple command writes to: its output redirections and the destination operands of the
+// write command it runs. cp, mv, install and ln write to their last operand (or -t), dd to of=, sed -i to its files, and
+// the other write commands to every operand.
+func writeTargets(command shellCommand) []string {
+	targets := append([]string{}, command.outputs...)
+
+	words := command.words
+	for len(words) > 0 && (isAssignment(words[0]) || slices.Contains(commandPrefixes, filepath.Base(words[0]))) {
+		words = words[1:]
+		for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+			words = words[1:] // Options of the prefix, e.g. sudo -E
+		}
+	}
+	if len(words) == 0 {
+		return targets
+	}
+	name := filepath.Base(words[0])
+	if slices.Contains(shells, name) {
+		// The script of sh -c arrives split into words when the command line was joined from arguments
+		if i := slices.Index(words, "-c"); i > 0 {
+			for _, inner := range parseCommandLine(strings.Join(words[i+1:], " ")) {
+				targets = append(targets, writeTargets(inner)...)
+			}
+		}
+		return targets
+	}
+	if !slices.Contains(writeCommands, name) && name != "sed" {
+		return targets
+	}
+
+	var operands []string
+	targetDir := ""
+	directories, inPlace, script := false, false, false
+	destination := name == "cp" || name == "mv" || name == "install" || name == "ln"
+	for i := 1; i < len(words); i++ {
+		word := words[i]
+		switch {
+		case destination && word == "-t" && i+1 < len(words):
+			i++
+			targetDir = words[i]
+		case destination && strings.HasPrefix(word, "--target-directory="):
+			targetDir = strings.TrimPrefix(word, "--target-directory=")
+		case name == "install" && word == "-d":
+			directories = true
+		case name == "sed" && (strings.HasPrefix(word, "-i") || strings.HasPrefix(word, "--in-place")):
+			inPlace = true
+		case name == "sed" && (word == "-e" || word == "-f") && i+1 < len(words):
+			i++
+			script = true
+		case strings.HasPrefix(word, "-") && word != "-":
+		default:
+			operands = append(operands, word)
+		}
+	}
+
+	switch {
+	case targetDir != "":
+		return append(targets, targetDir)
+	case destination && !directories:
+		if len(operands) >= 2 {
+			targets = append(targets, operands[len(operands)-1])
+		}
+	case name == "dd":
+		for _, operand := range operands {
+			if path, found := strings.CutPrefix(operand, "of="); found {
+				targets = append(targets, path)
+			}
+		}
+	case name == "sed":
+		if inPlace && !script && len(operands) > 0 {
+			operands = operands[1:] // The script
+		}
+		if inPlace {
+			targets = append(targets, operands...)
+		}
+	default:
+		targets = append(targets, operands...)
+	}
+	return targets
+}
+
+// isAssignment reports whether word sets an environment variable for the command after it, as in LANG=C sort
+func isAssignment(word string) bool {
+	name, _, found := strings.Cut(word, "=")
+	return found && name != "" && !strings.ContainsAny(name, "-/.")
+}
+
+// outsideDir reports whether path is absolute, home-relative or climbs with "..", and does not lie inside dir.
+// The null device and the standard streams are always allowed.
+func outsideDir(path string, dir string, home string) bool {
+	switch {
+	case path == "~" || strings.HasPrefix(path, "~/"):
+		path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+	case strings.HasPrefix(path, "/"):
+	case path == ".." || strings.HasPrefix(path, "../") || strings.Contains(path, "/../") || strings.HasSuffix(path, "/.."):
+		return true
+	default:
+		return false
+	}
+	if path == os.DevNull || path == "/dev/stdout" || path == "/dev/stderr" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, filepath.Clean(path))
+	return err != nil || rel == ".." || strings.HasPrefix(rel, "../")
+}
+
+// ApprovalRequiredError stops a subtask whose generated command matched a risk rule. The command is not run.
+type ApprovalRequiredError struct {
+	Command models.GeneratedCommand
+	Rules   []string
+}
+
+func (e *ApprovalRequiredError) Error() string {
+	return fmt.Sprintf("command requires approval, matched risk rules: %s", strings.Join(e.Rules, ", "))
+}
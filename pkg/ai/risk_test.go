@@ -0,0 +1,50 @@
+package ai
+
+import "testing"
+
+func TestWritesOutside(t *testing.T) {
+	dir := "/home/promise/promise-artifacts/default/task/1"
+	tests := []struct {
+		line string
+		want bool
+	}{
+		// Reading outside the workspace is fine
+		{"cat /etc/hosts > hosts.txt", false},
+		{"ls /usr 2>&1", false},
+		{"cp /usr/share/dict/words .", false},
+		{"grep -r TODO /usr/src 2>/dev/null | tee todo.txt", false},
+		{"ln -s /usr/bin/python3 python", false},
+		{"sed -i 's/a/b/' notes.txt", false},
+		{"sed -n '/start/,/end/p' /etc/services", false},
+		{"dd if=/dev/zero of=blank.img bs=1M count=1", false},
+		{"echo done >&2", false},
+		{"touch " + dir + "/out.txt", false},
+		{"bash -c cat /etc/passwd > users.txt", false},
+
+		// Redirection targets
+		{"cat notes.txt > /etc/motd", true},
+		{"echo x >>/var/log/app.log", true},
+		{"make 2> ../build.log", true},
+		{"echo x &> ~/out.txt", true},
+		{`echo "a;b" > "/tmp/x y"`, true},
+
+		// Destination operands of write commands
+		{"cp notes.txt /etc/notes.txt", true},
+		{"mv a.txt b.txt ../", true},
+		{"cp -t /opt a.txt b.txt", true},
+		{"install -d /usr/local/promise", true},
+		{"rm -rf /var/lib/data", true},
+		{"sudo -E chown root /srv", true},
+		{"mkdir -p build && touch /tmp/stamp", true},
+		{"dd if=image.iso of=/dev/sda", true},
+		{"sed -i -e 's/a/b/' /etc/hosts", true},
+		{"find . -name x | xargs rm /etc/x", true},
+		{"bash -c rm -rf /etc", true},
+		{"LANG=C tee /etc/issue", true},
+	}
+	for _, tt := range tests {
+		if got := writesOutside(tt.line, dir); got != tt.want {
+			t.Errorf("writesOutside(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
@@ -26,9 +26,18 @@ type Choice struct {
 	Message MessageResponse `json:"message"`
 }
 
+// UsageResponse is the token accounting block returned with every completion
+type UsageResponse struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
 // GroqResponse represents the full AI API response
 type GroqResponse struct {
-	Choices []Choice `json:"choices"`
+	Model   string        `json:"model"`
+	Choices []Choice      `json:"choices"`
+	Usage   UsageResponse `json:"usage"`
 }
 
 type GroqErrorResponse struct {
@@ -0,0 +1,142 @@
+package ai
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/google/uuid"
+)
+
+// Usage describes the token consumption and latency of one LLM call
+type Usage struct {
+	Model            string
+	PromptTokens     int64
+	CompletionTokens int64
+	Latency          time.Duration
+	Cost             float64
+}
+
+// UsageCollector gathers the usage of every LLM call made with a context returned by WithUsageCollector
+type UsageCollector struct {
+	mu    sync.Mutex
+	calls []Usage
+}
+
+// Calls returns the usage recorded so far
+func (c *UsageCollector) Calls() []Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Usage(nil), c.calls...)
+}
+
+// TotalTokens returns the prompt plus completion tokens across all recorded calls
+func (c *UsageCollector) TotalTokens() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := int64(0)
+	for _, call := range c.calls {
+		total += call.PromptTokens + call.CompletionTokens
+	}
+	return total
+}
+
+// TotalCost returns the summed cost of all recorded calls
+func (c *UsageCollector) TotalCost() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := 0.0
+	for _, call := range c.calls {
+		total += call.Cost
+	}
+	return total
+}
+
+type usageCollectorKey struct{}
+
+// WithUsageCollector returns a context whose LLM calls are recorded in the returned collector
+func WithUsageCollector(ctx context.Context) (context.Context, *UsageCollector) {
+	collector := &UsageCollector{}
+	return context.WithValue(ctx, usageCollectorKey{}, collector), collector
+}
+
+// recordUsage adds a call's usage to the collector attached to ctx, if any
+func recordUsage(ctx context.Context, usage Usage) {
+	collector, ok := ctx.Value(usageCollectorKey{}).(*UsageCollector)
+	if !ok {
+		return
+	}
+	collector.mu.Lock()
+	collector.calls = append(collector.calls, usage)
+	collector.mu.Unlock()
+}
+
+// Price is the USD cost per million prompt and completion tokens for a model
+type Price struct {
+	PromptPerMillion     float64
+	CompletionPerMillion float64
+}
+
+var (
+	pricesMu sync.RWMutex
+	prices   = map[string]Price{
+		"llama-3.3-70b-versatile": {PromptPerMillion: 0.59, CompletionPerMillion: 0.79},
+	}
+)
+
+// LoadPricesFromEnv merges LLM_PRICES="model=prompt:completion,..." (USD per million tokens) into the price table
+func LoadPricesFromEnv() {
+	pricesMu.Lock()
+	defer pricesMu.Unlock()
+
+	for _, entry := range strings.Split(os.Getenv("LLM_PRICES"), ",") {
+		model, rates, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			continue
+		}
+		promptRate, completionRate, found := strings.Cut(rates, ":")
+		if !found {
+			continue
+		}
+		promptPrice, err1 := strconv.ParseFloat(promptRate, 64)
+		completionPrice, err2 := strconv.ParseFloat(completionRate, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		prices[model] = Price{PromptPerMillion: promptPrice, CompletionPerMillion: completionPrice}
+	}
+}
+
+// Cost prices a call using the configured price table. Unknown models cost nothing.
+func Cost(model string, promptTokens, completionTokens int64) float64 {
+	pricesMu.RLock()
+	price, ok := prices[model]
+	pricesMu.RUnlock()
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price.PromptPerMillion + float64(completionTokens)*price.CompletionPerMillion) / 1e6
+}
+
+// UsageRecords converts collected calls into rows attributed to a subtask and client
+func UsageRecords(collector *UsageCollector, taskId uuid.UUID, subtaskId int, clientId string, purpose string) []models.LLMUsage {
+	var records []models.LLMUsage
+	for _, call := range collector.Calls() {
+		records = append(records, models.LLMUsage{
+			TaskId:           taskId,
+			SubtaskId:        subtaskId,
+			ClientId:         clientId,
+			Purpose:          purpose,
+			ModelName:        call.Model,
+			PromptTokens:     call.PromptTokens,
+			CompletionTokens: call.CompletionTokens,
+			LatencyMs:        call.Latency.Milliseconds(),
+			Cost:             call.Cost,
+		})
+	}
+	return records
+}
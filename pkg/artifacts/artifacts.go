@@ -0,0 +1,40 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned by Get for keys the backend does not hold
+var ErrNotFound = errors.New("artifact not found")
+
+// Backend stores the bytes of artifacts under opaque keys
+type Backend interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	Name() string
+}
+
+// Open returns the backend selected by ARTIFACT_BACKEND: "local" (default) or "s3"
+func Open() (Backend, error) {
+	switch backend := os.Getenv("ARTIFACT_BACKEND"); backend {
+	case "", "local":
+		return NewLocal(os.Getenv("ARTIFACT_DIR"))
+	case "s3":
+		return NewS3FromEnv()
+	default:
+		return nil, fmt.Errorf("unknown ARTIFACT_BACKEND %q, expected local or s3", backend)
+	}
+}
+
+// Key is where an artifact of a subtask is stored. name is the artifact's path relative to the task's workspace.
+func Key(tenant string, taskId uuid.UUID, subtaskId int, name string) string {
+	return path.Join(tenant, taskId.String(), fmt.Sprint(subtaskId), name)
+}
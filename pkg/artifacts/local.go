@@ -0,0 +1,87 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores artifacts as files under a directory on this host
+type Local struct {
+	Dir string
+}
+
+// NewLocal returns a local backend rooted at dir, ~/promise-artifacts when dir is empty
+func NewLocal(dir string) (*Local, error) {
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, "promise-artifacts")
+	}
+	return &Local{Dir: dir}, nil
+}
+
+func (l *Local) Name() string {
+	return "local"
+}
+
+// path resolves a key inside the backend's directory, refusing keys that would escape it
+func (l *Local) path(key string) (string, error) {
+	p := filepath.Join(l.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(l.Dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid artifact key %q", key)
+	}
+	return p, nil
+}
+
+// Put writes to a temporary file first so readers never see a partial artifact
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	dir := t.TempDir()
+	backend := &Local{Dir: dir}
+
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{"nested", "acme/task/1/out.txt", filepath.Join(dir, "acme", "task", "1", "out.txt"), false},
+		{"spaces and unicode", "acme/task/1/résumé final.md", filepath.Join(dir, "acme", "task", "1", "résumé final.md"), false},
+		{"leading slash stays inside", "/acme/out.txt", filepath.Join(dir, "acme", "out.txt"), false},
+		{"inner dot-dot stays inside", "acme/task/../out.txt", filepath.Join(dir, "acme", "out.txt"), false},
+		{"parent", "../out.txt", "", true},
+		{"escapes through a prefix", "acme/../../out.txt", "", true},
+		{"sibling with the same prefix", "../" + filepath.Base(dir) + "-other/out.txt", "", true},
+		{"empty", "", "", true},
+		{"root itself", ".", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := backend.path(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("path(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("path(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	backend := &Local{Dir: t.TempDir()}
+	key := "acme/task/1/build output/résumé.txt"
+
+	if err := backend.Put(ctx, key, strings.NewReader("first"), 5); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := backend.Put(ctx, key, strings.NewReader("second"), 6); err != nil {
+		t.Fatalf("Put() overwrite error = %v", err)
+	}
+
+	body, err := backend.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	got, _ := io.ReadAll(body)
+	body.Close()
+	if string(got) != "second" {
+		t.Errorf("Get() = %q, want %q", got, "second")
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(backend.Dir, "acme", "task", "1", "build output"))
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the artifact", len(entries))
+	}
+
+	if err := backend.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := backend.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete error = %v, want ErrNotFound", err)
+	}
+	if err := backend.Delete(ctx, key); err != nil {
+		t.Errorf("Delete() of a missing key error = %v, want nil", err)
+	}
+}
+
+func TestLocalRejectsEscapingKeys(t *testing.T) {
+	ctx := context.Background()
+	backend := &Local{Dir: t.TempDir()}
+	key := "../escaped.txt"
+
+	if err := backend.Put(ctx, key, strings.NewReader("x"), 1); err == nil {
+		t.Error("Put() of an escaping key succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(backend.Dir), "escaped.txt")); err == nil {
+		t.Error("Put() wrote outside the backend's directory")
+	}
+	if _, err := backend.Get(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of an escaping key error = %v, want an invalid key error", err)
+	}
+	if err := backend.Delete(ctx, key); err == nil {
+		t.Error("Delete() of an escaping key succeeded")
+	}
+}
@@ -0,0 +1,173 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// S3 stores artifacts in a bucket of an S3-compatible object store, e.g. AWS S3 or a local MinIO. Objects are
+// addressed path-style, which every S3-compatible store accepts, and requests are signed with AWS Signature V4.
+type S3 struct {
+	Endpoint  *url.URL // e.g. http://localhost:9000 for MinIO, https://s3.us-east-1.amazonaws.com for AWS
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+// NewS3FromEnv configures an S3 backend from ARTIFACT_S3_ENDPOINT, ARTIFACT_S3_BUCKET, ARTIFACT_S3_REGION,
+// ARTIFACT_S3_ACCESS_KEY and ARTIFACT_S3_SECRET_KEY
+func NewS3FromEnv() (*S3, error) {
+	endpoint, err := url.Parse(os.Getenv("ARTIFACT_S3_ENDPOINT"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("ARTIFACT_S3_ENDPOINT must be an http or https URL")
+	}
+	backend := &S3{
+		Endpoint:  endpoint,
+		Bucket:    os.Getenv("ARTIFACT_S3_BUCKET"),
+		Region:    os.Getenv("ARTIFACT_S3_REGION"),
+		AccessKey: os.Getenv("ARTIFACT_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("ARTIFACT_S3_SECRET_KEY"),
+		Client:    &http.Client{Timeout: 5 * time.Minute},
+	}
+	if backend.Bucket == "" {
+		return nil, fmt.Errorf("ARTIFACT_S3_BUCKET is required")
+	}
+	if backend.AccessKey == "" || backend.SecretKey == "" {
+		return nil, fmt.Errorf("ARTIFACT_S3_ACCESS_KEY and ARTIFACT_S3_SECRET_KEY are required")
+	}
+	if backend.Region == "" {
+		backend.Region = "us-east-1" // MinIO's default
+	}
+	return backend, nil
+}
+
+func (b *S3) Name() string {
+	return "s3"
+}
+
+func (b *S3) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	resp, err := b.do(ctx, http.MethodPut, key, body, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete succeeds for keys that do not exist, as S3 does
+func (b *S3) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request for an object and turns error statuses into errors
+func (b *S3) do(ctx context.Context, method string, key string, body io.Reader, size int64) (*http.Response, error) {
+	u := *b.Endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + b.Bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+
+	// An empty non-nil body would be sent chunked, which S3 refuses for PUT
+	if body != nil && size == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	b.sign(req, time.Now().UTC())
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature V4 headers to req. The payload is left unsigned so uploads can stream.
+func (b *S3) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD",
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := date + "/" + b.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.SecretKey), date)
+	key = hmacSHA256(key, b.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath percent-encodes everything but unreserved characters and slashes, as Signature V4 expects
+func escapePath(p string) string {
+	var escaped strings.Builder
+	for _, c := range []byte(p) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~', c == '/':
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,210 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is an in-memory bucket that checks every request's Signature V4 against its own computation
+type fakeS3 struct {
+	secretKey string
+	region    string
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	requests []recordedRequest
+}
+
+type recordedRequest struct {
+	method           string
+	uri              string
+	contentLength    string
+	transferEncoding []string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3) {
+	t.Helper()
+	fake := &fakeS3{secretKey: "secret", region: "us-test-1", objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	endpoint, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, &S3{
+		Endpoint:  endpoint,
+		Bucket:    "artifacts",
+		Region:    fake.region,
+		AccessKey: "access",
+		SecretKey: fake.secretKey,
+		Client:    server.Client(),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, recordedRequest{
+		method:           r.Method,
+		uri:              r.RequestURI,
+		contentLength:    r.Header.Get("Content-Length"),
+		transferEncoding: r.TransferEncoding,
+	})
+
+	if got, want := r.Header.Get("Authorization"), f.authorization(r); got != want {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "SignatureDoesNotMatch: got %q, want %q", got, want)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = body
+	case http.MethodGet:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// authorization recomputes the header a correctly signed request carries, using the path exactly as sent on the wire
+func (f *fakeS3) authorization(r *http.Request) string {
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) < 8 {
+		return "missing X-Amz-Date"
+	}
+	date := amzDate[:8]
+	uri, query, _ := strings.Cut(r.RequestURI, "?")
+
+	canonical := r.Method + "\n" + uri + "\n" + query + "\n" +
+		"host:" + r.Host + "\n" +
+		"x-amz-content-sha256:" + r.Header.Get("X-Amz-Content-Sha256") + "\n" +
+		"x-amz-date:" + amzDate + "\n\n" +
+		"host;x-amz-content-sha256;x-amz-date\n" +
+		r.Header.Get("X-Amz-Content-Sha256")
+	digest := sha256.Sum256([]byte(canonical))
+	scope := date + "/" + f.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+f.secretKey), date)
+	for _, part := range []string{f.region, "s3", "aws4_request"} {
+		key = mac(key, part)
+	}
+	return "AWS4-HMAC-SHA256 Credential=access/" + scope +
+		", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=" + hex.EncodeToString(mac(key, toSign))
+}
+
+func (f *fakeS3) last() recordedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[len(f.requests)-1]
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		key     string
+		wantURI string
+	}{
+		{"plain", "acme/task/1/out.txt", "/artifacts/acme/task/1/out.txt"},
+		{"spaces", "acme/task/1/build output/report final.txt", "/artifacts/acme/task/1/build%20output/report%20final.txt"},
+		{"unicode", "acme/task/1/résumé ☃.md", "/artifacts/acme/task/1/r%C3%A9sum%C3%A9%20%E2%98%83.md"},
+		{"reserved", "acme/task/1/a+b=c&d?.txt", "/artifacts/acme/task/1/a%2Bb%3Dc%26d%3F.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, backend := newFakeS3(t)
+			content := []byte("artifact " + tt.name)
+
+			if err := backend.Put(ctx, tt.key, bytes.NewReader(content), int64(len(content))); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if got := fake.last().uri; got != tt.wantURI {
+				t.Errorf("Put sent path %q, want %q", got, tt.wantURI)
+			}
+
+			body, err := backend.Get(ctx, tt.key)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			got, _ := io.ReadAll(body)
+			body.Close()
+			if !bytes.Equal(got, content) {
+				t.Errorf("Get() = %q, want %q", got, content)
+			}
+
+			if err := backend.Delete(ctx, tt.key); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, err := backend.Get(ctx, tt.key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get() after Delete error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestS3GetMissing(t *testing.T) {
+	_, backend := newFakeS3(t)
+	if _, err := backend.Get(context.Background(), "acme/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3DeleteMissing(t *testing.T) {
+	_, backend := newFakeS3(t)
+	if err := backend.Delete(context.Background(), "acme/missing"); err != nil {
+		t.Errorf("Delete() error = %v, want nil", err)
+	}
+}
+
+func TestS3PutEmpty(t *testing.T) {
+	fake, backend := newFakeS3(t)
+	if err := backend.Put(context.Background(), "acme/empty", strings.NewReader(""), 0); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	req := fake.last()
+	if req.contentLength != "0" {
+		t.Errorf("Content-Length = %q, want 0", req.contentLength)
+	}
+	if len(req.transferEncoding) != 0 {
+		t.Errorf("Transfer-Encoding = %v, want none", req.transferEncoding)
+	}
+}
+
+func TestS3ErrorStatus(t *testing.T) {
+	_, backend := newFakeS3(t)
+	backend.SecretKey = "wrong"
+	err := backend.Put(context.Background(), "acme/out.txt", strings.NewReader("x"), 1)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Put() with a bad signature error = %v, want a 403 error", err)
+	}
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Scopes grant access to groups of endpoints. Admin implies every other scope and sees every client's work.
+const (
+	ScopeSubmit    = "jobs:submit"     // Queue shell jobs
+	ScopeDecompose = "tasks:decompose" // Submit tasks for LLM decomposition
+	ScopeRead      = "read"            // Read the client's own jobs, tasks, budgets and costs
+	ScopeWebhooks  = "webhooks:manage" // Register and remove webhooks, redeliver events
+	ScopeApprove   = "plans:approve"   // Review plans and held commands, including those of other clients in the tenant
+	ScopeAdmin     = "admin"           // Manage keys, workers and drain mode; read all clients
+)
+
+// Scopes lists every valid scope
+var Scopes = []string{ScopeSubmit, ScopeDecompose, ScopeRead, ScopeWebhooks, ScopeApprove, ScopeAdmin}
+
+const (
+	keyPrefix = "pk_"
+	// touchInterval limits how often last_used_at is written for a busy key
+	touchInterval = time.Minute
+)
+
+var (
+	ErrInvalidKey   = errors.New("invalid API key")
+	ErrInvalidScope = errors.New("invalid scope")
+)
+
+// Principal is the authenticated client behind a request
+type Principal struct {
+	ClientId string
+	Tenant   string
+	KeyId    uint
+	Scopes   []string
+}
+
+// IsAdmin reports whether the principal can see and manage every client's work
+func (p Principal) IsAdmin() bool {
+	return slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+// HasScope reports whether the principal was granted scope, directly or through admin
+func (p Principal) HasScope(scope string) bool {
+	return p.IsAdmin() || slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated principal
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal attached by the auth middleware
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// hashKey returns the hex SHA-256 of a key. Keys are 256-bit random values, so a fast hash is sufficient.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitKey extracts the lookup prefix from a key of the form pk_<prefix>_<secret>
+func splitKey(key string) (prefix string, ok bool) {
+	rest, found := strings.CutPrefix(key, keyPrefix)
+	if !found {
+		return "", false
+	}
+	prefix, secret, found := strings.Cut(rest, "_")
+	if !found || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// ValidateScopes rejects unknown scopes
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return fmt.Errorf("%w %q, expected one of %s", ErrInvalidScope, scope, strings.Join(Scopes, ", "))
+		}
+	}
+	return nil
+}
+
+// CreateKey issues a new key for clientId in tenant. The returned secret is not stored and cannot be recovered.
+func CreateKey(s *db.Store, clientId string, tenant string, name string, scopes []string) (string, *models.APIKey, error) {
+	if err := ValidateScopes(scopes); err != nil {
+		return "", nil, err
+	}
+	prefix, err := randomHex(6)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := fmt.Sprintf("%s%s_%s", keyPrefix, prefix, secret)
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+	record := &models.APIKey{
+		ClientId: clientId,
+		Tenant:   tenant,
+		Name:     name,
+		Prefix:   prefix,
+		Hash:     hashKey(key),
+		Scopes:   scopes,
+	}
+	if err := s.DB.Create(record).Error; err != nil {
+		return "", nil, err
+	}
+	return key, record, nil
+}
+
+// Authenticate resolves a presented key to its principal. Unknown, malformed and revoked keys all return ErrInvalidKey.
+func Authenticate(s *db.Store, key string) (Principal, error) {
+	prefix, ok := splitKey(key)
+	if !ok {
+		return Principal{}, ErrInvalidKey
+	}
+
+	var record models.APIKey
+	if err := s.DB.Where("prefix = ? AND revoked_at IS NULL", prefix).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Principal{}, ErrInvalidKey
+		}
+		return Principal{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashKey(key)), []byte(record.Hash)) != 1 {
+		return Principal{}, ErrInvalidKey
+	}
+
+	now := time.Now().UTC()
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) > touchInterval {
+		s.DB.Model(&record).UpdateColumn("last_used_at", now)
+	}
+
+	tenant := record.Tenant
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+	return Principal{ClientId: record.ClientId, Tenant: tenant, KeyId: record.ID, Scopes: record.Scopes}, nil
+}
+
+// ListKeys returns the keys of clientId, or of every client when clientId is empty
+func ListKeys(s *db.Store, clientId string) ([]models.APIKey, error) {
+	query := s.DB.Order("id")
+	if clientId != "" {
+		query = query.Where("client_id = ?", clientId)
+	}
+	keys := []models.APIKey{}
+	err := query.Find(&keys).Error
+	return keys, err
+}
+
+// RevokeKey disables a key immediately. It returns gorm.ErrRecordNotFound for unknown or already revoked keys.
+func RevokeKey(s *db.Store, id uint) error {
+	result := s.DB.Model(&models.APIKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now().UTC())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// EnsureBootstrapKey registers key as an admin key for client "admin" if it is not stored yet.
+// It lets an operator configure the first key through ADMIN_API_KEY and create the rest over the API.
+func EnsureBootstrapKey(s *db.Store, key string) error {
+	prefix, ok := splitKey(key)
+	if !ok {
+		return fmt.Errorf("%w: expected the form %s<prefix>_<secret>", ErrInvalidKey, keyPrefix)
+	}
+
+	var existing models.APIKey
+	err := s.DB.Where("prefix = ?", prefix).First(&existing).Error
+	if err == nil {
+		if existing.Hash != hashKey(key) {
+			return fmt.Errorf("a different key with prefix %q already exists", prefix)
+		}
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return s.DB.Create(&models.APIKey{
+		ClientId: "admin",
+		Tenant:   models.DefaultTenant,
+		Name:     "bootstrap",
+		Prefix:   prefix,
+		Hash:     hashKey(key),
+		Scopes:   []string{ScopeAdmin},
+	}).Error
+}
@@ -2,10 +2,11 @@ package db
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
 
+	"github.com/arnavsurve/promise/pkg/artifacts"
 	"github.com/arnavsurve/promise/pkg/models"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/driver/postgres"
@@ -14,12 +15,15 @@ import (
 )
 
 type Store struct {
-	DB  *gorm.DB
-	Rdb *redis.Client
+	DB        *gorm.DB
+	Rdb       *redis.Client
+	Log       *slog.Logger
+	Artifacts artifacts.Backend
 }
 
-// NewStore returns a struct with a gorm Postgres client and redis client
-func NewStore() (*Store, error) {
+// NewStore returns a struct with a gorm Postgres client, redis client, artifact backend and the logger shared by
+// handlers and workers
+func NewStore(log *slog.Logger) (*Store, error) {
 	host := os.Getenv("DB_HOST")
 	port, _ := strconv.Atoi(os.Getenv("DB_PORT"))
 	user := os.Getenv("DB_USER")
@@ -35,21 +39,64 @@ func NewStore() (*Store, error) {
 		return nil, err
 	}
 
-	fmt.Println("DB connection successful")
+	log.Info("DB connection successful", "host", host, "db", dbname)
 
 	rdb := redis.NewClient(&redis.Options{
 		Addr: "localhost:6379",
 	})
 
+	backend, err := artifacts.Open()
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Artifact backend configured", "backend", backend.Name())
+
 	return &Store{
-		DB:  db,
-		Rdb: rdb,
+		DB:        db,
+		Rdb:       rdb,
+		Log:       log,
+		Artifacts: backend,
 	}, nil
 }
 
 func (s *Store) InitJobsTable() {
-	err := s.DB.AutoMigrate(&models.Job{}, &models.Task{})
+	err := s.DB.AutoMigrate(&models.Job{}, &models.Task{}, &models.LLMUsage{}, &models.TaskBudget{}, &models.APIKey{}, &models.Tenant{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.Plan{}, &models.CommandApproval{}, &models.PlanVersion{}, &models.Artifact{})
 	if err != nil {
-		log.Fatalf("Error creating accounts table: %v", err)
+		s.Log.Error("Error creating tables", "error", err)
+		os.Exit(1)
+	}
+}
+
+// SaveLLMUsage persists usage rows for LLM calls
+func (s *Store) SaveLLMUsage(records []models.LLMUsage) error {
+	if len(records) == 0 {
+		return nil
 	}
+	return s.DB.Create(&records).Error
+}
+
+// UsageReport rolls LLM usage up by "task" or "client", optionally restricted to a single task or client
+func (s *Store) UsageReport(groupBy string, taskId string, clientId string) ([]models.UsageReport, error) {
+	column := "task_id"
+	if groupBy == "client" {
+		column = "client_id"
+	}
+
+	query := s.DB.Model(&models.LLMUsage{}).Select(fmt.Sprintf(`%s::text AS key,
+		COUNT(*) AS calls,
+		SUM(prompt_tokens) AS prompt_tokens,
+		SUM(completion_tokens) AS completion_tokens,
+		SUM(prompt_tokens + completion_tokens) AS total_tokens,
+		AVG(latency_ms) AS avg_latency_ms,
+		SUM(cost) AS cost`, column))
+	if taskId != "" {
+		query = query.Where("task_id = ?", taskId)
+	}
+	if clientId != "" {
+		query = query.Where("client_id = ?", clientId)
+	}
+
+	var reports []models.UsageReport
+	err := query.Group(column).Order("cost DESC").Scan(&reports).Error
+	return reports, err
 }
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or does not match the requested sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListFilter narrows, orders and pages a job or task listing. Zero values mean "no filter".
+type ListFilter struct {
+	Status        string
+	Type          string // Tasks only
+	TaskId        string // Tasks only
+	ClientId      string // Owning API client
+	Tenant        string
+	Search        string // Substring of the job command or task description
+	Tags          []string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	SortBy        string // "created_at" (default), "updated_at" or "id"
+	Order         string // "desc" (default) or "asc"
+	Cursor        string // Opaque cursor returned as next_cursor by the previous page
+	Limit         int
+}
+
+// pageCursor is the position of the last row on a page: its sort value plus id as a tie-breaker
+type pageCursor struct {
+	SortBy string    `json:"s"`
+	Value  time.Time `json:"v"`
+	Id     uint      `json:"id"`
+}
+
+func encodeCursor(c pageCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(cursor string) (pageCursor, error) {
+	var c pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// escapeLike makes a user-supplied substring safe to use inside an ILIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// applyListFilter adds the filters shared by jobs and tasks. searchColumn is matched against Search.
+func applyListFilter(query *gorm.DB, f ListFilter, searchColumn string) *gorm.DB {
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+	if f.ClientId != "" {
+		query = query.Where("client_id = ?", f.ClientId)
+	}
+	if f.Tenant != "" {
+		query = query.Where("tenant = ?", f.Tenant)
+	}
+	if f.Search != "" {
+		query = query.Where(fmt.Sprintf("%s ILIKE ?", searchColumn), "%"+escapeLike(f.Search)+"%")
+	}
+	if len(f.Tags) > 0 {
+		tags, _ := json.Marshal(f.Tags)
+		query = query.Where("tags @> ?::jsonb", string(tags))
+	}
+	if !f.CreatedAfter.IsZero() {
+		query = query.Where("created_at >= ?", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", f.CreatedBefore)
+	}
+	return query
+}
+
+// paginate applies keyset pagination on (sort column, id) and fetches one page into dest.
+// It returns the cursor for the next page, or "" when this is the last page.
+func paginate[T any](query *gorm.DB, f ListFilter, dest *[]T, position func(T) (time.Time, time.Time, uint)) (string, error) {
+	sortBy := f.SortBy
+	if sortBy == "" {
+		sortBy = "created_at"
+	}
+	if sortBy != "created_at" && sortBy != "updated_at" && sortBy != "id" {
+		return "", fmt.Errorf("unsupported sort field %q", sortBy)
+	}
+	direction, comparison := "DESC", "<"
+	if f.Order == "asc" {
+		direction, comparison = "ASC", ">"
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil || c.SortBy != sortBy {
+			return "", ErrInvalidCursor
+		}
+		if sortBy == "id" {
+			query = query.Where(fmt.Sprintf("id %s ?", comparison), c.Id)
+		} else {
+			query = query.Where(fmt.Sprintf("(%s, id) %s (?, ?)", sortBy, comparison), c.Value, c.Id)
+		}
+	}
+
+	if sortBy == "id" {
+		query = query.Order("id " + direction)
+	} else {
+		query = query.Order(fmt.Sprintf("%s %s, id %s", sortBy, direction, direction))
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	if err := query.Limit(limit + 1).Find(dest).Error; err != nil {
+		return "", err
+	}
+	if len(*dest) <= limit {
+		return "", nil
+	}
+
+	*dest = (*dest)[:limit]
+	createdAt, updatedAt, id := position((*dest)[limit-1])
+	next := pageCursor{SortBy: sortBy, Id: id}
+	switch sortBy {
+	case "created_at":
+		next.Value = createdAt
+	case "updated_at":
+		next.Value = updatedAt
+	}
+	return encodeCursor(next), nil
+}
+
+// ListJobs returns one page of shell jobs matching f, and the cursor of the next page
+func (s *Store) ListJobs(f ListFilter) ([]models.Job, string, error) {
+	query := applyListFilter(s.DB.Model(&models.Job{}), f, "command")
+
+	jobs := []models.Job{}
+	next, err := paginate(query, f, &jobs, func(j models.Job) (time.Time, time.Time, uint) {
+		return j.CreatedAt, j.UpdatedAt, j.ID
+	})
+	return jobs, next, err
+}
+
+// ListTasks returns one page of subtasks matching f, and the cursor of the next page
+func (s *Store) ListTasks(f ListFilter) ([]models.Task, string, error) {
+	query := applyListFilter(s.DB.Model(&models.Task{}), f, "description")
+	if f.Type != "" {
+		query = query.Where("type = ?", f.Type)
+	}
+	if f.TaskId != "" {
+		query = query.Where("task_id = ?", f.TaskId)
+	}
+
+	tasks := []models.Task{}
+	next, err := paginate(query, f, &tasks, func(t models.Task) (time.Time, time.Time, uint) {
+		return t.CreatedAt, t.UpdatedAt, t.ID
+	})
+	return tasks, next, err
+}
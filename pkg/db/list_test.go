@@ -0,0 +1,129 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dryRun returns a handle that builds SQL without connecting to a database
+func dryRun(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("opening dry-run database: %v", err)
+	}
+	return db
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []pageCursor{
+		{SortBy: "created_at", Value: time.Date(2026, 3, 1, 12, 30, 0, 123456000, time.UTC), Id: 42},
+		{SortBy: "updated_at", Value: time.Date(2025, 12, 31, 23, 59, 59, 0, time.UTC), Id: 1},
+		{SortBy: "id", Id: 7},
+	}
+	for _, want := range tests {
+		got, err := decodeCursor(encodeCursor(want))
+		if err != nil {
+			t.Fatalf("decodeCursor(encodeCursor(%+v)): %v", want, err)
+		}
+		if got.SortBy != want.SortBy || !got.Value.Equal(want.Value) || got.Id != want.Id {
+			t.Errorf("round trip of %+v = %+v", want, got)
+		}
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "!!!"},
+		{"not json", "bm90IGpzb24"},
+		{"wrong shape", "WzEsMiwzXQ"}, // [1,2,3]
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor(%q) error = %v, want ErrInvalidCursor", tt.cursor, err)
+			}
+		})
+	}
+}
+
+func TestPaginateOrdering(t *testing.T) {
+	value := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		filter    ListFilter
+		wantOrder string
+		wantWhere string
+	}{
+		{"default", ListFilter{}, "ORDER BY created_at DESC, id DESC", ""},
+		{"updated ascending", ListFilter{SortBy: "updated_at", Order: "asc"}, "ORDER BY updated_at ASC, id ASC", ""},
+		{"id", ListFilter{SortBy: "id"}, "ORDER BY id DESC", ""},
+		{
+			"after a created_at cursor",
+			ListFilter{Cursor: encodeCursor(pageCursor{SortBy: "created_at", Value: value, Id: 9})},
+			"ORDER BY created_at DESC, id DESC", "(created_at, id) < (",
+		},
+		{
+			"after an ascending updated_at cursor",
+			ListFilter{SortBy: "updated_at", Order: "asc", Cursor: encodeCursor(pageCursor{SortBy: "updated_at", Value: value, Id: 9})},
+			"ORDER BY updated_at ASC, id ASC", "(updated_at, id) > (",
+		},
+		{
+			"after an id cursor",
+			ListFilter{SortBy: "id", Order: "asc", Cursor: encodeCursor(pageCursor{SortBy: "id", Id: 9})},
+			"ORDER BY id ASC", "id > ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := dryRun(t).Model(&models.Job{})
+			var jobs []models.Job
+			if _, err := paginate(query, tt.filter, &jobs, func(j models.Job) (time.Time, time.Time, uint) {
+				return j.CreatedAt, j.UpdatedAt, j.ID
+			}); err != nil {
+				t.Fatalf("paginate: %v", err)
+			}
+			sql := query.Statement.SQL.String()
+			if !strings.Contains(sql, tt.wantOrder) {
+				t.Errorf("SQL %q does not contain %q", sql, tt.wantOrder)
+			}
+			if tt.wantWhere != "" && !strings.Contains(sql, tt.wantWhere) {
+				t.Errorf("SQL %q does not contain %q", sql, tt.wantWhere)
+			}
+			vars := query.Statement.Vars
+			if limit := vars[len(vars)-1]; limit != DefaultPageSize+1 {
+				t.Errorf("LIMIT %v, want one row past the default page", limit)
+			}
+		})
+	}
+}
+
+func TestPaginateRejectsBadRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter ListFilter
+	}{
+		{"garbage cursor", ListFilter{Cursor: "!!!"}},
+		{"cursor for another sort", ListFilter{SortBy: "updated_at", Cursor: encodeCursor(pageCursor{SortBy: "created_at", Id: 1})}},
+		{"unsupported sort", ListFilter{SortBy: "command"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var jobs []models.Job
+			if _, err := paginate(dryRun(t).Model(&models.Job{}), tt.filter, &jobs, func(j models.Job) (time.Time, time.Time, uint) {
+				return j.CreatedAt, j.UpdatedAt, j.ID
+			}); err == nil {
+				t.Error("paginate accepted the request")
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/arnavsurve/promise/pkg/workers"
+)
+
+// GetDrainStatus reports whether this node is draining and how many workers are still running on it
+func GetDrainStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(drainStatusResponse{
+			Draining:      workers.IsDraining(),
+			ActiveWorkers: workers.ActiveWorkers(),
+		})
+	}
+}
+
+type drainStatusResponse struct {
+	Draining      bool  `json:"draining"`
+	ActiveWorkers int32 `json:"active_workers"`
+}
+
+// drainRequest is the body accepted by POST /v1/admin/drain
+type drainRequest struct {
+	Drain *bool `json:"drain"`
+}
+
+func (req *drainRequest) validate() error {
+	if req.Drain == nil {
+		return invalidField("drain", `expected {"drain": true|false}`)
+	}
+	return nil
+}
+
+// SetDrainMode puts this node into drain mode, or takes it out again with {"drain": false}.
+// A draining node rejects new submissions, spawns no workers, and lets its workers exit after their current subtask.
+func SetDrainMode() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req drainRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		workers.SetDraining(*req.Drain)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(drainStatusResponse{
+			Draining:      workers.IsDraining(),
+			ActiveWorkers: workers.ActiveWorkers(),
+		})
+	}
+}
+
+// RejectWhenDraining wraps a submission handler so a draining node turns new work away
+func RejectWhenDraining(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if workers.IsDraining() {
+			w.Header().Set("Retry-After", "30")
+			writeError(w, "Node is draining, not accepting new work", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
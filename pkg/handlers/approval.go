@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/arnavsurve/promise/pkg/auth"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workers"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type approvalListResponse struct {
+	Approvals []models.CommandApproval `json:"approvals"`
+}
+
+// ownedApproval loads the command approval in the {id} path segment if the caller owns it or may review it, writing an
+// error otherwise
+func ownedApproval(s *db.Store, w http.ResponseWriter, r *http.Request) (*models.CommandApproval, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "Invalid approval id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var approval models.CommandApproval
+	if err := restrictToReviewer(r, s.DB).Where("id = ?", id).First(&approval).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, "Approval not found", http.StatusNotFound)
+		} else {
+			s.Log.Error("Failed to fetch approval", "approval_id", id, "error", err)
+			writeError(w, "Failed to fetch approval", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return &approval, true
+}
+
+// ListApprovals returns the held commands the caller owns or may review, newest first. Query params: status, task_id, limit (default 50).
+func ListApprovals(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := restrictToReviewer(r, s.DB)
+		params := r.URL.Query()
+
+		limit := db.DefaultPageSize
+		if value := params.Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > db.MaxPageSize {
+				writeError(w, "limit must be between 1 and 200", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		if value := params.Get("task_id"); value != "" {
+			taskId, err := uuid.Parse(value)
+			if err != nil {
+				writeError(w, "Invalid task_id", http.StatusBadRequest)
+				return
+			}
+			query = query.Where("task_id = ?", taskId)
+		}
+		if status := params.Get("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+
+		approvals := []models.CommandApproval{}
+		if err := query.Order("id DESC").Limit(limit).Find(&approvals).Error; err != nil {
+			s.Log.Error("Failed to list approvals", "error", err)
+			writeError(w, "Failed to list approvals", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(approvalListResponse{Approvals: approvals})
+	}
+}
+
+// GetApproval returns a held command with the risk rules it matched
+func GetApproval(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		approval, ok := ownedApproval(s, w, r)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(approval)
+	}
+}
+
+// reviewApproval applies review to an approval the caller may review and replies with the result. Unless allowSelf is
+// set, only an admin may review a command held for their own client.
+func reviewApproval(s *db.Store, allowSelf bool, review func(s *db.Store, approvalId uint, reviewer string, comment string) (*models.CommandApproval, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		approval, ok := ownedApproval(s, w, r)
+		if !ok {
+			return
+		}
+		req, ok := decodeReview(w, r)
+		if !ok {
+			return
+		}
+		principal, _ := auth.FromContext(r.Context())
+		if !allowSelf && principal.ClientId == approval.ClientId && !principal.IsAdmin() {
+			writeAPIError(w, APIError{Code: "self_approval", Message: "Commands held for your own client must be approved by another reviewer"}, http.StatusForbidden)
+			return
+		}
+
+		reviewed, err := review(s, approval.ID, principal.ClientId, req.Comment)
+		if err != nil {
+			if errors.Is(err, workers.ErrApprovalClosed) {
+				writeError(w, "Command is no longer awaiting approval", http.StatusConflict)
+				return
+			}
+			s.Log.Error("Failed to review command", "approval_id", approval.ID, "error", err)
+			writeError(w, "Failed to review command", http.StatusInternalServerError)
+			return
+		}
+		s.Log.Info("Command reviewed", "approval_id", reviewed.ID, "task_id", reviewed.TaskId, "subtask_id", reviewed.SubtaskId,
+			"status", reviewed.Status, "reviewer", principal.ClientId)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reviewed)
+	}
+}
+
+// ApproveCommand lets a held subtask run its generated command. The client that submitted it cannot approve it.
+func ApproveCommand(s *db.Store) http.HandlerFunc {
+	return reviewApproval(s, false, workers.ApproveCommand)
+}
+
+// RejectCommand stops a held subtask for good
+func RejectCommand(s *db.Store) http.HandlerFunc {
+	return reviewApproval(s, true, workers.RejectCommand)
+}
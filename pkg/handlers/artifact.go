@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/arnavsurve/promise/pkg/artifacts"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"gorm.io/gorm"
+)
+
+type artifactListResponse struct {
+	Artifacts []models.Artifact `json:"artifacts"`
+}
+
+// ListArtifacts returns the files a task's subtasks produced, by subtask and name
+func ListArtifacts(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskId, err := taskIdParam(r)
+		if err != nil {
+			writeError(w, "Invalid task id", http.StatusBadRequest)
+			return
+		}
+		if !requireTaskOwner(s, w, r, taskId) {
+			return
+		}
+
+		query := s.DB.Where("task_id = ?", taskId)
+		if subtask := r.URL.Query().Get("subtask_id"); subtask != "" {
+			subtaskId, err := strconv.Atoi(subtask)
+			if err != nil {
+				writeError(w, "Invalid subtask_id", http.StatusBadRequest)
+				return
+			}
+			query = query.Where("subtask_id = ?", subtaskId)
+		}
+		found := []models.Artifact{}
+		if err := query.Order("subtask_id, name").Find(&found).Error; err != nil {
+			s.Log.Error("Failed to list artifacts", "task_id", taskId, "error", err)
+			writeError(w, "Failed to list artifacts", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(artifactListResponse{Artifacts: found})
+	}
+}
+
+// DownloadArtifact streams the content of one of a task's artifacts from the artifact store
+func DownloadArtifact(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskId, err := taskIdParam(r)
+		if err != nil {
+			writeError(w, "Invalid task id", http.StatusBadRequest)
+			return
+		}
+		id, err := strconv.ParseUint(r.PathValue("artifact"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid artifact id", http.StatusBadRequest)
+			return
+		}
+		if !requireTaskOwner(s, w, r, taskId) {
+			return
+		}
+
+		var artifact models.Artifact
+		if err := s.DB.Where("task_id = ?", taskId).First(&artifact, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeError(w, "Artifact not found", http.StatusNotFound)
+			} else {
+				s.Log.Error("Failed to fetch artifact", "task_id", taskId, "artifact_id", id, "error", err)
+				writeError(w, "Failed to fetch artifact", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		body, err := s.Artifacts.Get(r.Context(), artifact.Key)
+		if err != nil {
+			if errors.Is(err, artifacts.ErrNotFound) {
+				writeError(w, "Artifact content is missing from the store", http.StatusGone)
+			} else {
+				s.Log.Error("Failed to read artifact", "task_id", taskId, "artifact_id", id, "error", err)
+				writeError(w, "Failed to read artifact", http.StatusBadGateway)
+			}
+			return
+		}
+		defer body.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(artifact.Name)))
+		w.Header().Set("X-Artifact-Sha256", artifact.SHA256)
+		if _, err := io.Copy(w, body); err != nil {
+			s.Log.Warn("Artifact download interrupted", "task_id", taskId, "artifact_id", id, "error", err)
+		}
+	}
+}
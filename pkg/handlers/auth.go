@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/arnavsurve/promise/pkg/auth"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workers"
+	"gorm.io/gorm"
+)
+
+// presentedKey reads the API key from "Authorization: Bearer <key>" or the X-API-Key header
+func presentedKey(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// RequireScope authenticates the request's API key and checks it was granted scope before calling next
+func RequireScope(s *db.Store, scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := presentedKey(r)
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="promise"`)
+			writeError(w, "Missing API key", http.StatusUnauthorized)
+			return
+		}
+
+		principal, err := auth.Authenticate(s, key)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidKey) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="promise", error="invalid_token"`)
+				writeError(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			s.Log.Error("Failed to authenticate API key", "error", err)
+			writeError(w, "Failed to authenticate API key", http.StatusInternalServerError)
+			return
+		}
+
+		if !principal.HasScope(scope) {
+			writeAPIError(w, APIError{Code: "insufficient_scope", Message: "API key lacks the " + scope + " scope"}, http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	}
+}
+
+// ownerScope returns the client whose work the caller may see, and false when the caller is an admin who may see all
+func ownerScope(r *http.Request) (string, bool) {
+	principal, _ := auth.FromContext(r.Context())
+	if principal.IsAdmin() {
+		return "", false
+	}
+	return principal.ClientId, true
+}
+
+// restrictToOwner limits query to rows owned by the caller, unless the caller is an admin
+func restrictToOwner(r *http.Request, query *gorm.DB) *gorm.DB {
+	if clientId, restricted := ownerScope(r); restricted {
+		return query.Where("client_id = ?", clientId)
+	}
+	return query
+}
+
+// restrictToReviewer limits query to rows the caller may review: any row in its tenant when it holds plans:approve,
+// its own rows otherwise, and every row for an admin
+func restrictToReviewer(r *http.Request, query *gorm.DB) *gorm.DB {
+	principal, _ := auth.FromContext(r.Context())
+	if principal.IsAdmin() {
+		return query
+	}
+	if principal.HasScope(auth.ScopeApprove) {
+		return query.Where("tenant = ?", principal.Tenant)
+	}
+	return query.Where("client_id = ?", principal.ClientId)
+}
+
+// createKeyRequest is the body accepted by POST /v1/admin/keys
+type createKeyRequest struct {
+	ClientId string   `json:"client_id"`
+	Tenant   string   `json:"tenant,omitempty"` // "default" when omitted
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+}
+
+func (req *createKeyRequest) validate() error {
+	if strings.TrimSpace(req.ClientId) == "" {
+		return invalidField("client_id", "client_id cannot be empty")
+	}
+	if req.Tenant != "" {
+		if err := workers.ValidateTenantName(req.Tenant); err != nil {
+			return invalidField("tenant", "%s", err)
+		}
+	}
+	if err := auth.ValidateScopes(req.Scopes); err != nil {
+		return invalidField("scopes", "%s", err)
+	}
+	return nil
+}
+
+type createKeyResponse struct {
+	Key    string        `json:"key"` // Shown only once
+	APIKey models.APIKey `json:"api_key"`
+}
+
+type keyListResponse struct {
+	Keys []models.APIKey `json:"keys"`
+}
+
+// CreateAPIKey issues a key for a client. The full key is only ever returned in this response.
+func CreateAPIKey(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req createKeyRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		key, record, err := auth.CreateKey(s, req.ClientId, req.Tenant, req.Name, req.Scopes)
+		if err != nil {
+			s.Log.Error("Failed to create API key", "client_id", req.ClientId, "error", err)
+			writeError(w, "Failed to create API key", http.StatusInternalServerError)
+			return
+		}
+		s.Log.Info("API key created", "client_id", record.ClientId, "tenant", record.Tenant, "key_id", record.ID, "scopes", record.Scopes)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(createKeyResponse{Key: key, APIKey: *record})
+	}
+}
+
+// ListAPIKeys returns key metadata, optionally for a single client_id. Secrets are never returned.
+func ListAPIKeys(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := auth.ListKeys(s, r.URL.Query().Get("client_id"))
+		if err != nil {
+			s.Log.Error("Failed to list API keys", "error", err)
+			writeError(w, "Failed to list API keys", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keyListResponse{Keys: keys})
+	}
+}
+
+// RevokeAPIKey disables a key; requests using it fail from then on
+func RevokeAPIKey(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeError(w, "Invalid key id", http.StatusBadRequest)
+			return
+		}
+
+		if err := auth.RevokeKey(s, uint(id)); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeError(w, "API key not found", http.StatusNotFound)
+			} else {
+				s.Log.Error("Failed to revoke API key", "key_id", id, "error", err)
+				writeError(w, "Failed to revoke API key", http.StatusInternalServerError)
+			}
+			return
+		}
+		s.Log.Info("API key revoked", "key_id", id)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
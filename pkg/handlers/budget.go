@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workers"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// taskIdParam reads the task id from the {id} path segment, or the task_id query param on legacy routes
+func taskIdParam(r *http.Request) (uuid.UUID, error) {
+	id := r.PathValue("id")
+	if id == "" {
+		id = r.URL.Query().Get("task_id")
+	}
+	return uuid.Parse(id)
+}
+
+// requireTaskOwner checks that taskId exists and belongs to the caller, writing a 404 otherwise.
+// Tasks of other clients are reported as missing so their ids are not confirmed.
+func requireTaskOwner(s *db.Store, w http.ResponseWriter, r *http.Request, taskId uuid.UUID) bool {
+	var count int64
+	if err := restrictToOwner(r, s.DB.Model(&models.Task{})).Where("task_id = ?", taskId).Count(&count).Error; err != nil {
+		s.Log.Error("Failed to look up task", "task_id", taskId, "error", err)
+		writeError(w, "Failed to look up task", http.StatusInternalServerError)
+		return false
+	}
+	if count == 0 {
+		writeError(w, "Task not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// updateBudgetRequest is the body accepted by PUT /v1/tasks/{id}/budget. TaskId is only read on the legacy route.
+// updateBudgetRequest changes the limits that are present; an omitted limit keeps its current value, and 0 removes it
+type updateBudgetRequest struct {
+	TaskId    uuid.UUID `json:"task_id,omitempty"`
+	MaxTokens *int64    `json:"max_tokens,omitempty"`
+	MaxCost   *float64  `json:"max_cost,omitempty"`
+}
+
+func (req *updateBudgetRequest) validate() error {
+	if req.MaxTokens == nil && req.MaxCost == nil {
+		return invalidField("max_tokens", "at least one of max_tokens or max_cost is required")
+	}
+	if req.MaxTokens != nil && *req.MaxTokens < 0 {
+		return invalidField("max_tokens", "max_tokens cannot be negative")
+	}
+	if req.MaxCost != nil && *req.MaxCost < 0 {
+		return invalidField("max_cost", "max_cost cannot be negative")
+	}
+	return nil
+}
+
+// GetTaskBudget returns a decomposed task's budget, spend so far and number of paused subtasks
+func GetTaskBudget(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskId, err := taskIdParam(r)
+		if err != nil {
+			writeError(w, "Invalid task_id", http.StatusBadRequest)
+			return
+		}
+		if !requireTaskOwner(s, w, r, taskId) {
+			return
+		}
+
+		status, err := workers.GetBudgetStatus(s, taskId)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				writeError(w, "Task has no budget", http.StatusNotFound)
+			} else {
+				s.Log.Error("Failed to fetch task budget", "task_id", taskId, "error", err)
+				writeError(w, "Failed to fetch task budget", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// UpdateTaskBudget changes a task's token and/or cost limits. Paused subtasks resume once the task is back within budget.
+func UpdateTaskBudget(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req updateBudgetRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if id := r.PathValue("id"); id != "" {
+			taskId, err := uuid.Parse(id)
+			if err != nil {
+				writeError(w, "Invalid task id", http.StatusBadRequest)
+				return
+			}
+			req.TaskId = taskId
+		}
+		if !requireTaskOwner(s, w, r, req.TaskId) {
+			return
+		}
+
+		current, err := workers.GetBudgetStatus(s, req.TaskId)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				writeError(w, "Task has no budget", http.StatusNotFound)
+			} else {
+				s.Log.Error("Failed to fetch task budget", "task_id", req.TaskId, "error", err)
+				writeError(w, "Failed to fetch task budget", http.StatusInternalServerError)
+			}
+			return
+		}
+		maxTokens, maxCost := current.MaxTokens, current.MaxCost
+		if req.MaxTokens != nil {
+			maxTokens = *req.MaxTokens
+		}
+		if req.MaxCost != nil {
+			maxCost = *req.MaxCost
+		}
+
+		status, err := workers.UpdateBudget(s, req.TaskId, maxTokens, maxCost)
+		if err != nil {
+			s.Log.Error("Failed to update task budget", "task_id", req.TaskId, "error", err)
+			writeError(w, "Failed to update task budget", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// APIError is the envelope of every non-2xx response: {"error": {"code": ..., "message": ...}}
+type APIError struct {
+	Code    string `json:"code"`            // Stable, machine-readable, e.g. "not_found" or "budget_exceeded"
+	Message string `json:"message"`         // Human-readable explanation
+	Field   string `json:"field,omitempty"` // Request field that failed validation, if any
+}
+
+type errorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// errorCodes maps statuses to the code used when a handler does not pick a more specific one
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "invalid_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusUnprocessableEntity: "unprocessable",
+	http.StatusTooManyRequests:     "rate_limited",
+	http.StatusInternalServerError: "internal_error",
+	http.StatusServiceUnavailable:  "unavailable",
+}
+
+func codeForStatus(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// writeError replies with an error envelope whose code is derived from status; it mirrors http.Error's argument order
+func writeError(w http.ResponseWriter, message string, status int) {
+	writeAPIError(w, APIError{Code: codeForStatus(status), Message: message}, status)
+}
+
+// writeAPIError replies with a fully specified error envelope
+func writeAPIError(w http.ResponseWriter, apiErr APIError, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: apiErr})
+}
@@ -4,53 +4,135 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/arnavsurve/promise/pkg/ai"
+	"github.com/arnavsurve/promise/pkg/auth"
 	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/metrics"
 	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/tracing"
+	"github.com/arnavsurve/promise/pkg/webhooks"
+	"github.com/arnavsurve/promise/pkg/workers"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
 var ctx = context.Background()
 
+// enqueueJobRequest is the body accepted by POST /v1/jobs
+type enqueueJobRequest struct {
+	Command    string   `json:"command"`
+	Tags       []string `json:"tags,omitempty"`
+	WebhookURL string   `json:"webhook_url,omitempty"` // Receives this job's events; currently only job_queued
+}
+
+func (req *enqueueJobRequest) validate() error {
+	if strings.TrimSpace(req.Command) == "" {
+		return invalidField("command", "command cannot be empty")
+	}
+	if err := validateWebhookURL(req.WebhookURL); err != nil {
+		return err
+	}
+	return validateTags(req.Tags)
+}
+
+type enqueueJobResponse struct {
+	Message string             `json:"message"`
+	JobId   uint               `json:"job_id"`
+	Webhook *submissionWebhook `json:"webhook,omitempty"`
+}
+
+// submissionWebhook identifies the webhook registered through a submission's webhook_url, with the secret to verify its signatures
+type submissionWebhook struct {
+	Id     uint   `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// validateWebhookURL checks the optional webhook_url of a submission
+func validateWebhookURL(receiver string) error {
+	if receiver == "" {
+		return nil
+	}
+	if err := webhooks.ValidateURL(receiver); err != nil {
+		return invalidField("webhook_url", "%s", err)
+	}
+	return nil
+}
+
+// registerSubmissionWebhook stores the webhook requested with a submission in the submission's transaction, so a
+// submission is never queued without the webhook it asked for
+func registerSubmissionWebhook(tx *gorm.DB, principal auth.Principal, receiver string, taskId *uuid.UUID, jobId *uint) (*submissionWebhook, error) {
+	if receiver == "" {
+		return nil, nil
+	}
+	hook, secret, err := webhooks.CreateForSubmission(tx, principal.ClientId, principal.Tenant, receiver, taskId, jobId)
+	if err != nil {
+		return nil, fmt.Errorf("registering webhook: %w", err)
+	}
+	return &submissionWebhook{Id: hook.ID, Secret: secret}, nil
+}
+
+// validateTags rejects empty tags and tags containing the comma used to separate them in list filters
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" || strings.Contains(tag, ",") {
+			return invalidField("tags", "tags must be non-empty and cannot contain commas")
+		}
+	}
+	return nil
+}
+
 // EnqueueJob adds an incoming task to the queue
 func EnqueueJob(s *db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var job models.Job
-
-		// Decode JSON from request body
-		err := json.NewDecoder(r.Body).Decode(&job)
-		if err != nil {
-			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		var req enqueueJobRequest
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 
-		// Validate that command is not empty
-		if job.Command == "" {
-			http.Error(w, "Command cannot be empty", http.StatusBadRequest)
+		// Set initial status and save to the database
+		principal, _ := auth.FromContext(r.Context())
+		job := models.Job{Command: req.Command, Tags: req.Tags, Status: "Queued", ClientId: principal.ClientId, Tenant: principal.Tenant}
+		tx := s.DB.Begin()
+		if err := tx.Create(&job).Error; err != nil {
+			s.Log.Error("Failed to store job", "error", err)
+			tx.Rollback()
+			writeError(w, "Failed to enqueue job", http.StatusInternalServerError)
 			return
 		}
-
-		// Set initial status and save to the database
-		job.Status = "Queued"
-		if err := s.DB.Create(&job).Error; err != nil {
-			http.Error(w, "Failed to enqueue job", http.StatusInternalServerError)
+		hook, err := registerSubmissionWebhook(tx, principal, req.WebhookURL, nil, &job.ID)
+		if err != nil {
+			s.Log.Error("Failed to register submission webhook", "error", err)
+			tx.Rollback()
+			writeError(w, "Failed to enqueue job", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			s.Log.Error("Failed to commit job", "error", err)
+			writeError(w, "Failed to enqueue job", http.StatusInternalServerError)
 			return
 		}
 
-		err = PublishJob(s, job.Command)
-		if err != nil {
-			log.Printf("Error publishing job: %s\n", err)
+		if err := PublishJob(s, job.Command); err != nil {
+			s.Log.Error("Error publishing job", "job_id", job.ID, "error", err)
 		}
+		workers.PublishTaskEvent(s, models.TaskEvent{JobId: job.ID, Event: "job_queued"})
+		s.Log.Info("Job enqueued", "job_id", job.ID, "command", job.Command)
+		metrics.JobsSubmitted.Inc()
 
 		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(map[string]interface{}{
-			"message": "Job enqueued successfully",
-			"job_id":  job.ID,
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(enqueueJobResponse{
+			Message: "Job enqueued successfully",
+			JobId:   job.ID,
+			Webhook: hook,
 		})
 	}
 }
@@ -63,127 +145,365 @@ func PublishJob(s *db.Store, command string) error {
 	return nil
 }
 
-// GetJobStatus returns a job's status and execution time in UTC by default. Timezone can be defined via URL parameter
+type jobStatusResponse struct {
+	Id         uint      `json:"id"`
+	Command    string    `json:"command"`
+	Status     string    `json:"status"`
+	Tags       []string  `json:"tags"`
+	ExecutedAt time.Time `json:"executed_at"`
+}
+
+// GetJobStatus returns a job's status and execution time in UTC by default. Timezone can be defined via URL parameter.
+// The job id comes from the {id} path segment, or the id query param on the legacy route.
 func GetJobStatus(s *db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		queryParams := r.URL.Query()
-		id := queryParams.Get("id")
+		id := r.PathValue("id")
+		if id == "" {
+			id = queryParams.Get("id")
+		}
 		timezone := queryParams.Get("timezone")
 
+		if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+			writeError(w, "Invalid job id", http.StatusBadRequest)
+			return
+		}
+
+		loc := time.UTC
+		if timezone != "" {
+			var err error
+			if loc, err = time.LoadLocation(timezone); err != nil {
+				writeError(w, "Invalid timezone", http.StatusBadRequest)
+				return
+			}
+		}
+
 		var job models.Job
 
-		if err := s.DB.Where("id = ?", id).First(&job).Error; err != nil {
+		if err := restrictToOwner(r, s.DB).Where("id = ?", id).First(&job).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
-				http.Error(w, "Job not found", http.StatusNotFound)
+				writeError(w, "Job not found", http.StatusNotFound)
 			} else {
-				log.Printf("Failed to fetch job from database: %s\n", err)
-				http.Error(w, "Failed to fetch job from database", http.StatusInternalServerError)
+				s.Log.Error("Failed to fetch job from database", "job_id", id, "error", err)
+				writeError(w, "Failed to fetch job from database", http.StatusInternalServerError)
 			}
+			return
 		}
 
 		// Convert ExecutionTime to requester's local time if timezone is provided
-		executedAt := job.ExecutionTime
-		if timezone != "" {
-			loc, err := time.LoadLocation(timezone)
-			if err != nil {
-				http.Error(w, "Invalid timezone", http.StatusBadRequest)
-			}
-
-			executedAt = executedAt.In(loc)
-		}
+		executedAt := job.ExecutionTime.In(loc)
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"id":          job.ID,
-			"command":     job.Command,
-			"status":      job.Status,
-			"executed_at": executedAt,
+		json.NewEncoder(w).Encode(jobStatusResponse{
+			Id:         job.ID,
+			Command:    job.Command,
+			Status:     job.Status,
+			Tags:       job.Tags,
+			ExecutedAt: executedAt,
 		})
 	}
 }
 
+// budgetRequest is the optional spend cap accepted by /job/decompose
+type budgetRequest struct {
+	MaxTokens   int64   `json:"max_tokens"`
+	MaxCost     float64 `json:"max_cost"`
+	MaxSubtasks int     `json:"max_subtasks"`
+	OnExceeded  string  `json:"on_exceeded"` // "pause" (default) or "fail"
+}
+
+// decomposeRequest is the body accepted by POST /v1/tasks
+type decomposeRequest struct {
+	Description string         `json:"description"`
+	Budget      *budgetRequest `json:"budget,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	WebhookURL  string         `json:"webhook_url,omitempty"` // Receives this task's events
+	Plan        bool           `json:"plan,omitempty"`        // Hold the subtasks for review instead of queueing them
+}
+
+func (req *decomposeRequest) validate() error {
+	if strings.TrimSpace(req.Description) == "" {
+		return invalidField("description", "description cannot be empty")
+	}
+	if err := validateBudget(req.Budget); err != nil {
+		return err
+	}
+	if err := validateWebhookURL(req.WebhookURL); err != nil {
+		return err
+	}
+	return validateTags(req.Tags)
+}
+
+// validateBudget checks an optional budget block, defaulting on_exceeded to "pause"
+func validateBudget(budget *budgetRequest) error {
+	if budget == nil {
+		return nil
+	}
+	if budget.OnExceeded == "" {
+		budget.OnExceeded = "pause"
+	}
+	if budget.OnExceeded != "pause" && budget.OnExceeded != "fail" {
+		return invalidField("budget.on_exceeded", "budget.on_exceeded must be 'pause' or 'fail'")
+	}
+	if budget.MaxTokens < 0 || budget.MaxCost < 0 || budget.MaxSubtasks < 0 {
+		return invalidField("budget", "budget limits cannot be negative")
+	}
+	return nil
+}
+
+type decomposeResponse struct {
+	Message string                `json:"message"`
+	TaskId  uuid.UUID             `json:"task_id"`
+	Budget  *budgetRequest        `json:"budget"`
+	Tasks   []models.TaskResponse `json:"tasks"`
+	Webhook *submissionWebhook    `json:"webhook,omitempty"`
+}
+
 func EnqueueJobWithDecomposition(s *db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		var job decomposeRequest
+		if !decodeJSON(w, r, &job) {
 			return
 		}
 
-		var job struct {
-			Description string `json:"description"`
-		}
+		// Generate a unique task ID
+		taskId := uuid.New()
 
-		err := json.NewDecoder(r.Body).Decode(&job)
-		if err != nil {
-			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		principal, _ := auth.FromContext(r.Context())
+		clientId := principal.ClientId
+		tenant := principal.Tenant
+
+		// Refuse before spending tokens on decomposition if the tenant is already out of tokens or storage
+		if err := workers.CheckSubmissionQuota(s, tenant, 0); err != nil {
+			writeQuotaError(w, err)
 			return
 		}
 
-		// Generate a unique task ID
-		taskId := uuid.New()
+		// The decomposition span is the root of the task's trace; every subtask carries it in its payload
+		spanCtx, span := tracing.Tracer.Start(r.Context(), "decompose", trace.WithAttributes(
+			attribute.String("task.id", taskId.String()),
+			attribute.String("client.id", clientId),
+			attribute.String("tenant", tenant),
+		))
+		defer span.End()
+
+		logger := s.Log.With("task_id", taskId, "client_id", clientId, "tenant", tenant)
 
 		// Query AI for subtasks
-		tasks, err := ai.LLMDecompositionQuery(job.Description)
+		queryCtx, usage := ai.WithUsageCollector(spanCtx)
+		tasks, err := ai.LLMDecompositionQuery(queryCtx, job.Description, workers.ResourceNames())
+		if err := s.SaveLLMUsage(ai.UsageRecords(usage, taskId, 0, clientId, "decomposition")); err != nil {
+			logger.Error("Failed to record decomposition LLM usage", "error", err)
+		}
+		workers.ChargeTenant(s, tenant, usage.TotalTokens())
 		if err != nil {
-			log.Println(err)
-			http.Error(w, "Failed to generate subtasks", http.StatusInternalServerError)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "decomposition failed")
+			logger.Error("Decomposition failed", "error", err)
+			metrics.TasksDecomposed.WithLabelValues("llm_error").Inc()
+			writeError(w, "Failed to generate subtasks", http.StatusInternalServerError)
 			return
 		}
 
-		tx := s.DB.Begin()
-
-		var taskResponses []models.TaskResponse
+		if err := workers.CheckSubmissionQuota(s, tenant, len(tasks)); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			metrics.TasksDecomposed.WithLabelValues("quota_exceeded").Inc()
+			writeQuotaError(w, err)
+			return
+		}
 
-		// Store subtasks in Postgres
-		for _, task := range tasks {
+		tx := s.DB.Begin()
 
-			// Cast TaskResponse to DB model Task
-			taskInDb := models.Task{
-				TaskId:       taskId,
-				SubtaskId:    task.SubtaskId,
-				Type:         task.Type,
-				Description:  task.Description,
-				Dependencies: task.Dependencies,
-				Status:       "pending",
+		var budget *models.TaskBudget
+		if job.Budget != nil {
+			// Enforce the budget against the decomposition itself before anything is queued
+			budget = newTaskBudget(taskId, job.Budget)
+			var reason string
+			switch {
+			case budget.MaxSubtasks > 0 && len(tasks) > budget.MaxSubtasks:
+				reason = fmt.Sprintf("decomposition produced %d subtasks, budget allows %d", len(tasks), budget.MaxSubtasks)
+			case budget.MaxTokens > 0 && usage.TotalTokens() >= budget.MaxTokens:
+				reason = fmt.Sprintf("decomposition used %d tokens, budget allows %d", usage.TotalTokens(), budget.MaxTokens)
+			case budget.MaxCost > 0 && usage.TotalCost() >= budget.MaxCost:
+				reason = fmt.Sprintf("decomposition cost $%.6f, budget allows $%.6f", usage.TotalCost(), budget.MaxCost)
 			}
-
-			if err := tx.Create(&taskInDb).Error; err != nil {
-				log.Printf("Failed to store subtask: %v\n", err)
+			if reason != "" {
+				span.SetStatus(codes.Error, reason)
 				tx.Rollback()
-				http.Error(w, "Failed to store subtask", http.StatusInternalServerError)
+				metrics.TasksDecomposed.WithLabelValues("budget_exceeded").Inc()
+				writeAPIError(w, APIError{Code: "budget_exceeded", Message: reason}, http.StatusUnprocessableEntity)
 				return
 			}
 
-			err = PublishTask(s, taskInDb)
-			if err != nil {
-				log.Printf("Error publishing task: %s\n", err)
+			if err := storeTaskBudget(tx, budget); err != nil {
+				logger.Error("Failed to store task budget", "error", err)
 				tx.Rollback()
-				http.Error(w, "Failed to publish task to queue", http.StatusInternalServerError)
+				metrics.TasksDecomposed.WithLabelValues("store_error").Inc()
+				writeError(w, "Failed to store task budget", http.StatusInternalServerError)
 				return
 			}
+		}
+
+		// Register the webhook before anything is queued so it sees the first transition
+		hook, err := registerSubmissionWebhook(tx, principal, job.WebhookURL, &taskId, nil)
+		if err != nil {
+			logger.Error("Failed to register submission webhook", "error", err)
+			tx.Rollback()
+			metrics.TasksDecomposed.WithLabelValues("store_error").Inc()
+			writeError(w, "Failed to register webhook", http.StatusInternalServerError)
+			return
+		}
 
-			taskResponse := models.TaskResponse{
-				TaskId:       task.TaskId,
+		// Cast TaskResponse to DB model Task
+		taskRows := make([]models.Task, 0, len(tasks))
+		for _, task := range tasks {
+			// The decomposer numbers dependencies under its own task id; point them at the stored task
+			for i := range task.Dependencies {
+				task.Dependencies[i].TaskId = taskId
+			}
+			taskRows = append(taskRows, models.Task{
+				TaskId:       taskId,
 				SubtaskId:    task.SubtaskId,
 				Type:         task.Type,
 				Description:  task.Description,
 				Dependencies: task.Dependencies,
-				Status:       task.Status,
+				Resources:    task.Resources,
+				Status:       "pending",
+				ClientId:     clientId,
+				Tenant:       tenant,
+				TraceContext: tracing.Inject(spanCtx),
+				Tags:         job.Tags,
+			})
+		}
+
+		if job.Plan {
+			err = holdPlan(tx, &models.Plan{TaskId: taskId, ClientId: clientId, Tenant: tenant, Description: job.Description}, taskRows)
+		} else if err = workers.RecordInitialPlan(tx, taskId, job.Description, taskRows); err == nil {
+			err = storeSubtasks(tx, taskRows)
+		}
+		if err != nil {
+			logger.Error("Failed to queue subtasks", "error", err)
+			tx.Rollback()
+			metrics.TasksDecomposed.WithLabelValues("store_error").Inc()
+			writeError(w, "Failed to queue subtasks", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			logger.Error("Failed to commit subtasks", "error", err)
+			metrics.TasksDecomposed.WithLabelValues("store_error").Inc()
+			writeError(w, "Failed to queue subtasks", http.StatusInternalServerError)
+			return
+		}
+		if err := activateTaskBudget(s, budget, usage.TotalTokens(), usage.TotalCost()); err != nil {
+			logger.Error("Failed to activate task budget", "error", err)
+			metrics.TasksDecomposed.WithLabelValues("store_error").Inc()
+			writeError(w, "Failed to store task budget", http.StatusInternalServerError)
+			return
+		}
+		if !job.Plan {
+			if err := publishSubtasks(s, taskRows); err != nil {
+				logger.Error("Failed to publish subtasks", "error", err)
+				metrics.TasksDecomposed.WithLabelValues("store_error").Inc()
+				writeError(w, "Failed to publish subtasks to queue", http.StatusInternalServerError)
+				return
 			}
+		}
 
-			taskResponses = append(taskResponses, taskResponse)
+		metrics.TasksDecomposed.WithLabelValues("success").Inc()
+		span.SetAttributes(attribute.Int("task.subtasks", len(tasks)), attribute.Bool("task.plan", job.Plan))
+		for _, task := range tasks {
+			metrics.SubtasksCreated.WithLabelValues(task.Type).Inc()
 		}
 
-		tx.Commit()
+		message := "Job decomposed and queued successfully"
+		if job.Plan {
+			message = "Job decomposed, plan awaiting approval"
+			logger.Info("Job decomposed, plan awaiting approval", "subtasks", len(tasks), "description", job.Description)
+			workers.PublishTaskEvent(s, models.TaskEvent{TaskId: taskId, Event: "plan_awaiting_approval"})
+		} else {
+			logger.Info("Job decomposed and queued", "subtasks", len(tasks), "description", job.Description)
+		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"message": "Job decomposed and queued successfully",
-			"tasks":   taskResponses,
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(decomposeResponse{
+			Message: message,
+			TaskId:  taskId,
+			Budget:  job.Budget,
+			Tasks:   taskResponses(taskRows),
+			Webhook: hook,
 		})
 	}
 }
 
+// newTaskBudget builds the budget row for a submission's budget block
+func newTaskBudget(taskId uuid.UUID, req *budgetRequest) *models.TaskBudget {
+	return &models.TaskBudget{
+		TaskId:      taskId,
+		MaxTokens:   req.MaxTokens,
+		MaxCost:     req.MaxCost,
+		MaxSubtasks: req.MaxSubtasks,
+		OnExceeded:  req.OnExceeded,
+		Status:      "within_budget",
+	}
+}
+
+// storeTaskBudget saves a budget in tx. Workers only see it once activateTaskBudget runs after tx commits.
+func storeTaskBudget(tx *gorm.DB, budget *models.TaskBudget) error {
+	return tx.Create(budget).Error
+}
+
+// activateTaskBudget makes a committed budget visible to workers and charges it with what the submission already spent.
+// It must run before the first subtask is published; if it fails, the task's subtasks are marked failed.
+func activateTaskBudget(s *db.Store, budget *models.TaskBudget, tokens int64, cost float64) error {
+	if budget == nil {
+		return nil
+	}
+	if err := workers.InitTaskBudget(s, *budget); err != nil {
+		s.DB.Model(&models.Task{}).Where("task_id = ?", budget.TaskId).Update("status", "failed")
+		return err
+	}
+	workers.ChargeBudget(s, budget.TaskId, tokens, cost)
+	return nil
+}
+
+// storeSubtasks stores subtasks in tx. They are published with publishSubtasks once tx has committed, so a worker
+// never picks up a subtask whose row, or whose siblings' rows, it cannot see yet.
+func storeSubtasks(tx *gorm.DB, tasks []models.Task) error {
+	for _, task := range tasks {
+		if err := tx.Create(&task).Error; err != nil {
+			return fmt.Errorf("storing subtask %d: %w", task.SubtaskId, err)
+		}
+	}
+	return nil
+}
+
+// publishSubtasks pushes committed subtasks to their task's queue. Subtasks that could not be published are marked
+// failed so the task still finishes.
+func publishSubtasks(s *db.Store, tasks []models.Task) error {
+	for i, task := range tasks {
+		if err := PublishTask(s, task); err != nil {
+			unpublished := make([]int, 0, len(tasks)-i)
+			for _, task := range tasks[i:] {
+				unpublished = append(unpublished, task.SubtaskId)
+			}
+			s.DB.Model(&models.Task{}).Where("task_id = ? AND subtask_id IN ?", task.TaskId, unpublished).Update("status", "failed")
+			return fmt.Errorf("publishing subtask %d: %w", task.SubtaskId, err)
+		}
+	}
+	return nil
+}
+
+// taskResponses converts subtasks to their API representation
+func taskResponses(tasks []models.Task) []models.TaskResponse {
+	responses := make([]models.TaskResponse, 0, len(tasks))
+	for _, task := range tasks {
+		responses = append(responses, task.Response())
+	}
+	return responses
+}
+
 // Publish subtask to Redis
 func PublishTask(s *db.Store, task models.Task) error {
 	taskJSON, err := json.Marshal(task)
@@ -198,11 +518,11 @@ func PublishTask(s *db.Store, task models.Task) error {
 	}
 
 	// Store the subtask status as "pending"
-	statusKey := fmt.Sprintf("task_status:%s:%d", task.TaskId, task.SubtaskId)
+	statusKey := workers.SubtaskStatusKey(task.TaskId, task.SubtaskId)
 	s.Rdb.Set(ctx, statusKey, "pending", 0)
 
-	// Push task into the queue for that task ID
-	queueKey := fmt.Sprintf("task_queue:%s", task.TaskId)
+	// Push task into the queue for that task ID, within the tenant's namespace
+	queueKey := workers.TaskQueueKey(task.Tenant, task.TaskId)
 	err = s.Rdb.RPush(ctx, queueKey, taskJSON).Err()
 	if err != nil {
 		return err
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/google/uuid"
+)
+
+// ownedClientFilter is the client_id listings are restricted to: the caller's own, or for admins the
+// optional client_id query param
+func ownedClientFilter(r *http.Request) string {
+	if clientId, restricted := ownerScope(r); restricted {
+		return clientId
+	}
+	return r.URL.Query().Get("client_id")
+}
+
+// parseListFilter reads the filter, sort and paging query params shared by /jobs and /tasks:
+// status, tenant, search, tags (comma separated, all must match), created_after and created_before (RFC 3339),
+// sort (created_at, updated_at, id), order (asc, desc), cursor and limit.
+func parseListFilter(query url.Values) (db.ListFilter, error) {
+	f := db.ListFilter{
+		Status: query.Get("status"),
+		Tenant: query.Get("tenant"),
+		Search: query.Get("search"),
+		SortBy: query.Get("sort"),
+		Order:  query.Get("order"),
+		Cursor: query.Get("cursor"),
+	}
+
+	if tags := query.Get("tags"); tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				f.Tags = append(f.Tags, tag)
+			}
+		}
+	}
+
+	for param, dest := range map[string]*time.Time{"created_after": &f.CreatedAfter, "created_before": &f.CreatedBefore} {
+		if value := query.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+			}
+			*dest = t
+		}
+	}
+
+	switch f.SortBy {
+	case "", "created_at", "updated_at", "id":
+	default:
+		return f, errors.New("sort must be one of created_at, updated_at, id")
+	}
+	switch f.Order {
+	case "", "asc", "desc":
+	default:
+		return f, errors.New("order must be 'asc' or 'desc'")
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > db.MaxPageSize {
+			return f, fmt.Errorf("limit must be between 1 and %d", db.MaxPageSize)
+		}
+		f.Limit = n
+	}
+
+	return f, nil
+}
+
+type jobListResponse struct {
+	Jobs       []models.Job `json:"jobs"`
+	NextCursor string       `json:"next_cursor"` // Empty on the last page
+}
+
+type taskListResponse struct {
+	Tasks      []models.Task `json:"tasks"`
+	NextCursor string        `json:"next_cursor"` // Empty on the last page
+}
+
+// ListJobs returns a page of shell jobs. Besides the shared filters, search matches a substring of the command.
+func ListJobs(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseListFilter(r.URL.Query())
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.ClientId = ownedClientFilter(r)
+
+		jobs, next, err := s.ListJobs(filter)
+		if err != nil {
+			if errors.Is(err, db.ErrInvalidCursor) {
+				writeError(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			s.Log.Error("Failed to list jobs", "error", err)
+			writeError(w, "Failed to list jobs", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jobListResponse{Jobs: jobs, NextCursor: next})
+	}
+}
+
+// ListTasks returns a page of subtasks. Besides the shared filters it accepts type and task_id,
+// and search matches a substring of the subtask description.
+func ListTasks(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter, err := parseListFilter(query)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Type = query.Get("type")
+		filter.ClientId = ownedClientFilter(r)
+		if taskId := query.Get("task_id"); taskId != "" {
+			if _, err := uuid.Parse(taskId); err != nil {
+				writeError(w, "Invalid task_id", http.StatusBadRequest)
+				return
+			}
+			filter.TaskId = taskId
+		}
+
+		tasks, next, err := s.ListTasks(filter)
+		if err != nil {
+			if errors.Is(err, db.ErrInvalidCursor) {
+				writeError(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			s.Log.Error("Failed to list tasks", "error", err)
+			writeError(w, "Failed to list tasks", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(taskListResponse{Tasks: tasks, NextCursor: next})
+	}
+}
+
+type taskDetailResponse struct {
+	TaskId   uuid.UUID      `json:"task_id"`
+	Status   map[string]int `json:"status"` // Number of subtasks in each status
+	Subtasks []models.Task  `json:"subtasks"`
+}
+
+// GetTask returns every subtask of a decomposed task together with a count of subtasks per status
+func GetTask(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskId, err := taskIdParam(r)
+		if err != nil {
+			writeError(w, "Invalid task id", http.StatusBadRequest)
+			return
+		}
+
+		var subtasks []models.Task
+		if err := restrictToOwner(r, s.DB).Where("task_id = ?", taskId).Order("subtask_id").Find(&subtasks).Error; err != nil {
+			s.Log.Error("Failed to fetch subtasks", "task_id", taskId, "error", err)
+			writeError(w, "Failed to fetch subtasks", http.StatusInternalServerError)
+			return
+		}
+		if len(subtasks) == 0 {
+			writeError(w, "Task not found", http.StatusNotFound)
+			return
+		}
+
+		status := map[string]int{}
+		for _, subtask := range subtasks {
+			status[subtask.Status]++
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(taskDetailResponse{TaskId: taskId, Status: status, Subtasks: subtasks})
+	}
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	uuidType      = reflect.TypeOf(uuid.UUID{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// openAPIBuilder generates an OpenAPI 3 document from the route table, deriving schemas from the Go request
+// and response types so the document cannot drift from the handlers
+type openAPIBuilder struct {
+	schemas map[string]interface{}
+}
+
+// OpenAPISpec builds the OpenAPI document describing routes
+func OpenAPISpec(routes []Route) map[string]interface{} {
+	b := &openAPIBuilder{schemas: map[string]interface{}{}}
+	errorSchema := b.schemaFor(reflect.TypeOf(errorResponse{}))
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range routes {
+		operation := map[string]interface{}{
+			"summary":     route.Summary,
+			"operationId": route.OperationId,
+			"tags":        []string{route.Tag},
+		}
+
+		var params []interface{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name": match[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, param := range route.Query {
+			paramType := param.Type
+			if paramType == "" {
+				paramType = "string"
+			}
+			params = append(params, map[string]interface{}{
+				"name": param.Name, "in": "query", "required": param.Required, "description": param.Description,
+				"schema": map[string]interface{}{"type": paramType},
+			})
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		if route.Request != nil {
+			schema := b.schemaFor(reflect.TypeOf(route.Request))
+			content := jsonContent(schema)
+			if route.AcceptsYAML {
+				content["application/yaml"] = map[string]interface{}{"schema": schema}
+			}
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  content,
+			}
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if route.Response != nil {
+			success["content"] = jsonContent(b.schemaFor(reflect.TypeOf(route.Response)))
+		}
+		operation["responses"] = map[string]interface{}{
+			strconv.Itoa(status): success,
+			"default":            map[string]interface{}{"description": "Error", "content": jsonContent(errorSchema)},
+		}
+
+		if route.Scope != "" {
+			operation["security"] = []interface{}{map[string]interface{}{"apiKey": []string{}}}
+			operation["description"] = "Requires an API key with the " + route.Scope + " scope."
+		}
+
+		if paths[route.Path] == nil {
+			paths[route.Path] = map[string]interface{}{}
+		}
+		paths[route.Path][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "promise",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "API key issued through /v1/admin/keys"},
+			},
+		},
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// schemaFor returns the JSON schema of t. Named structs are added to components and referenced.
+func (b *openAPIBuilder) schemaFor(t reflect.Type) interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]interface{}{"type": "string", "format": "uuid"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "description": "Duration in nanoseconds"}
+	}
+	if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		// Custom JSON encodings (e.g. gorm.DeletedAt) have no reflectable shape
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, exists := b.schemas[name]; !exists {
+			b.schemas[name] = map[string]interface{}{} // Placeholder so recursive types terminate
+			b.schemas[name] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema describes a struct's JSON fields, flattening embedded structs the way encoding/json does
+func (b *openAPIBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	b.collectFields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (b *openAPIBuilder) collectFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				b.collectFields(embedded, properties)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schemaFor(field.Type)
+	}
+}
+
+// schemaName turns a Go type name into a component name, e.g. jobListResponse -> JobListResponse
+func schemaName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+// ServeOpenAPI serves the OpenAPI document for routes, generated once at startup
+func ServeOpenAPI(routes []Route) http.HandlerFunc {
+	spec, err := json.MarshalIndent(OpenAPISpec(routes), "", "  ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			writeError(w, "Failed to generate OpenAPI document", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
@@ -0,0 +1,533 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/auth"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workers"
+	"github.com/arnavsurve/promise/pkg/workflow"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errPlanClosed is returned when a plan is changed after it has been approved or rejected
+var errPlanClosed = errors.New("plan is no longer awaiting approval")
+
+type planResponse struct {
+	Plan     models.Plan   `json:"plan"`
+	Subtasks []models.Task `json:"subtasks"`
+}
+
+type planListResponse struct {
+	Plans []models.Plan `json:"plans"`
+}
+
+// planSubtaskRequest is the body of POST and PATCH /v1/tasks/{id}/plan/subtasks. Omitted fields are left unchanged on PATCH.
+type planSubtaskRequest struct {
+	Type         *string           `json:"type,omitempty"`
+	Description  *string           `json:"description,omitempty"`
+	Command      *string           `json:"command,omitempty"` // Explicit shell command; an empty string clears it
+	Dependencies *[]planDependency `json:"dependencies,omitempty"`
+	When         *string           `json:"when,omitempty"` // An empty string clears it
+	Resources    *[]string         `json:"resources,omitempty"`
+}
+
+// planDependency is written as a subtask id, or as an object to add a condition
+type planDependency struct {
+	SubtaskId int    `json:"subtask_id"`
+	Condition string `json:"condition,omitempty"` // success (default), failure or always
+	Match     string `json:"match,omitempty"`     // Regular expression the dependency's output must match
+}
+
+// UnmarshalJSON accepts either 3 or {"subtask_id": 3, ...}
+func (d *planDependency) UnmarshalJSON(data []byte) error {
+	var id int
+	if err := json.Unmarshal(data, &id); err == nil {
+		*d = planDependency{SubtaskId: id}
+		return nil
+	}
+	type plain planDependency
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*plain)(d))
+}
+
+func (req *planSubtaskRequest) validate() error {
+	if req.Type != nil && !slices.Contains([]string{workflow.TypeCommand, workflow.TypeCode, workflow.TypeComposite}, *req.Type) {
+		return invalidField("type", "type must be %s, %s or %s", workflow.TypeCommand, workflow.TypeCode, workflow.TypeComposite)
+	}
+	if req.Description != nil && strings.TrimSpace(*req.Description) == "" {
+		return invalidField("description", "description cannot be empty")
+	}
+	return nil
+}
+
+// apply copies the request's fields onto task
+func (req *planSubtaskRequest) apply(task *models.Task) {
+	if req.Type != nil {
+		task.Type = *req.Type
+	}
+	if req.Description != nil {
+		task.Description = *req.Description
+	}
+	if req.Command != nil {
+		task.Command = *req.Command
+	}
+	if req.Dependencies != nil {
+		task.Dependencies = nil
+		for _, dep := range *req.Dependencies {
+			task.Dependencies = append(task.Dependencies, models.Dependency{
+				TaskId:    task.TaskId,
+				SubtaskId: dep.SubtaskId,
+				Condition: dep.Condition,
+				Match:     dep.Match,
+			})
+		}
+	}
+	if req.When != nil {
+		task.When = *req.When
+	}
+	if req.Resources != nil {
+		task.Resources = *req.Resources
+	}
+}
+
+// nameDependencies fills in the step names of a task's dependencies so when-clauses can refer to them by name
+func nameDependencies(task *models.Task, subtasks []models.Task) {
+	for i, dep := range task.Dependencies {
+		for _, subtask := range subtasks {
+			if subtask.SubtaskId == dep.SubtaskId {
+				task.Dependencies[i].Name = subtask.Name
+			}
+		}
+	}
+}
+
+// reviewRequest is the optional body of the approve and reject endpoints
+type reviewRequest struct {
+	Comment string `json:"comment,omitempty"`
+}
+
+// holdPlan stores a task's subtasks awaiting approval instead of queueing them
+func holdPlan(tx *gorm.DB, plan *models.Plan, tasks []models.Task) error {
+	plan.Status = models.PlanAwaitingApproval
+	if err := tx.Create(plan).Error; err != nil {
+		return fmt.Errorf("storing plan: %w", err)
+	}
+	for i := range tasks {
+		tasks[i].Status = models.PlanAwaitingApproval
+		if err := tx.Create(&tasks[i]).Error; err != nil {
+			return fmt.Errorf("storing subtask %d: %w", tasks[i].SubtaskId, err)
+		}
+	}
+	return nil
+}
+
+// ownedPlan loads the plan of the task in the {id} path segment if the caller owns it or may review it, writing an
+// error otherwise
+func ownedPlan(s *db.Store, w http.ResponseWriter, r *http.Request) (*models.Plan, bool) {
+	taskId, err := taskIdParam(r)
+	if err != nil {
+		writeError(w, "Invalid task id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var plan models.Plan
+	if err := restrictToReviewer(r, s.DB).Where("task_id = ?", taskId).First(&plan).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, "Task has no plan", http.StatusNotFound)
+		} else {
+			s.Log.Error("Failed to fetch plan", "task_id", taskId, "error", err)
+			writeError(w, "Failed to fetch plan", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return &plan, true
+}
+
+// lockOpenPlan locks a plan's row for the rest of tx, so edits and reviews of the same plan are serialized,
+// and returns its subtasks. It fails with errPlanClosed once the plan has been reviewed.
+func lockOpenPlan(tx *gorm.DB, plan *models.Plan) ([]models.Task, error) {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(plan, plan.ID).Error; err != nil {
+		return nil, err
+	}
+	if plan.Status != models.PlanAwaitingApproval {
+		return nil, errPlanClosed
+	}
+	var subtasks []models.Task
+	err := tx.Where("task_id = ?", plan.TaskId).Order("subtask_id").Find(&subtasks).Error
+	return subtasks, err
+}
+
+// editPlan runs edit on a plan's subtasks inside a transaction and replies with the resulting plan.
+// edit returns the API error to report, if any, with its status. editPlan reports whether the edit was committed.
+func editPlan(s *db.Store, w http.ResponseWriter, plan *models.Plan, edit func(tx *gorm.DB, subtasks []models.Task) (*APIError, int, error)) bool {
+	tx := s.DB.Begin()
+	subtasks, err := lockOpenPlan(tx, plan)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, errPlanClosed) {
+			writeError(w, fmt.Sprintf("Plan is %s and can no longer be edited", plan.Status), http.StatusConflict)
+			return false
+		}
+		s.Log.Error("Failed to lock plan", "task_id", plan.TaskId, "error", err)
+		writeError(w, "Failed to update plan", http.StatusInternalServerError)
+		return false
+	}
+
+	apiErr, status, err := edit(tx, subtasks)
+	if apiErr != nil || err != nil {
+		tx.Rollback()
+		if err != nil {
+			s.Log.Error("Failed to update plan", "task_id", plan.TaskId, "error", err)
+			writeError(w, "Failed to update plan", http.StatusInternalServerError)
+			return false
+		}
+		writeAPIError(w, *apiErr, status)
+		return false
+	}
+	if err := tx.Commit().Error; err != nil {
+		s.Log.Error("Failed to update plan", "task_id", plan.TaskId, "error", err)
+		writeError(w, "Failed to update plan", http.StatusInternalServerError)
+		return false
+	}
+
+	writePlan(s, w, plan, http.StatusOK)
+	return true
+}
+
+// writePlan replies with a plan and its current subtasks
+func writePlan(s *db.Store, w http.ResponseWriter, plan *models.Plan, status int) {
+	subtasks := []models.Task{}
+	if err := s.DB.Where("task_id = ?", plan.TaskId).Order("subtask_id").Find(&subtasks).Error; err != nil {
+		s.Log.Error("Failed to fetch plan subtasks", "task_id", plan.TaskId, "error", err)
+		writeError(w, "Failed to fetch plan subtasks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(planResponse{Plan: *plan, Subtasks: subtasks})
+}
+
+// planError checks the edited subtasks still form a valid graph, that only command_execution subtasks carry a command,
+// and that map subtasks remain maps
+func planError(subtasks []models.Task) *APIError {
+	for _, task := range subtasks {
+		if task.Command != "" && task.Type != workflow.TypeCommand {
+			return &APIError{Code: "validation_failed", Message: fmt.Sprintf("subtask %d: only command_execution subtasks take a command", task.SubtaskId), Field: "command"}
+		}
+		if (task.Map != nil) != (task.Type == workflow.TypeMap) {
+			return &APIError{Code: "validation_failed", Message: fmt.Sprintf("subtask %d: map subtasks cannot change type", task.SubtaskId), Field: "type"}
+		}
+	}
+	if err := workflow.ValidateGraph(subtasks); err != nil {
+		apiErr := &APIError{Code: "validation_failed", Message: err.Error()}
+		var we *workflow.Error
+		if errors.As(err, &we) {
+			apiErr.Field = we.Field
+		}
+		return apiErr
+	}
+	return nil
+}
+
+// subtaskParam reads the {subtask} path segment
+func subtaskParam(r *http.Request) (int, error) {
+	return strconv.Atoi(r.PathValue("subtask"))
+}
+
+// ListPlans returns the plans the caller owns or may review, newest first. Query params: status, limit (default 50).
+func ListPlans(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := db.DefaultPageSize
+		if value := r.URL.Query().Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > db.MaxPageSize {
+				writeError(w, "limit must be between 1 and 200", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		query := restrictToReviewer(r, s.DB)
+		if status := r.URL.Query().Get("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		plans := []models.Plan{}
+		if err := query.Order("id DESC").Limit(limit).Find(&plans).Error; err != nil {
+			s.Log.Error("Failed to list plans", "error", err)
+			writeError(w, "Failed to list plans", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(planListResponse{Plans: plans})
+	}
+}
+
+// GetPlan returns a task's plan with its subtasks
+func GetPlan(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plan, ok := ownedPlan(s, w, r)
+		if !ok {
+			return
+		}
+		writePlan(s, w, plan, http.StatusOK)
+	}
+}
+
+// AddPlanSubtask appends a subtask to a plan awaiting approval
+func AddPlanSubtask(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plan, ok := ownedPlan(s, w, r)
+		if !ok {
+			return
+		}
+		var req planSubtaskRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Type == nil || req.Description == nil {
+			writeAPIError(w, APIError{Code: "validation_failed", Message: "type and description are required"}, http.StatusBadRequest)
+			return
+		}
+
+		editPlan(s, w, plan, func(tx *gorm.DB, subtasks []models.Task) (*APIError, int, error) {
+			task := models.Task{
+				TaskId:   plan.TaskId,
+				Status:   models.PlanAwaitingApproval,
+				ClientId: plan.ClientId,
+				Tenant:   plan.Tenant,
+			}
+			for _, subtask := range subtasks {
+				task.SubtaskId = max(task.SubtaskId, subtask.SubtaskId)
+				task.TraceContext = subtask.TraceContext
+				task.Tags = subtask.Tags
+			}
+			task.SubtaskId++
+			req.apply(&task)
+			nameDependencies(&task, subtasks)
+
+			if apiErr := planError(append(subtasks, task)); apiErr != nil {
+				return apiErr, http.StatusBadRequest, nil
+			}
+			return nil, 0, tx.Create(&task).Error
+		})
+	}
+}
+
+// UpdatePlanSubtask edits a subtask of a plan awaiting approval
+func UpdatePlanSubtask(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plan, ok := ownedPlan(s, w, r)
+		if !ok {
+			return
+		}
+		subtaskId, err := subtaskParam(r)
+		if err != nil {
+			writeError(w, "Invalid subtask id", http.StatusBadRequest)
+			return
+		}
+		var req planSubtaskRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		editPlan(s, w, plan, func(tx *gorm.DB, subtasks []models.Task) (*APIError, int, error) {
+			i := slices.IndexFunc(subtasks, func(t models.Task) bool { return t.SubtaskId == subtaskId })
+			if i < 0 {
+				return &APIError{Code: "not_found", Message: "Subtask not found"}, http.StatusNotFound, nil
+			}
+			req.apply(&subtasks[i])
+			nameDependencies(&subtasks[i], subtasks)
+
+			if apiErr := planError(subtasks); apiErr != nil {
+				return apiErr, http.StatusBadRequest, nil
+			}
+			return nil, 0, tx.Save(&subtasks[i]).Error
+		})
+	}
+}
+
+// DeletePlanSubtask removes a subtask from a plan awaiting approval. Subtasks that others depend on cannot be removed.
+func DeletePlanSubtask(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plan, ok := ownedPlan(s, w, r)
+		if !ok {
+			return
+		}
+		subtaskId, err := subtaskParam(r)
+		if err != nil {
+			writeError(w, "Invalid subtask id", http.StatusBadRequest)
+			return
+		}
+
+		editPlan(s, w, plan, func(tx *gorm.DB, subtasks []models.Task) (*APIError, int, error) {
+			i := slices.IndexFunc(subtasks, func(t models.Task) bool { return t.SubtaskId == subtaskId })
+			if i < 0 {
+				return &APIError{Code: "not_found", Message: "Subtask not found"}, http.StatusNotFound, nil
+			}
+
+			var dependents []string
+			for _, subtask := range subtasks {
+				for _, dep := range subtask.Dependencies {
+					if dep.SubtaskId == subtaskId {
+						dependents = append(dependents, strconv.Itoa(subtask.SubtaskId))
+					}
+				}
+			}
+			if len(dependents) > 0 {
+				message := fmt.Sprintf("Subtasks %s depend on subtask %d", strings.Join(dependents, ", "), subtaskId)
+				return &APIError{Code: "conflict", Message: message}, http.StatusConflict, nil
+			}
+
+			// Hard delete: a subtask removed during review was never part of the task
+			return nil, 0, tx.Unscoped().Delete(&subtasks[i]).Error
+		})
+	}
+}
+
+// decodeReview reads the optional reviewer comment; an empty body is accepted
+func decodeReview(w http.ResponseWriter, r *http.Request) (reviewRequest, bool) {
+	var req reviewRequest
+	if r.ContentLength == 0 {
+		return req, true
+	}
+	return req, decodeJSON(w, r, &req)
+}
+
+// ApprovePlan queues every subtask of a plan. The graph, the task's budget and the tenant's queue quota are checked first.
+func ApprovePlan(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plan, ok := ownedPlan(s, w, r)
+		if !ok {
+			return
+		}
+		review, ok := decodeReview(w, r)
+		if !ok {
+			return
+		}
+		principal, _ := auth.FromContext(r.Context())
+		logger := s.Log.With("task_id", plan.TaskId, "reviewer", principal.ClientId)
+
+		tx := s.DB.Begin()
+		subtasks, err := lockOpenPlan(tx, plan)
+		if err != nil {
+			tx.Rollback()
+			if errors.Is(err, errPlanClosed) {
+				writeError(w, fmt.Sprintf("Plan is already %s", plan.Status), http.StatusConflict)
+				return
+			}
+			logger.Error("Failed to lock plan", "error", err)
+			writeError(w, "Failed to approve plan", http.StatusInternalServerError)
+			return
+		}
+
+		if len(subtasks) == 0 {
+			tx.Rollback()
+			writeError(w, "Plan has no subtasks", http.StatusUnprocessableEntity)
+			return
+		}
+		if apiErr := planError(subtasks); apiErr != nil {
+			tx.Rollback()
+			writeAPIError(w, *apiErr, http.StatusUnprocessableEntity)
+			return
+		}
+		var budget models.TaskBudget
+		if err := tx.Where("task_id = ?", plan.TaskId).First(&budget).Error; err == nil && budget.MaxSubtasks > 0 && len(subtasks) > budget.MaxSubtasks {
+			tx.Rollback()
+			reason := fmt.Sprintf("plan has %d subtasks, budget allows %d", len(subtasks), budget.MaxSubtasks)
+			writeAPIError(w, APIError{Code: "budget_exceeded", Message: reason}, http.StatusUnprocessableEntity)
+			return
+		}
+		if err := workers.CheckSubmissionQuota(s, plan.Tenant, len(subtasks)); err != nil {
+			tx.Rollback()
+			writeQuotaError(w, err)
+			return
+		}
+
+		now := time.Now().UTC()
+		plan.Status = models.PlanApproved
+		plan.ReviewedBy = principal.ClientId
+		plan.ReviewedAt = &now
+		plan.Comment = review.Comment
+		if err := tx.Save(plan).Error; err != nil {
+			tx.Rollback()
+			logger.Error("Failed to approve plan", "error", err)
+			writeError(w, "Failed to approve plan", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Model(&models.Task{}).Where("task_id = ?", plan.TaskId).Update("status", "pending").Error; err != nil {
+			tx.Rollback()
+			logger.Error("Failed to release plan subtasks", "error", err)
+			writeError(w, "Failed to approve plan", http.StatusInternalServerError)
+			return
+		}
+		for i := range subtasks {
+			subtasks[i].Status = "pending"
+		}
+		if err := workers.RecordInitialPlan(tx, plan.TaskId, plan.Description, subtasks); err != nil {
+			tx.Rollback()
+			logger.Error("Failed to record plan version", "error", err)
+			writeError(w, "Failed to approve plan", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			logger.Error("Failed to commit plan approval", "error", err)
+			writeError(w, "Failed to approve plan", http.StatusInternalServerError)
+			return
+		}
+		// Published only once the approval is committed, so nothing runs while the plan still awaits approval
+		if err := publishSubtasks(s, subtasks); err != nil {
+			logger.Error("Error publishing subtasks", "error", err)
+			writeError(w, "Failed to publish task to queue", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("Plan approved", "subtasks", len(subtasks))
+		workers.PublishTaskEvent(s, models.TaskEvent{TaskId: plan.TaskId, Event: "plan_approved", Detail: review.Comment})
+		writePlan(s, w, plan, http.StatusOK)
+	}
+}
+
+// RejectPlan closes a plan without running anything
+func RejectPlan(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plan, ok := ownedPlan(s, w, r)
+		if !ok {
+			return
+		}
+		review, ok := decodeReview(w, r)
+		if !ok {
+			return
+		}
+		principal, _ := auth.FromContext(r.Context())
+
+		rejected := editPlan(s, w, plan, func(tx *gorm.DB, subtasks []models.Task) (*APIError, int, error) {
+			now := time.Now().UTC()
+			plan.Status = models.PlanRejected
+			plan.ReviewedBy = principal.ClientId
+			plan.ReviewedAt = &now
+			plan.Comment = review.Comment
+			if err := tx.Save(plan).Error; err != nil {
+				return nil, 0, err
+			}
+			return nil, 0, tx.Model(&models.Task{}).Where("task_id = ?", plan.TaskId).Update("status", models.PlanRejected).Error
+		})
+		if rejected {
+			s.Log.Info("Plan rejected", "task_id", plan.TaskId, "reviewer", principal.ClientId)
+			workers.PublishTaskEvent(s, models.TaskEvent{TaskId: plan.TaskId, Event: "plan_rejected", Detail: review.Comment})
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/arnavsurve/promise/pkg/auth"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workers"
+	"gorm.io/gorm"
+)
+
+// replanRequest is the optional body accepted by POST /v1/tasks/{id}/replan
+type replanRequest struct {
+	Reason string `json:"reason,omitempty"` // Passed to the LLM, e.g. what went wrong
+}
+
+func (req *replanRequest) validate() error {
+	if len(req.Reason) > 2000 {
+		return invalidField("reason", "reason must be at most 2000 characters")
+	}
+	return nil
+}
+
+type planVersionListResponse struct {
+	Versions []models.PlanVersion `json:"versions"`
+}
+
+// ReplanTask asks the LLM to revise the subtasks of a task that have not started, given the results so far.
+// The revision replaces pending and skipped subtasks and is stored as the task's next plan version.
+func ReplanTask(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskId, err := taskIdParam(r)
+		if err != nil {
+			writeError(w, "Invalid task id", http.StatusBadRequest)
+			return
+		}
+		if !requireTaskOwner(s, w, r, taskId) {
+			return
+		}
+		var req replanRequest
+		if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+			return
+		}
+		principal, _ := auth.FromContext(r.Context())
+
+		version, err := workers.Replan(r.Context(), s, taskId, models.PlanVersionManual, req.Reason, principal.ClientId)
+		if err != nil {
+			switch {
+			case errors.Is(err, workers.ErrReplanInProgress):
+				writeError(w, "Task is already being replanned", http.StatusConflict)
+			case errors.Is(err, workers.ErrPlanNotApproved):
+				writeError(w, "Task's plan has not been approved", http.StatusConflict)
+			case errors.Is(err, workers.ErrSubtaskBudget):
+				writeAPIError(w, APIError{Code: "budget_exceeded", Message: err.Error()}, http.StatusUnprocessableEntity)
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				writeError(w, "Task not found", http.StatusNotFound)
+			default:
+				s.Log.Error("Replanning failed", "task_id", taskId, "error", err)
+				writeAPIError(w, APIError{Code: "replan_failed", Message: err.Error()}, http.StatusUnprocessableEntity)
+			}
+			return
+		}
+
+		s.Log.Info("Task replanned", "task_id", taskId, "version", version.Version, "client_id", principal.ClientId,
+			"replaced", len(version.Replaced), "added", len(version.Added))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(version)
+	}
+}
+
+// ListPlanVersions returns every revision of a task's plan, oldest first
+func ListPlanVersions(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskId, err := taskIdParam(r)
+		if err != nil {
+			writeError(w, "Invalid task id", http.StatusBadRequest)
+			return
+		}
+		if !requireTaskOwner(s, w, r, taskId) {
+			return
+		}
+
+		versions := []models.PlanVersion{}
+		if err := s.DB.Where("task_id = ?", taskId).Order("version").Find(&versions).Error; err != nil {
+			s.Log.Error("Failed to list plan versions", "task_id", taskId, "error", err)
+			writeError(w, "Failed to list plan versions", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(planVersionListResponse{Versions: versions})
+	}
+}
+
+// GetPlanVersion returns one revision of a task's plan
+func GetPlanVersion(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskId, err := taskIdParam(r)
+		if err != nil {
+			writeError(w, "Invalid task id", http.StatusBadRequest)
+			return
+		}
+		number, err := strconv.Atoi(r.PathValue("version"))
+		if err != nil || number < 1 {
+			writeError(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+		if !requireTaskOwner(s, w, r, taskId) {
+			return
+		}
+
+		var version models.PlanVersion
+		if err := s.DB.Where("task_id = ? AND version = ?", taskId, number).First(&version).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				writeError(w, "Plan version not found", http.StatusNotFound)
+			} else {
+				s.Log.Error("Failed to fetch plan version", "task_id", taskId, "version", number, "error", err)
+				writeError(w, "Failed to fetch plan version", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(version)
+	}
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/arnavsurve/promise/pkg/auth"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workers"
+)
+
+// Route is one operation of the versioned API. The same table registers the handlers and generates the OpenAPI document.
+type Route struct {
+	Method      string
+	Path        string // ServeMux path, path params in braces, e.g. /v1/jobs/{id}
+	OperationId string
+	Summary     string
+	Tag         string
+	Query       []Param
+	Request     interface{} // Zero value of the JSON body type, nil when the operation takes no body
+	AcceptsYAML bool        // The body may also be sent as application/yaml
+	Response    interface{} // Zero value of the success response type
+	Status      int         // Success status, 200 when zero
+	Scope       string      // API key scope required to call the route
+	Handler     http.HandlerFunc
+}
+
+// Param documents a query parameter
+type Param struct {
+	Name        string
+	Type        string // OpenAPI primitive type, "string" when empty
+	Description string
+	Required    bool
+}
+
+// Pattern is the Go 1.22 ServeMux pattern for the route
+func (route Route) Pattern() string {
+	return route.Method + " " + route.Path
+}
+
+var listParams = []Param{
+	{Name: "status", Description: "Exact status"},
+	{Name: "client_id", Description: "Owning client; admins only, other callers always see their own"},
+	{Name: "tenant", Description: "Tenant namespace"},
+	{Name: "search", Description: "Case-insensitive substring of the command or description"},
+	{Name: "tags", Description: "Comma-separated tags, all of which must be present"},
+	{Name: "created_after", Description: "RFC 3339 timestamp, inclusive"},
+	{Name: "created_before", Description: "RFC 3339 timestamp, exclusive"},
+	{Name: "sort", Description: "created_at (default), updated_at or id"},
+	{Name: "order", Description: "desc (default) or asc"},
+	{Name: "cursor", Description: "next_cursor from the previous page"},
+	{Name: "limit", Type: "integer", Description: "Page size, 1-200, default 50"},
+}
+
+// V1Routes is the versioned API served under /v1
+func V1Routes(s *db.Store) []Route {
+	return []Route{
+		{
+			Method: http.MethodPost, Path: "/v1/jobs", OperationId: "createJob", Tag: "jobs", Scope: auth.ScopeSubmit,
+			Summary: "Queue a shell job", Request: enqueueJobRequest{}, Response: enqueueJobResponse{}, Status: http.StatusCreated,
+			Handler: RejectWhenDraining(EnqueueJob(s)),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/jobs", OperationId: "listJobs", Tag: "jobs", Scope: auth.ScopeRead,
+			Summary: "List shell jobs", Query: listParams, Response: jobListResponse{},
+			Handler: ListJobs(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/jobs/{id}", OperationId: "getJob", Tag: "jobs", Scope: auth.ScopeRead,
+			Summary:  "Get a shell job's status",
+			Query:    []Param{{Name: "timezone", Description: "IANA time zone for executed_at, UTC by default"}},
+			Response: jobStatusResponse{},
+			Handler:  GetJobStatus(s),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/tasks", OperationId: "createTask", Tag: "tasks", Scope: auth.ScopeDecompose,
+			Summary: "Decompose a task into subtasks and queue them", Request: decomposeRequest{}, Response: decomposeResponse{},
+			Status:  http.StatusCreated,
+			Handler: RejectWhenDraining(EnqueueJobWithDecomposition(s)),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/tasks", OperationId: "listSubtasks", Tag: "tasks", Scope: auth.ScopeRead,
+			Summary: "List subtasks across tasks",
+			Query: append([]Param{
+				{Name: "type", Description: "Subtask type"},
+				{Name: "task_id", Description: "Parent task id"},
+			}, listParams...),
+			Response: taskListResponse{},
+			Handler:  ListTasks(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/tasks/{id}", OperationId: "getTask", Tag: "tasks", Scope: auth.ScopeRead,
+			Summary: "Get a task and its subtasks", Response: taskDetailResponse{},
+			Handler: GetTask(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/tasks/{id}/budget", OperationId: "getTaskBudget", Tag: "tasks", Scope: auth.ScopeRead,
+			Summary: "Get a task's budget and spend", Response: workers.BudgetStatus{},
+			Handler: GetTaskBudget(s),
+		},
+		{
+			Method: http.MethodPut, Path: "/v1/tasks/{id}/budget", OperationId: "updateTaskBudget", Tag: "tasks", Scope: auth.ScopeDecompose,
+			Summary: "Change a task's token and cost limits", Request: updateBudgetRequest{}, Response: workers.BudgetStatus{},
+			Handler: UpdateTaskBudget(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/costs", OperationId: "getCostReport", Tag: "usage", Scope: auth.ScopeRead,
+			Summary: "LLM usage and cost rolled up per task or client",
+			Query: []Param{
+				{Name: "group_by", Description: "task (default) or client"},
+				{Name: "task_id", Description: "Restrict to one task and include per-call rows"},
+				{Name: "client_id", Description: "Restrict to one client; admins only"},
+			},
+			Response: costReportResponse{},
+			Handler:  GetCostReport(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/workers", OperationId: "listWorkers", Tag: "workers", Scope: auth.ScopeAdmin,
+			Summary: "List live workers across nodes", Response: workerListResponse{},
+			Handler: ListWorkers(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/workers/autoscale", OperationId: "getAutoscaleStatus", Tag: "workers", Scope: auth.ScopeAdmin,
+			Summary: "Autoscaler configuration, metrics and recent events", Response: autoscaleStatusResponse{},
+			Handler: GetAutoscaleStatus(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/workers/resources", OperationId: "listResourceUsage", Tag: "workers", Scope: auth.ScopeAdmin,
+			Summary: "Concurrency limits and current occupancy", Response: resourceUsageResponse{},
+			Handler: ListResourceUsage(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/admin/drain", OperationId: "getDrainStatus", Tag: "admin", Scope: auth.ScopeAdmin,
+			Summary: "Whether this node is draining", Response: drainStatusResponse{},
+			Handler: GetDrainStatus(),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/admin/drain", OperationId: "setDrainMode", Tag: "admin", Scope: auth.ScopeAdmin,
+			Summary: "Put this node into or out of drain mode", Request: drainRequest{}, Response: drainStatusResponse{},
+			Handler: SetDrainMode(),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/plans", OperationId: "listPlans", Tag: "plans", Scope: auth.ScopeRead,
+			Summary: "List plans, newest first",
+			Query: []Param{
+				{Name: "status", Description: "awaiting_approval, approved or rejected"},
+				{Name: "limit", Type: "integer", Description: "Number of plans, 1-200, default 50"},
+			},
+			Response: planListResponse{},
+			Handler:  ListPlans(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/tasks/{id}/plan", OperationId: "getPlan", Tag: "plans", Scope: auth.ScopeRead,
+			Summary: "Plan of a task submitted in plan mode, with its subtasks", Response: planResponse{},
+			Handler: GetPlan(s),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/tasks/{id}/plan/subtasks", OperationId: "addPlanSubtask", Tag: "plans", Scope: auth.ScopeApprove,
+			Summary: "Add a subtask to a plan awaiting approval", Request: planSubtaskRequest{}, Response: planResponse{},
+			Handler: AddPlanSubtask(s),
+		},
+		{
+			Method: http.MethodPatch, Path: "/v1/tasks/{id}/plan/subtasks/{subtask}", OperationId: "updatePlanSubtask", Tag: "plans", Scope: auth.ScopeApprove,
+			Summary: "Edit a subtask of a plan awaiting approval", Request: planSubtaskRequest{}, Response: planResponse{},
+			Handler: UpdatePlanSubtask(s),
+		},
+		{
+			Method: http.MethodDelete, Path: "/v1/tasks/{id}/plan/subtasks/{subtask}", OperationId: "deletePlanSubtask", Tag: "plans", Scope: auth.ScopeApprove,
+			Summary: "Remove a subtask from a plan awaiting approval", Response: planResponse{},
+			Handler: DeletePlanSubtask(s),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/tasks/{id}/plan/approve", OperationId: "approvePlan", Tag: "plans", Scope: auth.ScopeApprove,
+			Summary: "Approve a plan and queue its subtasks", Request: reviewRequest{}, Response: planResponse{},
+			Handler: RejectWhenDraining(ApprovePlan(s)),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/tasks/{id}/plan/reject", OperationId: "rejectPlan", Tag: "plans", Scope: auth.ScopeApprove,
+			Summary: "Reject a plan without running it", Request: reviewRequest{}, Response: planResponse{},
+			Handler: RejectPlan(s),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/tasks/{id}/replan", OperationId: "replanTask", Tag: "plans", Scope: auth.ScopeDecompose,
+			Summary: "Ask the LLM to revise the subtasks that have not started", Request: replanRequest{}, Response: models.PlanVersion{},
+			Handler: RejectWhenDraining(ReplanTask(s)),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/tasks/{id}/versions", OperationId: "listPlanVersions", Tag: "plans", Scope: auth.ScopeRead,
+			Summary: "Every revision of a task's plan, oldest first", Response: planVersionListResponse{},
+			Handler: ListPlanVersions(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/tasks/{id}/versions/{version}", OperationId: "getPlanVersion", Tag: "plans", Scope: auth.ScopeRead,
+			Summary: "One revision of a task's plan", Response: models.PlanVersion{},
+			Handler: GetPlanVersion(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/tasks/{id}/artifacts", OperationId: "listArtifacts", Tag: "artifacts", Scope: auth.ScopeRead,
+			Summary:  "Files produced by a task's subtasks",
+			Query:    []Param{{Name: "subtask_id", Type: "integer", Description: "Restrict to one subtask"}},
+			Response: artifactListResponse{},
+			Handler:  ListArtifacts(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/tasks/{id}/artifacts/{artifact}", OperationId: "downloadArtifact", Tag: "artifacts", Scope: auth.ScopeRead,
+			Summary: "Download the content of an artifact as application/octet-stream",
+			Handler: DownloadArtifact(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/approvals", OperationId: "listApprovals", Tag: "approvals", Scope: auth.ScopeRead,
+			Summary: "List generated commands held by risk rules, newest first",
+			Query: []Param{
+				{Name: "status", Description: "awaiting_approval, approved or rejected"},
+				{Name: "task_id", Description: "Restrict to one task"},
+				{Name: "limit", Type: "integer", Description: "Number of approvals, 1-200, default 50"},
+			},
+			Response: approvalListResponse{},
+			Handler:  ListApprovals(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/approvals/{id}", OperationId: "getApproval", Tag: "approvals", Scope: auth.ScopeRead,
+			Summary: "A held command and the risk rules it matched", Response: models.CommandApproval{},
+			Handler: GetApproval(s),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/approvals/{id}/approve", OperationId: "approveCommand", Tag: "approvals", Scope: auth.ScopeApprove,
+			Summary: "Run a held command as generated; the submitting client cannot approve its own commands", Request: reviewRequest{}, Response: models.CommandApproval{},
+			Handler: ApproveCommand(s),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/approvals/{id}/reject", OperationId: "rejectCommand", Tag: "approvals", Scope: auth.ScopeApprove,
+			Summary: "Reject a held command; its subtask is marked rejected", Request: reviewRequest{}, Response: models.CommandApproval{},
+			Handler: RejectCommand(s),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/workflows", OperationId: "createWorkflow", Tag: "tasks", Scope: auth.ScopeDecompose,
+			Summary: "Queue a declarative workflow as a task, without LLM decomposition", Request: createWorkflowRequest{}, AcceptsYAML: true,
+			Response: workflowResponse{}, Status: http.StatusCreated,
+			Handler: RejectWhenDraining(CreateWorkflow(s)),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/webhooks", OperationId: "createWebhook", Tag: "webhooks", Scope: auth.ScopeWebhooks,
+			Summary: "Register a webhook for job and task events", Request: createWebhookRequest{}, Response: webhookResponse{}, Status: http.StatusCreated,
+			Handler: CreateWebhook(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/webhooks", OperationId: "listWebhooks", Tag: "webhooks", Scope: auth.ScopeRead,
+			Summary: "List webhooks", Response: webhookListResponse{},
+			Handler: ListWebhooks(s),
+		},
+		{
+			Method: http.MethodDelete, Path: "/v1/webhooks/{id}", OperationId: "deleteWebhook", Tag: "webhooks", Scope: auth.ScopeWebhooks,
+			Summary: "Delete a webhook", Status: http.StatusNoContent,
+			Handler: DeleteWebhook(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/webhooks/{id}/deliveries", OperationId: "listWebhookDeliveries", Tag: "webhooks", Scope: auth.ScopeRead,
+			Summary: "Delivery log of a webhook, newest first",
+			Query: []Param{
+				{Name: "status", Description: "pending, delivered or failed"},
+				{Name: "limit", Type: "integer", Description: "Number of deliveries, 1-200, default 50"},
+			},
+			Response: deliveryListResponse{},
+			Handler:  ListDeliveries(s),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/webhooks/{id}/deliveries/{delivery}/redeliver", OperationId: "redeliverWebhook", Tag: "webhooks", Scope: auth.ScopeWebhooks,
+			Summary: "Send a past delivery again", Status: http.StatusAccepted,
+			Handler: RedeliverWebhook(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/tenant", OperationId: "getOwnTenant", Tag: "tenants", Scope: auth.ScopeRead,
+			Summary: "Quotas and usage of the caller's tenant", Response: workers.TenantUsage{},
+			Handler: GetOwnTenant(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/admin/tenants", OperationId: "listTenants", Tag: "tenants", Scope: auth.ScopeAdmin,
+			Summary: "List configured tenants", Response: tenantListResponse{},
+			Handler: ListTenants(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/admin/tenants/{name}", OperationId: "getTenant", Tag: "tenants", Scope: auth.ScopeAdmin,
+			Summary: "Quotas and usage of a tenant", Response: workers.TenantUsage{},
+			Handler: GetTenant(s),
+		},
+		{
+			Method: http.MethodPut, Path: "/v1/admin/tenants/{name}", OperationId: "saveTenant", Tag: "tenants", Scope: auth.ScopeAdmin,
+			Summary: "Create a tenant or replace its quotas", Request: tenantRequest{}, Response: models.Tenant{},
+			Handler: SaveTenant(s),
+		},
+		{
+			Method: http.MethodPost, Path: "/v1/admin/keys", OperationId: "createApiKey", Tag: "admin", Scope: auth.ScopeAdmin,
+			Summary: "Issue an API key for a client", Request: createKeyRequest{}, Response: createKeyResponse{}, Status: http.StatusCreated,
+			Handler: CreateAPIKey(s),
+		},
+		{
+			Method: http.MethodGet, Path: "/v1/admin/keys", OperationId: "listApiKeys", Tag: "admin", Scope: auth.ScopeAdmin,
+			Summary: "List API keys", Query: []Param{{Name: "client_id", Description: "Restrict to one client"}}, Response: keyListResponse{},
+			Handler: ListAPIKeys(s),
+		},
+		{
+			Method: http.MethodDelete, Path: "/v1/admin/keys/{id}", OperationId: "revokeApiKey", Tag: "admin", Scope: auth.ScopeAdmin,
+			Summary: "Revoke an API key", Status: http.StatusNoContent,
+			Handler: RevokeAPIKey(s),
+		},
+	}
+}
+
+// RegisterV1 registers the versioned API and its OpenAPI document on mux
+func RegisterV1(mux *http.ServeMux, s *db.Store) {
+	routes := V1Routes(s)
+	for _, route := range routes {
+		mux.HandleFunc(route.Pattern(), RequireScope(s, route.Scope, route.Handler))
+	}
+	mux.HandleFunc("GET /v1/openapi.json", ServeOpenAPI(routes))
+}
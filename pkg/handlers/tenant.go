@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/arnavsurve/promise/pkg/auth"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workers"
+)
+
+// writeQuotaError reports a tenant quota violation as 429, or any other error as 500
+func writeQuotaError(w http.ResponseWriter, err error) {
+	if errors.Is(err, workers.ErrQuotaExceeded) {
+		writeAPIError(w, APIError{Code: "quota_exceeded", Message: err.Error()}, http.StatusTooManyRequests)
+		return
+	}
+	writeError(w, "Failed to check tenant quota", http.StatusInternalServerError)
+}
+
+// tenantRequest is the body accepted by PUT /v1/admin/tenants/{name}. Zero quotas are unlimited.
+type tenantRequest struct {
+	MaxConcurrentSubtasks int64 `json:"max_concurrent_subtasks"`
+	MaxQueuedSubtasks     int64 `json:"max_queued_subtasks"`
+	MaxTokensPerDay       int64 `json:"max_tokens_per_day"`
+	MaxStorageBytes       int64 `json:"max_storage_bytes"`
+	Weight                int64 `json:"weight"` // 1 when omitted
+}
+
+func (req *tenantRequest) validate() error {
+	if req.MaxConcurrentSubtasks < 0 || req.MaxQueuedSubtasks < 0 || req.MaxTokensPerDay < 0 || req.MaxStorageBytes < 0 {
+		return invalidField("", "quotas cannot be negative")
+	}
+	if req.Weight < 0 {
+		return invalidField("weight", "weight cannot be negative")
+	}
+	if req.Weight == 0 {
+		req.Weight = 1
+	}
+	return nil
+}
+
+type tenantListResponse struct {
+	Tenants []models.Tenant `json:"tenants"`
+}
+
+// GetOwnTenant returns the quotas and current usage of the caller's tenant
+func GetOwnTenant(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.FromContext(r.Context())
+		usage, err := workers.GetTenantUsage(s, principal.Tenant)
+		if err != nil {
+			s.Log.Error("Failed to read tenant usage", "tenant", principal.Tenant, "error", err)
+			writeError(w, "Failed to read tenant usage", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage)
+	}
+}
+
+// GetTenant returns any tenant's quotas and current usage
+func GetTenant(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if err := workers.ValidateTenantName(name); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		usage, err := workers.GetTenantUsage(s, name)
+		if err != nil {
+			s.Log.Error("Failed to read tenant usage", "tenant", name, "error", err)
+			writeError(w, "Failed to read tenant usage", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage)
+	}
+}
+
+// ListTenants returns every configured tenant
+func ListTenants(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenants, err := workers.ListTenants(s)
+		if err != nil {
+			s.Log.Error("Failed to list tenants", "error", err)
+			writeError(w, "Failed to list tenants", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tenantListResponse{Tenants: tenants})
+	}
+}
+
+// SaveTenant creates a tenant or replaces its quotas
+func SaveTenant(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if err := workers.ValidateTenantName(name); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var req tenantRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		tenant, err := workers.SaveTenant(s, models.Tenant{
+			Name:                  name,
+			MaxConcurrentSubtasks: req.MaxConcurrentSubtasks,
+			MaxQueuedSubtasks:     req.MaxQueuedSubtasks,
+			MaxTokensPerDay:       req.MaxTokensPerDay,
+			MaxStorageBytes:       req.MaxStorageBytes,
+			Weight:                req.Weight,
+		})
+		if err != nil {
+			s.Log.Error("Failed to save tenant", "tenant", name, "error", err)
+			writeError(w, "Failed to save tenant", http.StatusInternalServerError)
+			return
+		}
+		s.Log.Info("Tenant saved", "tenant", name)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tenant)
+	}
+}
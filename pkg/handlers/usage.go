@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+)
+
+type costReportResponse struct {
+	GroupBy string               `json:"group_by"`
+	Report  []models.UsageReport `json:"report"`
+	Calls   []models.LLMUsage    `json:"calls,omitempty"` // Only with task_id
+}
+
+// GetCostReport returns LLM token usage and cost rolled up per task or per client.
+// Query params: group_by (task|client, default task), task_id, client_id (admins only). With task_id, per-call rows are included.
+// Non-admin callers only see their own usage.
+func GetCostReport(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		queryParams := r.URL.Query()
+		groupBy := queryParams.Get("group_by")
+		taskId := queryParams.Get("task_id")
+		clientId := ownedClientFilter(r)
+
+		if groupBy == "" {
+			groupBy = "task"
+		}
+		if groupBy != "task" && groupBy != "client" {
+			writeError(w, "group_by must be 'task' or 'client'", http.StatusBadRequest)
+			return
+		}
+
+		reports, err := s.UsageReport(groupBy, taskId, clientId)
+		if err != nil {
+			s.Log.Error("Failed to build cost report", "error", err)
+			writeError(w, "Failed to build cost report", http.StatusInternalServerError)
+			return
+		}
+
+		response := costReportResponse{
+			GroupBy: groupBy,
+			Report:  reports,
+		}
+
+		if taskId != "" {
+			var calls []models.LLMUsage
+			if err := restrictToOwner(r, s.DB).Where("task_id = ?", taskId).Order("subtask_id, created_at").Find(&calls).Error; err != nil {
+				s.Log.Error("Failed to fetch LLM usage", "task_id", taskId, "error", err)
+				writeError(w, "Failed to fetch LLM usage", http.StatusInternalServerError)
+				return
+			}
+			response.Calls = calls
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
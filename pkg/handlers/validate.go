@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxBodyBytes bounds request bodies so a client cannot make the server buffer arbitrary amounts of JSON
+const maxBodyBytes = 1 << 20
+
+// validator is implemented by request bodies that check their own fields after decoding
+type validator interface {
+	validate() error
+}
+
+// fieldError reports which request field failed validation
+type fieldError struct {
+	field   string
+	message string
+}
+
+func (e *fieldError) Error() string {
+	return e.message
+}
+
+func invalidField(field string, format string, args ...interface{}) error {
+	return &fieldError{field: field, message: fmt.Sprintf(format, args...)}
+}
+
+// decodeJSON decodes the request body into dst, rejecting unknown fields, and runs dst's validation.
+// On failure it writes a 400 error envelope and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(dst); err != nil {
+		message := fmt.Sprintf("Invalid JSON body: %s", err)
+		if errors.Is(err, io.EOF) {
+			message = "Request body is required"
+		}
+		writeError(w, message, http.StatusBadRequest)
+		return false
+	}
+
+	if v, ok := dst.(validator); ok {
+		if err := v.validate(); err != nil {
+			apiErr := APIError{Code: "validation_failed", Message: err.Error()}
+			var fe *fieldError
+			if errors.As(err, &fe) {
+				apiErr.Field = fe.field
+			}
+			writeAPIError(w, apiErr, http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantOK      bool
+		wantCode    string
+		wantField   string
+		wantMessage string
+	}{
+		{name: "valid", body: `{"command":"ls","tags":["a"]}`, wantOK: true},
+		{name: "empty body", body: ``, wantCode: "invalid_request", wantMessage: "Request body is required"},
+		{name: "malformed", body: `{"command":`, wantCode: "invalid_request", wantMessage: "Invalid JSON body"},
+		{name: "wrong type", body: `{"command":1}`, wantCode: "invalid_request", wantMessage: "Invalid JSON body"},
+		{name: "unknown field", body: `{"command":"ls","cmd":"ls"}`, wantCode: "invalid_request", wantMessage: `unknown field "cmd"`},
+		{name: "too large", body: `{"command":"` + strings.Repeat("x", maxBodyBytes) + `"}`, wantCode: "invalid_request", wantMessage: "Invalid JSON body"},
+		{name: "blank command", body: `{"command":"  "}`, wantCode: "validation_failed", wantField: "command", wantMessage: "command cannot be empty"},
+		{name: "comma in tag", body: `{"command":"ls","tags":["a,b"]}`, wantCode: "validation_failed", wantField: "tags"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/v1/jobs", strings.NewReader(tt.body))
+			var req enqueueJobRequest
+			ok := decodeJSON(w, r, &req)
+			if ok != tt.wantOK {
+				t.Fatalf("decodeJSON() = %v, want %v (response %s)", ok, tt.wantOK, w.Body.String())
+			}
+			if tt.wantOK {
+				if w.Body.Len() != 0 {
+					t.Errorf("decodeJSON wrote %q for a valid body", w.Body.String())
+				}
+				return
+			}
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", w.Code)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			var envelope errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+				t.Fatalf("response %q is not an error envelope: %v", w.Body.String(), err)
+			}
+			if envelope.Error.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", envelope.Error.Code, tt.wantCode)
+			}
+			if envelope.Error.Field != tt.wantField {
+				t.Errorf("field = %q, want %q", envelope.Error.Field, tt.wantField)
+			}
+			if !strings.Contains(envelope.Error.Message, tt.wantMessage) {
+				t.Errorf("message = %q, want it to contain %q", envelope.Error.Message, tt.wantMessage)
+			}
+		})
+	}
+}
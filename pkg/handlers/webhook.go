@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/arnavsurve/promise/pkg/auth"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/webhooks"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// createWebhookRequest is the body accepted by POST /v1/webhooks. Without task_id or job_id the webhook is tenant-wide.
+type createWebhookRequest struct {
+	URL        string     `json:"url"`
+	Events     []string   `json:"events,omitempty"` // All events when omitted
+	TaskId     *uuid.UUID `json:"task_id,omitempty"`
+	JobId      *uint      `json:"job_id,omitempty"`
+	AllClients bool       `json:"all_clients,omitempty"` // Admins only: receive every client's events in the tenant
+}
+
+func (req *createWebhookRequest) validate() error {
+	if err := webhooks.ValidateURL(req.URL); err != nil {
+		return invalidField("url", "%s", err)
+	}
+	if req.TaskId != nil && req.JobId != nil {
+		return invalidField("task_id", "a webhook is scoped to a task or a job, not both")
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(webhooks.Events, event) {
+			return invalidField("events", "unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// webhookResponse carries the signing secret, which is only ever returned when the webhook is created
+type webhookResponse struct {
+	Webhook models.Webhook `json:"webhook"`
+	Secret  string         `json:"secret"`
+}
+
+type webhookListResponse struct {
+	Webhooks []models.Webhook `json:"webhooks"`
+}
+
+type deliveryListResponse struct {
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+}
+
+// ownedWebhook loads the webhook named by the {id} path segment if the caller owns it, writing an error otherwise
+func ownedWebhook(s *db.Store, w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "Invalid webhook id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var hook models.Webhook
+	if err := restrictToOwner(r, s.DB).Where("id = ?", id).First(&hook).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeError(w, "Webhook not found", http.StatusNotFound)
+		} else {
+			s.Log.Error("Failed to fetch webhook", "webhook_id", id, "error", err)
+			writeError(w, "Failed to fetch webhook", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return &hook, true
+}
+
+// CreateWebhook registers a URL to receive signed event notifications
+func CreateWebhook(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req createWebhookRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		principal, _ := auth.FromContext(r.Context())
+		if req.AllClients && !principal.IsAdmin() {
+			writeAPIError(w, APIError{Code: "insufficient_scope", Message: "all_clients webhooks require the admin scope", Field: "all_clients"}, http.StatusForbidden)
+			return
+		}
+		if req.TaskId != nil && !requireTaskOwner(s, w, r, *req.TaskId) {
+			return
+		}
+		if req.JobId != nil {
+			var count int64
+			if err := restrictToOwner(r, s.DB.Model(&models.Job{})).Where("id = ?", *req.JobId).Count(&count).Error; err != nil || count == 0 {
+				writeError(w, "Job not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			writeError(w, "Failed to generate webhook secret", http.StatusInternalServerError)
+			return
+		}
+		hook := models.Webhook{
+			ClientId:   principal.ClientId,
+			Tenant:     principal.Tenant,
+			URL:        req.URL,
+			Secret:     secret,
+			Events:     req.Events,
+			TaskId:     req.TaskId,
+			JobId:      req.JobId,
+			AllClients: req.AllClients,
+		}
+		if err := s.DB.Create(&hook).Error; err != nil {
+			s.Log.Error("Failed to store webhook", "error", err)
+			writeError(w, "Failed to store webhook", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(webhookResponse{Webhook: hook, Secret: secret})
+	}
+}
+
+// ListWebhooks returns the caller's webhooks, or every webhook for admins
+func ListWebhooks(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hooks := []models.Webhook{}
+		if err := restrictToOwner(r, s.DB).Order("id").Find(&hooks).Error; err != nil {
+			s.Log.Error("Failed to list webhooks", "error", err)
+			writeError(w, "Failed to list webhooks", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(webhookListResponse{Webhooks: hooks})
+	}
+}
+
+// DeleteWebhook stops deliveries to a webhook. Its delivery log is kept.
+func DeleteWebhook(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook, ok := ownedWebhook(s, w, r)
+		if !ok {
+			return
+		}
+		if err := s.DB.Delete(hook).Error; err != nil {
+			s.Log.Error("Failed to delete webhook", "webhook_id", hook.ID, "error", err)
+			writeError(w, "Failed to delete webhook", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ListDeliveries returns a webhook's most recent deliveries, newest first. Query params: status, limit (default 50).
+func ListDeliveries(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook, ok := ownedWebhook(s, w, r)
+		if !ok {
+			return
+		}
+
+		limit := 50
+		if value := r.URL.Query().Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > db.MaxPageSize {
+				writeError(w, "limit must be between 1 and 200", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		query := s.DB.Where("webhook_id = ?", hook.ID)
+		if status := r.URL.Query().Get("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		deliveries := []models.WebhookDelivery{}
+		if err := query.Order("id DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+			s.Log.Error("Failed to list webhook deliveries", "webhook_id", hook.ID, "error", err)
+			writeError(w, "Failed to list webhook deliveries", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveryListResponse{Deliveries: deliveries})
+	}
+}
+
+// RedeliverWebhook queues a past delivery to be sent again with a fresh retry budget
+func RedeliverWebhook(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook, ok := ownedWebhook(s, w, r)
+		if !ok {
+			return
+		}
+
+		var delivery models.WebhookDelivery
+		if err := s.DB.Where("id = ? AND webhook_id = ?", r.PathValue("delivery"), hook.ID).First(&delivery).Error; err != nil {
+			writeError(w, "Delivery not found", http.StatusNotFound)
+			return
+		}
+		if err := webhooks.Redeliver(s, delivery); err != nil {
+			s.Log.Error("Failed to queue redelivery", "delivery_id", delivery.ID, "error", err)
+			writeError(w, "Failed to queue redelivery", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
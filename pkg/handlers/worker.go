@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workers"
+)
+
+type workerListResponse struct {
+	Count   int                 `json:"count"`
+	Workers []models.WorkerInfo `json:"workers"`
+}
+
+type autoscaleConfigResponse struct {
+	Policy       string `json:"policy"`
+	MinWorkers   int32  `json:"min_workers"`
+	MaxWorkers   int32  `json:"max_workers"`
+	Cooldown     string `json:"cooldown"`
+	PollInterval string `json:"poll_interval"`
+	IdleTimeout  string `json:"idle_timeout"`
+}
+
+type autoscaleStatusResponse struct {
+	Config  autoscaleConfigResponse  `json:"config"`
+	Metrics workers.AutoscaleMetrics `json:"metrics"`
+	Events  []workers.ScalingEvent   `json:"events"`
+}
+
+type resourceUsageResponse struct {
+	Resources []workers.ResourceUsage `json:"resources"`
+}
+
+// ListWorkers returns all live workers across nodes and the subtask each one is currently running
+func ListWorkers(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		liveWorkers, err := workers.ListWorkers(s)
+		if err != nil {
+			s.Log.Error("Failed to list workers", "error", err)
+			writeError(w, "Failed to list workers", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(workerListResponse{
+			Count:   len(liveWorkers),
+			Workers: liveWorkers,
+		})
+	}
+}
+
+// GetAutoscaleStatus returns the autoscaler configuration, its current metrics and the most recent scaling events
+func GetAutoscaleStatus(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events, err := workers.ListScalingEvents(s, 50)
+		if err != nil {
+			s.Log.Error("Failed to list scaling events", "error", err)
+			writeError(w, "Failed to list scaling events", http.StatusInternalServerError)
+			return
+		}
+
+		cfg := workers.GetAutoscaleConfig()
+		metrics := workers.GetAutoscaleMetrics()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(autoscaleStatusResponse{
+			Config: autoscaleConfigResponse{
+				Policy:       metrics.Policy,
+				MinWorkers:   cfg.MinWorkers,
+				MaxWorkers:   cfg.MaxWorkers,
+				Cooldown:     cfg.Cooldown.String(),
+				PollInterval: cfg.PollInterval.String(),
+				IdleTimeout:  cfg.IdleTimeout.String(),
+			},
+			Metrics: metrics,
+			Events:  events,
+		})
+	}
+}
+
+// ListResourceUsage returns each concurrency-limited task type and named resource with its limit and current occupancy
+func ListResourceUsage(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usage, err := workers.ListResourceUsage(s)
+		if err != nil {
+			s.Log.Error("Failed to read resource usage", "error", err)
+			writeError(w, "Failed to read resource usage", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resourceUsageResponse{Resources: usage})
+	}
+}
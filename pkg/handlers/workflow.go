@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/arnavsurve/promise/pkg/auth"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/metrics"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/tracing"
+	"github.com/arnavsurve/promise/pkg/workers"
+	"github.com/arnavsurve/promise/pkg/workflow"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// createWorkflowRequest is the body accepted by POST /v1/workflows, as JSON or YAML
+type createWorkflowRequest struct {
+	workflow.Definition
+	Tags       []string       `json:"tags,omitempty"`
+	Budget     *budgetRequest `json:"budget,omitempty"`
+	WebhookURL string         `json:"webhook_url,omitempty"` // Receives this task's events
+	Plan       bool           `json:"plan,omitempty"`        // Hold the steps for review instead of queueing them
+}
+
+func (req *createWorkflowRequest) validate() error {
+	if err := req.Definition.Validate(); err != nil {
+		var we *workflow.Error
+		if errors.As(err, &we) {
+			return invalidField(we.Field, "%s", we.Message)
+		}
+		return err
+	}
+	if err := validateBudget(req.Budget); err != nil {
+		return err
+	}
+	if err := validateWebhookURL(req.WebhookURL); err != nil {
+		return err
+	}
+	return validateTags(req.Tags)
+}
+
+type workflowResponse struct {
+	Message string                `json:"message"`
+	TaskId  uuid.UUID             `json:"task_id"`
+	Name    string                `json:"name,omitempty"`
+	Budget  *budgetRequest        `json:"budget"`
+	Tasks   []models.TaskResponse `json:"tasks"`
+	Webhook *submissionWebhook    `json:"webhook,omitempty"`
+}
+
+// isYAML reports whether the request body is declared as YAML
+func isYAML(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
+
+// decodeWorkflow decodes a JSON or YAML workflow. YAML is converted to JSON first so both get the same strict decoding.
+func decodeWorkflow(w http.ResponseWriter, r *http.Request, dst *createWorkflowRequest) bool {
+	if isYAML(r) {
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to read request body: %s", err), http.StatusBadRequest)
+			return false
+		}
+		converted, err := workflow.YAMLToJSON(data)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Invalid YAML body: %s", err), http.StatusBadRequest)
+			return false
+		}
+		r.Body = io.NopCloser(bytes.NewReader(converted))
+	}
+	return decodeJSON(w, r, dst)
+}
+
+// CreateWorkflow queues a declarative subtask DAG as a task, skipping LLM decomposition
+func CreateWorkflow(s *db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req createWorkflowRequest
+		if !decodeWorkflow(w, r, &req) {
+			return
+		}
+
+		taskId := uuid.New()
+		principal, _ := auth.FromContext(r.Context())
+
+		if err := workers.CheckSubmissionQuota(s, principal.Tenant, len(req.Steps)); err != nil {
+			writeQuotaError(w, err)
+			return
+		}
+
+		// The workflow span is the root of the task's trace, as the decomposition span is for LLM-planned tasks
+		spanCtx, span := tracing.Tracer.Start(r.Context(), "workflow", trace.WithAttributes(
+			attribute.String("task.id", taskId.String()),
+			attribute.String("workflow.name", req.Name),
+			attribute.String("client.id", principal.ClientId),
+			attribute.String("tenant", principal.Tenant),
+		))
+		defer span.End()
+
+		logger := s.Log.With("task_id", taskId, "client_id", principal.ClientId, "tenant", principal.Tenant)
+
+		tasks := req.Tasks(taskId)
+		traceContext := tracing.Inject(spanCtx)
+		for i := range tasks {
+			tasks[i].ClientId = principal.ClientId
+			tasks[i].Tenant = principal.Tenant
+			tasks[i].TraceContext = traceContext
+			tasks[i].Tags = req.Tags
+		}
+
+		tx := s.DB.Begin()
+
+		var budget *models.TaskBudget
+		if req.Budget != nil {
+			budget = newTaskBudget(taskId, req.Budget)
+			if budget.MaxSubtasks > 0 && len(tasks) > budget.MaxSubtasks {
+				reason := fmt.Sprintf("workflow has %d steps, budget allows %d", len(tasks), budget.MaxSubtasks)
+				span.SetStatus(codes.Error, reason)
+				tx.Rollback()
+				metrics.WorkflowsSubmitted.WithLabelValues("budget_exceeded").Inc()
+				writeAPIError(w, APIError{Code: "budget_exceeded", Message: reason}, http.StatusUnprocessableEntity)
+				return
+			}
+
+			if err := storeTaskBudget(tx, budget); err != nil {
+				logger.Error("Failed to store task budget", "error", err)
+				tx.Rollback()
+				metrics.WorkflowsSubmitted.WithLabelValues("store_error").Inc()
+				writeError(w, "Failed to store task budget", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Register the webhook before anything is queued so it sees the first transition
+		hook, err := registerSubmissionWebhook(tx, principal, req.WebhookURL, &taskId, nil)
+		if err != nil {
+			logger.Error("Failed to register submission webhook", "error", err)
+			span.RecordError(err)
+			tx.Rollback()
+			metrics.WorkflowsSubmitted.WithLabelValues("store_error").Inc()
+			writeError(w, "Failed to register webhook", http.StatusInternalServerError)
+			return
+		}
+
+		if req.Plan {
+			err = holdPlan(tx, &models.Plan{TaskId: taskId, ClientId: principal.ClientId, Tenant: principal.Tenant, Description: req.Name}, tasks)
+		} else if err = workers.RecordInitialPlan(tx, taskId, req.Summary(), tasks); err == nil {
+			err = storeSubtasks(tx, tasks)
+		}
+		if err != nil {
+			logger.Error("Failed to queue workflow steps", "error", err)
+			span.RecordError(err)
+			tx.Rollback()
+			metrics.WorkflowsSubmitted.WithLabelValues("store_error").Inc()
+			writeError(w, "Failed to queue workflow steps", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			logger.Error("Failed to commit workflow steps", "error", err)
+			span.RecordError(err)
+			metrics.WorkflowsSubmitted.WithLabelValues("store_error").Inc()
+			writeError(w, "Failed to queue workflow steps", http.StatusInternalServerError)
+			return
+		}
+		if err := activateTaskBudget(s, budget, 0, 0); err != nil {
+			logger.Error("Failed to activate task budget", "error", err)
+			span.RecordError(err)
+			metrics.WorkflowsSubmitted.WithLabelValues("store_error").Inc()
+			writeError(w, "Failed to store task budget", http.StatusInternalServerError)
+			return
+		}
+		if !req.Plan {
+			if err := publishSubtasks(s, tasks); err != nil {
+				logger.Error("Failed to publish workflow steps", "error", err)
+				span.RecordError(err)
+				metrics.WorkflowsSubmitted.WithLabelValues("store_error").Inc()
+				writeError(w, "Failed to publish workflow steps to queue", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		metrics.WorkflowsSubmitted.WithLabelValues("success").Inc()
+		span.SetAttributes(attribute.Int("task.subtasks", len(tasks)), attribute.Bool("task.plan", req.Plan))
+		for _, task := range tasks {
+			metrics.SubtasksCreated.WithLabelValues(task.Type).Inc()
+		}
+
+		message := "Workflow queued successfully"
+		if req.Plan {
+			message = "Workflow plan awaiting approval"
+			logger.Info("Workflow plan awaiting approval", "workflow", req.Name, "steps", len(tasks))
+			workers.PublishTaskEvent(s, models.TaskEvent{TaskId: taskId, Event: "plan_awaiting_approval"})
+		} else {
+			logger.Info("Workflow queued", "workflow", req.Name, "steps", len(tasks))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(workflowResponse{
+			Message: message,
+			TaskId:  taskId,
+			Name:    req.Name,
+			Budget:  req.Budget,
+			Tasks:   taskResponses(tasks),
+			Webhook: hook,
+		})
+	}
+}
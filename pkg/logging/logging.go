@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// sensitiveKeys are attributes that may carry prompts, commands or LLM output and are redacted unless LOG_REDACT=false
+var sensitiveKeys = map[string]bool{
+	"prompt":      true,
+	"command":     true,
+	"args":        true,
+	"context":     true,
+	"output":      true,
+	"description": true,
+	"code":        true,
+}
+
+// redacting is whether Redact hides sensitive values, as set by the last logger built with New
+var redacting atomic.Bool
+
+func init() {
+	redacting.Store(true)
+}
+
+// Config selects the log level, output format and whether sensitive content is redacted
+type Config struct {
+	Level  slog.Level
+	Format string // "text" or "json"
+	Redact bool
+}
+
+// ConfigFromEnv reads LOG_LEVEL (debug, info, warn, error), LOG_FORMAT (text, json) and LOG_REDACT (default true)
+func ConfigFromEnv() Config {
+	cfg := Config{Level: slog.LevelInfo, Format: "text", Redact: true}
+
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		if err := cfg.Level.UnmarshalText([]byte(level)); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid LOG_LEVEL %q, using info\n", level)
+		}
+	}
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "json") {
+		cfg.Format = "json"
+	}
+	if strings.EqualFold(os.Getenv("LOG_REDACT"), "false") {
+		cfg.Redact = false
+	}
+	return cfg
+}
+
+// New builds a logger writing to w according to cfg. cfg.Redact also decides whether Redact hides values recorded
+// outside the logger, such as span attributes.
+func New(w io.Writer, cfg Config) *slog.Logger {
+	redacting.Store(cfg.Redact)
+	opts := &slog.HandlerOptions{Level: cfg.Level}
+	if cfg.Redact {
+		opts.ReplaceAttr = redact
+	}
+
+	if cfg.Format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// redact replaces sensitive attribute values with their length so logs stay useful without leaking content
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if !sensitiveKeys[a.Key] {
+		return a
+	}
+	return slog.String(a.Key, redacted(a.Value.String()))
+}
+
+func redacted(value string) string {
+	return fmt.Sprintf("[redacted %d bytes]", len(value))
+}
+
+// Redact applies the log redaction policy to a value recorded elsewhere, e.g. the span attribute "exec.command":
+// the value is replaced with its length if the last dot-separated part of key is sensitive and redaction is on
+func Redact(key string, value string) string {
+	if !redacting.Load() {
+		return value
+	}
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		key = key[i+1:]
+	}
+	if !sensitiveKeys[key] {
+		return value
+	}
+	return redacted(value)
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context carrying logger, for code paths that do not have access to the Store
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger attached to ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
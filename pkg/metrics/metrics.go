@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "promise"
+
+var (
+	// Jobs and subtasks
+
+	JobsSubmitted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_submitted_total",
+		Help:      "Shell jobs submitted through /job.",
+	})
+
+	TasksDecomposed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_decomposed_total",
+		Help:      "Decomposition requests by outcome (success, llm_error, budget_exceeded, store_error).",
+	}, []string{"outcome"})
+
+	WorkflowsSubmitted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workflows_submitted_total",
+		Help:      "Declarative workflow submissions by outcome (success, budget_exceeded, store_error).",
+	}, []string{"outcome"})
+
+	SubtasksCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "subtasks_created_total",
+		Help:      "Subtasks created by decomposition or workflows, by type.",
+	}, []string{"type"})
+
+	SubtasksProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "subtasks_processed_total",
+		Help:      "Subtasks run by workers, by type and outcome (completed, failed, skipped, awaiting_approval, interrupted).",
+	}, []string{"type", "outcome"})
+
+	SubtaskDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "subtask_duration_seconds",
+		Help:      "Time spent executing a subtask, by type and outcome.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"type", "outcome"})
+
+	SubtaskRequeues = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "subtask_requeues_total",
+		Help:      "Subtasks put back on their queue, by reason (dependencies, concurrency_limit, recovered, shutdown).",
+	}, []string{"reason"})
+
+	SubtaskRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "subtask_retries_total",
+		Help:      "Subtasks retried after their worker died.",
+	})
+
+	SubtasksDeadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "subtasks_dead_lettered_total",
+		Help:      "Subtasks moved to the dead-letter queue after exhausting their attempts.",
+	})
+
+	// Workers
+
+	ScalingEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "autoscale_events_total",
+		Help:      "Scaling decisions taken by the worker manager, by action.",
+	}, []string{"action"})
+
+	// LLM calls
+
+	LLMRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_requests_total",
+		Help:      "LLM requests by model and outcome (success, rate_limited, server_error, client_error, transport_error).",
+	}, []string{"model", "outcome"})
+
+	LLMLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "llm_request_duration_seconds",
+		Help:      "Latency of successful LLM requests.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32, 60},
+	}, []string{"model"})
+
+	LLMTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_tokens_total",
+		Help:      "Tokens consumed by LLM requests, by model and kind (prompt, completion).",
+	}, []string{"model", "kind"})
+
+	LLMCost = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_cost_dollars_total",
+		Help:      "Estimated LLM spend in USD, by model.",
+	}, []string{"model"})
+
+	// HTTP API
+
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+)
+
+// queueCollector reports Redis queue sizes at scrape time so every node exports the same cluster-wide view
+type queueCollector struct {
+	store      *db.Store
+	depth      *prometheus.Desc
+	paused     *prometheus.Desc
+	deadLetter *prometheus.Desc
+}
+
+// RegisterQueueCollector exports queue depth, paused and dead-letter sizes read from Redis on each scrape
+func RegisterQueueCollector(s *db.Store) {
+	prometheus.MustRegister(&queueCollector{
+		store:      s,
+		depth:      prometheus.NewDesc(namespace+"_queue_depth", "Items waiting, by queue (task_queue, job_queue) and tenant.", []string{"queue", "tenant"}, nil),
+		paused:     prometheus.NewDesc(namespace+"_paused_subtasks", "Subtasks paused because their task exceeded its budget.", nil, nil),
+		deadLetter: prometheus.NewDesc(namespace+"_dead_letter_size", "Subtasks in the dead-letter queue.", nil, nil),
+	})
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.depth
+	ch <- c.paused
+	ch <- c.deadLetter
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	// Task queues are per task, so they are summed by tenant to keep the label set bounded
+	queues, _ := c.store.Rdb.Keys(ctx, "task_queue:*").Result()
+	depths := map[string]int64{}
+	for _, queue := range queues {
+		length, err := c.store.Rdb.LLen(ctx, queue).Result()
+		if err != nil {
+			continue
+		}
+		tenant, _, _ := strings.Cut(strings.TrimPrefix(queue, "task_queue:"), ":")
+		depths[tenant] += length
+	}
+	for tenant, depth := range depths {
+		ch <- prometheus.MustNewConstMetric(c.depth, prometheus.GaugeValue, float64(depth), "task_queue", tenant)
+	}
+	if length, err := c.store.Rdb.LLen(ctx, "job_queue").Result(); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.depth, prometheus.GaugeValue, float64(length), "job_queue", "")
+	}
+
+	pausedQueues, _ := c.store.Rdb.Keys(ctx, "task_paused:*").Result()
+	paused := int64(0)
+	for _, queue := range pausedQueues {
+		length, _ := c.store.Rdb.LLen(ctx, queue).Result()
+		paused += length
+	}
+	ch <- prometheus.MustNewConstMetric(c.paused, prometheus.GaugeValue, float64(paused))
+
+	deadLetter, _ := c.store.Rdb.LLen(ctx, "task_dead_letter").Result()
+	ch <- prometheus.MustNewConstMetric(c.deadLetter, prometheus.GaugeValue, float64(deadLetter))
+}
+
+// RegisterActiveWorkers exports the node's worker count, read from activeWorkers on each scrape
+func RegisterActiveWorkers(activeWorkers func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_workers",
+		Help:      "Workers running on this node.",
+	}, activeWorkers)
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// InstrumentHandler counts every request served by next, labelled by the matched route pattern
+func InstrumentHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// ServeMux fills in the pattern it matched; unmatched paths share one label to bound cardinality
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+	})
+}
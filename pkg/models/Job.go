@@ -12,4 +12,7 @@ type Job struct {
 	Status        string    `json:"status"`
 	ExecutionTime time.Time `json:"execution_time"`
 	RetryCount    int       `json:"retry_count"`
+	Tags          []string  `gorm:"type:jsonb;serializer:json" json:"tags,omitempty"` // Free-form labels used to filter listings
+	ClientId      string    `gorm:"index" json:"client_id"`                           // API client that submitted the job
+	Tenant        string    `gorm:"index;default:default" json:"tenant"`              // Namespace of the submitting client
 }
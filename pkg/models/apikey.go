@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey authenticates a client. Only a SHA-256 hash of the secret is stored; the full key is shown once at creation.
+type APIKey struct {
+	ClientId   string     `gorm:"index;not null" json:"client_id"`     // Owner of every job and task submitted with this key
+	Tenant     string     `gorm:"index;default:default" json:"tenant"` // Namespace the client's work is queued and billed under
+	Name       string     `json:"name"`                                // Human-readable label, e.g. "ci-runner"
+	Prefix     string     `gorm:"uniqueIndex;not null" json:"prefix"`  // Public part of the key, used to look it up
+	Hash       string     `gorm:"not null" json:"-"`                   // Hex SHA-256 of the full key
+	Scopes     []string   `gorm:"serializer:json" json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	gorm.Model
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GeneratedCommand is a shell command produced by the LLM for a command_execution subtask
+type GeneratedCommand struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Context string   `json:"context"` // LLM's handoff notes for dependent subtasks
+}
+
+// CommandApproval holds a generated command that matched a risk rule until a reviewer approves or rejects it.
+// Status takes the plan statuses: awaiting_approval, approved or rejected.
+type CommandApproval struct {
+	TaskId     uuid.UUID        `gorm:"type:uuid;index;not null" json:"task_id"`
+	SubtaskId  int              `gorm:"not null" json:"subtask_id"`
+	ClientId   string           `gorm:"index" json:"client_id"`
+	Tenant     string           `gorm:"index;default:default" json:"tenant"`
+	Command    GeneratedCommand `gorm:"serializer:json" json:"command"`
+	Rules      []string         `gorm:"serializer:json" json:"rules"` // Names of the risk rules the command matched
+	Status     string           `gorm:"index" json:"status"`
+	ReviewedBy string           `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time       `json:"reviewed_at,omitempty"`
+	Comment    string           `json:"comment,omitempty"`
+
+	gorm.Model
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Artifact is a file a subtask produced, kept in the artifact store so dependents on other hosts and API clients can
+// fetch it
+type Artifact struct {
+	TaskId    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_artifact" json:"task_id"`
+	SubtaskId int       `gorm:"not null;uniqueIndex:idx_artifact" json:"subtask_id"`
+	Name      string    `gorm:"not null;uniqueIndex:idx_artifact" json:"name"` // Path relative to the task's workspace
+	Tenant    string    `gorm:"index" json:"tenant"`
+	Key       string    `json:"-"`       // Location in the backend
+	Backend   string    `json:"backend"` // local or s3
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+
+	gorm.Model
+}
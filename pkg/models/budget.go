@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TaskBudget caps the LLM spend and size of a decomposed task
+type TaskBudget struct {
+	TaskId      uuid.UUID  `gorm:"type:uuid;uniqueIndex" json:"task_id"`
+	MaxTokens   int64      `json:"max_tokens"`                                        // 0 for unlimited
+	MaxCost     float64    `json:"max_cost"`                                          // USD, 0 for unlimited
+	MaxSubtasks int        `json:"max_subtasks"`                                      // 0 for unlimited
+	OnExceeded  string     `gorm:"type:varchar(10);default:pause" json:"on_exceeded"` // "pause" or "fail" remaining subtasks
+	Status      string     `gorm:"type:varchar(20)" json:"status"`                    // "within_budget" or "budget_exceeded"
+	ExceededAt  *time.Time `json:"exceeded_at,omitempty"`
+
+	gorm.Model
+}
+
+// TaskEvent is published on the task_events channel whenever a job, task or subtask changes state
+type TaskEvent struct {
+	TaskId    uuid.UUID `json:"task_id"`
+	SubtaskId int       `json:"subtask_id"`       // 0 for events about the task as a whole
+	JobId     uint      `json:"job_id,omitempty"` // Set instead of TaskId for shell jobs
+	Event     string    `json:"event"`
+	Detail    string    `json:"detail,omitempty"`
+	Time      time.Time `json:"time"`
+}
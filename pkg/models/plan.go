@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Plan statuses. Subtasks of a plan awaiting approval carry PlanAwaitingApproval as their status and are not queued.
+const (
+	PlanAwaitingApproval = "awaiting_approval"
+	PlanApproved         = "approved"
+	PlanRejected         = "rejected"
+)
+
+// Plan is the review record of a task submitted in plan mode
+type Plan struct {
+	TaskId      uuid.UUID  `gorm:"type:uuid;uniqueIndex;not null" json:"task_id"`
+	ClientId    string     `gorm:"index" json:"client_id"`
+	Tenant      string     `gorm:"index;default:default" json:"tenant"`
+	Description string     `json:"description"`           // What was submitted: the decomposed description or the workflow name
+	Status      string     `gorm:"index" json:"status"`   // awaiting_approval, approved or rejected
+	ReviewedBy  string     `json:"reviewed_by,omitempty"` // Client that approved or rejected the plan
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	Comment     string     `json:"comment,omitempty"` // Reviewer's note
+
+	gorm.Model
+}
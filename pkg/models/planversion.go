@@ -0,0 +1,30 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// What started a plan version
+const (
+	PlanVersionInitial = "initial" // The graph as first queued
+	PlanVersionManual  = "manual"  // Replan requested through the API
+	PlanVersionFailure = "failure" // Replan started automatically after a subtask failed
+)
+
+// PlanVersion is one revision of a task's subtask graph. Version 1 is the graph as first queued; each replan adds the
+// next version with the graph as it stood afterwards.
+type PlanVersion struct {
+	TaskId      uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_plan_version" json:"task_id"`
+	Version     int            `gorm:"not null;uniqueIndex:idx_plan_version" json:"version"`
+	Trigger     string         `json:"trigger"`                                   // initial, manual or failure
+	Description string         `json:"description"`                               // What the task is meant to achieve, given to the LLM when replanning
+	Reason      string         `json:"reason,omitempty"`                          // Why the replan was requested
+	Rationale   string         `json:"rationale,omitempty"`                       // The LLM's explanation of the revision
+	Replaced    []int          `gorm:"serializer:json" json:"replaced,omitempty"` // Subtasks that had not started and were superseded
+	Added       []int          `gorm:"serializer:json" json:"added,omitempty"`    // Subtasks this version created
+	Subtasks    []TaskResponse `gorm:"serializer:json" json:"subtasks"`           // The whole graph after this version
+	CreatedBy   string         `json:"created_by,omitempty"`                      // Client that requested the replan
+
+	gorm.Model
+}
@@ -8,31 +8,99 @@ import (
 type Dependency struct {
 	TaskId    uuid.UUID `json:"task_id"`
 	SubtaskId int       `json:"subtask_id"`
+	Name      string    `json:"name,omitempty"`      // Step name of the dependency, usable in when-clauses
+	Condition string    `json:"condition,omitempty"` // success (default), failure or always
+	Match     string    `json:"match,omitempty"`     // Regular expression the dependency's output must match
+}
+
+// MapSpec describes a map subtask: at run time it reads a list from one of its dependencies and spawns one child
+// subtask per item under the same TaskId
+type MapSpec struct {
+	From           int    `json:"from"`                      // Dependency whose output lists the items, as a JSON array or one item per line
+	Type           string `json:"type"`                      // Type of each child: command_execution, code_generation or composite
+	Command        string `json:"command,omitempty"`         // Child command; {{item}} is replaced by the shell-quoted item
+	Prompt         string `json:"prompt,omitempty"`          // Child instructions for the LLM; {{item}} is replaced by the item
+	MaxConcurrency int64  `json:"max_concurrency,omitempty"` // Children running at once, 0 for unlimited
+	MaxFailures    int    `json:"max_failures,omitempty"`    // Children that may fail before the map fails
+	// OutputSchema is the JSON schema every child's structured output must match
+	OutputSchema map[string]interface{} `json:"output_schema,omitempty"`
 }
 
 type Task struct {
-	TaskId       uuid.UUID    `gorm:"type:uuid;not null" json:"task_id"` // Shared by all subtasks
-	SubtaskId    int          `gorm:"not null" json:"subtask_id"`        // Unique within TaskId
-	Type         string       `gorm:"type:varchar(20);not null" json:"type"`
-	Description  string       `json:"description"`
-	Dependencies []Dependency `gorm:"serializer:json" json:"dependencies"`
-	Status       string       `json:"status"`
+	TaskId       uuid.UUID              `gorm:"type:uuid;not null" json:"task_id"` // Shared by all subtasks
+	SubtaskId    int                    `gorm:"not null" json:"subtask_id"`        // Unique within TaskId
+	Name         string                 `json:"name,omitempty"`                    // Step name, set for subtasks defined by a workflow
+	Type         string                 `gorm:"type:varchar(20);not null" json:"type"`
+	Description  string                 `json:"description"`
+	Command      string                 `json:"command,omitempty"` // Shell command run as-is instead of asking the LLM to write one
+	Dependencies []Dependency           `gorm:"serializer:json" json:"dependencies"`
+	When         string                 `json:"when,omitempty"`                                 // Clause over the dependencies' results; the subtask is skipped when false
+	OutputSchema map[string]interface{} `gorm:"serializer:json" json:"output_schema,omitempty"` // JSON schema the subtask's structured output must match
+	Map          *MapSpec               `gorm:"serializer:json" json:"map,omitempty"`           // Set for map subtasks, which fan out into children
+	Artifacts    []string               `gorm:"serializer:json" json:"artifacts,omitempty"`     // Files the subtask produces, relative to its working directory
+	ParentId     int                    `gorm:"default:0" json:"parent_id,omitempty"`           // Map or composite subtask that spawned this child
+	Depth        int                    `gorm:"default:0" json:"depth,omitempty"`               // Levels of composite decomposition above this subtask
+	MapLimit     int64                  `gorm:"default:0" json:"map_limit,omitempty"`           // Parent's max_concurrency, enforced as a permit
+	SupersededBy int                    `gorm:"default:0" json:"superseded_by,omitempty"`       // Plan version that replaced this subtask or took over from its failure
+	Status       string                 `json:"status"`
+	Resources    []string               `gorm:"serializer:json" json:"resources"`                 // Named resources (e.g. "groq-api") acquired before running
+	Attempts     int                    `gorm:"default:0" json:"attempts"`                        // Incremented each time the subtask is recovered from a dead worker
+	ClientId     string                 `gorm:"index" json:"client_id"`                           // API client that submitted the task, used for usage rollups
+	TraceContext map[string]string      `gorm:"serializer:json" json:"trace_context,omitempty"`   // W3C trace context of the span that enqueued the subtask
+	Tags         []string               `gorm:"type:jsonb;serializer:json" json:"tags,omitempty"` // Labels given when the task was submitted, shared by all subtasks
+	Tenant       string                 `gorm:"index;default:default" json:"tenant"`              // Namespace whose queues, quotas and workspace the subtask uses
+	// ApprovedCommand is set on the queued payload once a reviewer approves a risky generated command, so the worker
+	// runs exactly that command instead of asking the LLM again
+	ApprovedCommand *GeneratedCommand `gorm:"-" json:"approved_command,omitempty"`
 
 	gorm.Model
 }
 
 type TaskResponse struct {
-	TaskId       uuid.UUID    `json:"task_id"`
-	SubtaskId    int          `json:"subtask_id"`
-	Type         string       `json:"type"`
-	Description  string       `json:"description"`
-	Dependencies []Dependency `gorm:"serializer:json" json:"dependencies"`
-	Status       string       `json:"status"`
+	TaskId       uuid.UUID              `json:"task_id"`
+	SubtaskId    int                    `json:"subtask_id"`
+	Name         string                 `json:"name,omitempty"`
+	Type         string                 `json:"type"`
+	Description  string                 `json:"description"`
+	Command      string                 `json:"command,omitempty"`
+	Dependencies []Dependency           `gorm:"serializer:json" json:"dependencies"`
+	When         string                 `json:"when,omitempty"`
+	OutputSchema map[string]interface{} `json:"output_schema,omitempty"`
+	Map          *MapSpec               `json:"map,omitempty"`
+	Artifacts    []string               `json:"artifacts,omitempty"`
+	ParentId     int                    `json:"parent_id,omitempty"`
+	Depth        int                    `json:"depth,omitempty"`
+	SupersededBy int                    `json:"superseded_by,omitempty"`
+	Resources    []string               `json:"resources,omitempty"`
+	Status       string                 `json:"status"`
+}
+
+// Response converts a subtask to its API representation
+func (t Task) Response() TaskResponse {
+	return TaskResponse{
+		TaskId:       t.TaskId,
+		SubtaskId:    t.SubtaskId,
+		Name:         t.Name,
+		Type:         t.Type,
+		Description:  t.Description,
+		Command:      t.Command,
+		Dependencies: t.Dependencies,
+		When:         t.When,
+		OutputSchema: t.OutputSchema,
+		Map:          t.Map,
+		Artifacts:    t.Artifacts,
+		ParentId:     t.ParentId,
+		Depth:        t.Depth,
+		SupersededBy: t.SupersededBy,
+		Resources:    t.Resources,
+		Status:       t.Status,
+	}
 }
 
 type Subtask struct {
-	SubtaskId    int    `json:"subtask_id"`
-	Description  string `json:"description"`
-	Type         string `json:"type"`
-	Dependencies []int  `json:"dependencies"`
+	SubtaskId    int      `json:"subtask_id"`
+	Description  string   `json:"description"`
+	Type         string   `json:"type"`
+	Dependencies []int    `json:"dependencies"`
+	Resources    []string `json:"resources"`
 }
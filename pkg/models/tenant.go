@@ -0,0 +1,20 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// DefaultTenant owns keys, jobs and tasks that were created without a tenant
+const DefaultTenant = "default"
+
+// Tenant is a namespace shared by a team's clients. Zero quotas are unlimited.
+type Tenant struct {
+	Name                  string `gorm:"uniqueIndex;not null" json:"name"`
+	MaxConcurrentSubtasks int64  `json:"max_concurrent_subtasks"` // Subtasks running at once across all nodes
+	MaxQueuedSubtasks     int64  `json:"max_queued_subtasks"`     // Subtasks waiting in the tenant's queues
+	MaxTokensPerDay       int64  `json:"max_tokens_per_day"`      // LLM tokens per UTC day
+	MaxStorageBytes       int64  `json:"max_storage_bytes"`       // Size of the tenant's workspace directory
+	Weight                int64  `gorm:"default:1" json:"weight"` // Relative share of worker capacity when tenants compete
+
+	gorm.Model
+}
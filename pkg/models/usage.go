@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LLMUsage records the tokens, latency and cost of a single LLM call
+type LLMUsage struct {
+	TaskId           uuid.UUID `gorm:"type:uuid;index" json:"task_id"`
+	SubtaskId        int       `json:"subtask_id"` // 0 for the decomposition call itself
+	ClientId         string    `gorm:"index" json:"client_id"`
+	Purpose          string    `gorm:"type:varchar(32)" json:"purpose"` // decomposition, command_execution, code_generation
+	ModelName        string    `gorm:"column:model" json:"model"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	LatencyMs        int64     `json:"latency_ms"`
+	Cost             float64   `json:"cost"` // USD, computed from the price table at the time of the call
+
+	gorm.Model
+}
+
+// UsageReport is a rollup of LLM usage grouped by task or client
+type UsageReport struct {
+	Key              string  `json:"key"`
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+	Cost             float64 `json:"cost"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Webhook subscribes a URL to job and task events. A webhook scoped to a task or job only receives that one's events;
+// otherwise it receives events for the owning client's work in its tenant, or every client's if AllClients is set.
+type Webhook struct {
+	ClientId   string     `gorm:"index;not null" json:"client_id"`
+	Tenant     string     `gorm:"index;not null" json:"tenant"`
+	URL        string     `gorm:"not null" json:"url"`
+	Secret     string     `gorm:"not null" json:"-"`             // HMAC key shared with the receiver, only returned at creation
+	Events     []string   `gorm:"serializer:json" json:"events"` // Event names to deliver, empty for all
+	TaskId     *uuid.UUID `gorm:"type:uuid;index" json:"task_id,omitempty"`
+	JobId      *uint      `gorm:"index" json:"job_id,omitempty"`
+	AllClients bool       `json:"all_clients"` // Tenant-wide across clients, admins only
+
+	gorm.Model
+}
+
+// WebhookDelivery is one attempt log entry for sending an event to a webhook
+type WebhookDelivery struct {
+	WebhookId      uint       `gorm:"index;not null" json:"webhook_id"`
+	Event          string     `json:"event"`
+	Payload        string     `gorm:"type:text" json:"payload"`
+	Status         string     `gorm:"type:varchar(20);index" json:"status"` // "pending", "delivered" or "failed"
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"index" json:"next_attempt_at"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+
+	gorm.Model
+}
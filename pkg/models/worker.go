@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// WorkerInfo describes a live worker registered in Redis and the subtask it currently holds
+type WorkerInfo struct {
+	WorkerId      string    `json:"worker_id"`
+	Node          string    `json:"node"`
+	StartedAt     time.Time `json:"started_at"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	CurrentTask   string    `json:"current_task"` // 'taskid:subtaskid', empty when idle
+	Leases        int64     `json:"leases"`
+}
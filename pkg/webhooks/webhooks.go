@@ -0,0 +1,341 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workers"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxAttempts    = 8
+	initialBackoff = 10 * time.Second
+	maxBackoff     = time.Hour
+	requestTimeout = 10 * time.Second
+	pollInterval   = 2 * time.Second
+	batchSize      = 20
+)
+
+var ctx = context.Background()
+
+// Events lists the event names a webhook can subscribe to. Shell jobs only emit job_queued: the server does not run
+// them, so it has nothing to report once they leave the queue.
+var Events = []string{
+	"job_queued",
+	"subtask_running", "subtask_completed", "subtask_failed", "subtask_dead_lettered",
+	"subtask_awaiting_approval", "subtask_approved", "subtask_rejected", "subtask_skipped", "subtask_expanded",
+	"task_completed", "task_failed", "task_replanned",
+	"plan_awaiting_approval", "plan_approved", "plan_rejected",
+	"budget_exceeded", "budget_resumed",
+}
+
+var client = &http.Client{
+	Timeout: requestTimeout,
+	// Receivers must answer directly; following redirects would let a URL bounce deliveries elsewhere
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// NewSecret returns a random signing secret for a webhook
+func NewSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// ValidateURL checks a receiver URL is an absolute http(s) URL
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// Sign returns the X-Promise-Signature value for a payload: "sha256=" + hex HMAC-SHA256 of "<timestamp>.<body>".
+// Receivers should recompute it and reject stale timestamps to prevent replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the X-Promise-Signature of a payload sent at timestamp, for receivers written in Go
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// backoff is the delay before retrying after the given number of failed attempts
+func backoff(attempts int) time.Duration {
+	delay := initialBackoff << (attempts - 1)
+	if delay <= 0 || delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+// Run turns task events into deliveries and sends due deliveries. Every node runs it: events are taken off a
+// shared Redis list and deliveries are claimed with SKIP LOCKED, so each is handled by one node.
+func Run(s *db.Store) {
+	s.Log.Info("Starting webhook dispatcher")
+	go dispatch(s)
+	for {
+		if n := deliverDue(s); n == 0 {
+			time.Sleep(pollInterval)
+		}
+	}
+}
+
+// dispatch records a pending delivery for every webhook subscribed to each event. An event is moved into this node's
+// processing list while it is handled and only dropped once its deliveries are committed, so a failure puts it back on
+// the queue and a crash leaves it for the reaper to return.
+func dispatch(s *db.Store) {
+	processing := workers.TaskEventProcessingKey(workers.NodeId())
+	for {
+		payload, err := s.Rdb.BLMove(ctx, workers.TaskEventQueue, processing, "LEFT", "RIGHT", 0).Result()
+		if err != nil {
+			s.Log.Error("Failed to read task events", "error", err)
+			time.Sleep(pollInterval)
+			continue
+		}
+
+		var event models.TaskEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			s.Log.Error("Dropped unparseable task event", "error", err)
+			s.Rdb.LRem(ctx, processing, 1, payload)
+			continue
+		}
+
+		if err := recordDeliveries(s, event, payload); err != nil {
+			s.Log.Error("Failed to record webhook deliveries, returning event to the queue", "event", event.Event, "error", err)
+			if err := s.Rdb.LMove(ctx, processing, workers.TaskEventQueue, "RIGHT", "LEFT").Err(); err != nil {
+				s.Log.Error("Failed to return task event to the queue", "event", event.Event, "error", err)
+			}
+			time.Sleep(pollInterval)
+			continue
+		}
+		s.Rdb.LRem(ctx, processing, 1, payload)
+	}
+}
+
+// recordDeliveries inserts a pending delivery of payload for every webhook subscribed to event, all or nothing
+func recordDeliveries(s *db.Store, event models.TaskEvent, payload string) error {
+	hooks, err := subscribers(s, event)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	deliveries := make([]models.WebhookDelivery, 0, len(hooks))
+	for _, hook := range hooks {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			WebhookId:     hook.ID,
+			Event:         event.Event,
+			Payload:       payload,
+			Status:        "pending",
+			NextAttemptAt: now,
+		})
+	}
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(&deliveries).Error
+	})
+}
+
+// owner resolves the tenant and client an event belongs to
+func owner(s *db.Store, event models.TaskEvent) (tenant string, clientId string, err error) {
+	if event.JobId != 0 {
+		var job models.Job
+		err = s.DB.Select("tenant", "client_id").Where("id = ?", event.JobId).First(&job).Error
+		return job.Tenant, job.ClientId, err
+	}
+	var task models.Task
+	err = s.DB.Select("tenant", "client_id").Where("task_id = ?", event.TaskId).First(&task).Error
+	return task.Tenant, task.ClientId, err
+}
+
+// subscribers returns the active webhooks that should receive event
+func subscribers(s *db.Store, event models.TaskEvent) ([]models.Webhook, error) {
+	tenant, clientId, err := owner(s, event)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	query := s.DB.Where("tenant = ?", tenant).
+		Where("(client_id = ? OR all_clients)", clientId)
+	if event.JobId != 0 {
+		query = query.Where("task_id IS NULL AND (job_id IS NULL OR job_id = ?)", event.JobId)
+	} else {
+		query = query.Where("job_id IS NULL AND (task_id IS NULL OR task_id = ?)", event.TaskId)
+	}
+
+	var candidates []models.Webhook
+	if err := query.Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	var hooks []models.Webhook
+	for _, hook := range candidates {
+		if len(hook.Events) == 0 || slices.Contains(hook.Events, event.Event) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+// deliverDue claims and sends a batch of deliveries whose next attempt is due, returning how many it sent
+func deliverDue(s *db.Store) int {
+	var deliveries []models.WebhookDelivery
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", "pending", time.Now().UTC()).
+			Order("next_attempt_at").Limit(batchSize).Find(&deliveries).Error; err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		// Push the claimed rows into the future so other nodes skip them while this one sends
+		ids := make([]uint, len(deliveries))
+		for i, d := range deliveries {
+			ids[i] = d.ID
+		}
+		return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).
+			Update("next_attempt_at", time.Now().UTC().Add(2*requestTimeout)).Error
+	})
+	if err != nil {
+		s.Log.Error("Failed to claim webhook deliveries", "error", err)
+		return 0
+	}
+
+	for _, delivery := range deliveries {
+		deliver(s, delivery)
+	}
+	return len(deliveries)
+}
+
+// deliver makes one attempt at sending a delivery and records the outcome
+func deliver(s *db.Store, delivery models.WebhookDelivery) {
+	var hook models.Webhook
+	if err := s.DB.Where("id = ?", delivery.WebhookId).First(&hook).Error; err != nil {
+		s.DB.Model(&delivery).Updates(map[string]interface{}{"status": "failed", "last_error": "webhook removed"})
+		return
+	}
+
+	delivery.Attempts++
+	statusCode, err := send(hook, delivery)
+
+	updates := outcome(delivery.Attempts, statusCode, err, time.Now().UTC())
+	logger := s.Log.With("webhook_id", hook.ID, "delivery_id", delivery.ID, "event", delivery.Event, "attempt", delivery.Attempts)
+	switch updates["status"] {
+	case "delivered":
+		logger.Debug("Webhook delivered", "status_code", statusCode)
+	case "failed":
+		logger.Warn("Webhook delivery failed permanently", "error", err)
+	default:
+		logger.Info("Webhook delivery failed, will retry", "error", err, "retry_in", backoff(delivery.Attempts))
+	}
+	if err := s.DB.Model(&delivery).Updates(updates).Error; err != nil {
+		logger.Error("Failed to update webhook delivery", "error", err)
+	}
+}
+
+// outcome is the update recorded for a delivery after its attempts-th attempt, which ended with statusCode and err
+func outcome(attempts int, statusCode int, err error, now time.Time) map[string]interface{} {
+	updates := map[string]interface{}{"attempts": attempts, "last_status_code": statusCode, "last_error": ""}
+	switch {
+	case err == nil:
+		updates["status"] = "delivered"
+		updates["delivered_at"] = now
+	case attempts >= maxAttempts:
+		updates["status"] = "failed"
+		updates["last_error"] = err.Error()
+	default:
+		updates["status"] = "pending"
+		updates["next_attempt_at"] = now.Add(backoff(attempts))
+		updates["last_error"] = err.Error()
+	}
+	return updates
+}
+
+// send POSTs a delivery's payload, signed with the webhook's secret. Any 2xx response counts as delivered.
+func send(hook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "promise-webhooks/1")
+	req.Header.Set("X-Promise-Event", delivery.Event)
+	req.Header.Set("X-Promise-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Promise-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Promise-Signature", Sign(hook.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Redeliver queues a fresh attempt of a past delivery, e.g. after the receiver was fixed
+func Redeliver(s *db.Store, delivery models.WebhookDelivery) error {
+	return s.DB.Model(&delivery).Updates(redelivery(time.Now().UTC())).Error
+}
+
+// redelivery is the update that makes a delivery pending again with a fresh set of attempts
+func redelivery(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"status":          "pending",
+		"attempts":        0,
+		"next_attempt_at": now,
+		"last_error":      "",
+	}
+}
+
+// CreateForSubmission registers a webhook scoped to a single task or job, as requested in a submission body, within the
+// submission's transaction
+func CreateForSubmission(tx *gorm.DB, clientId string, tenant string, receiver string, taskId *uuid.UUID, jobId *uint) (*models.Webhook, string, error) {
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	hook := &models.Webhook{ClientId: clientId, Tenant: tenant, URL: receiver, Secret: secret, TaskId: taskId, JobId: jobId}
+	if err := tx.Create(hook).Error; err != nil {
+		return nil, "", err
+	}
+	return hook, secret, nil
+}
@@ -0,0 +1,170 @@
+package webhooks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/models"
+)
+
+// receiver is a local webhook endpoint that answers with the given status codes in turn, then 200
+func receiver(t *testing.T, statuses ...int) (*httptest.Server, *[]*http.Request, *[][]byte) {
+	t.Helper()
+	var requests []*http.Request
+	var bodies [][]byte
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		n := int(calls.Add(1)) - 1
+		if n < len(statuses) {
+			w.WriteHeader(statuses[n])
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests, &bodies
+}
+
+func TestSendSignsPayload(t *testing.T) {
+	server, requests, bodies := receiver(t)
+	hook := models.Webhook{URL: server.URL, Secret: "whsec_test"}
+	delivery := models.WebhookDelivery{Event: "task_completed", Payload: `{"event":"task_completed"}`}
+	delivery.ID = 7
+
+	status, err := send(hook, delivery)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("send() = %d, %v, want 200, nil", status, err)
+	}
+
+	req, body := (*requests)[0], (*bodies)[0]
+	if got := req.Header.Get("X-Promise-Event"); got != "task_completed" {
+		t.Errorf("X-Promise-Event = %q", got)
+	}
+	if got := req.Header.Get("X-Promise-Delivery"); got != "7" {
+		t.Errorf("X-Promise-Delivery = %q", got)
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get("X-Promise-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatalf("X-Promise-Timestamp: %v", err)
+	}
+	signature := req.Header.Get("X-Promise-Signature")
+	if signature != Sign(hook.Secret, timestamp, body) {
+		t.Errorf("X-Promise-Signature = %q, want %q", signature, Sign(hook.Secret, timestamp, body))
+	}
+	if !Verify(hook.Secret, timestamp, body, signature) {
+		t.Error("Verify rejected a valid signature")
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      string
+	}{
+		{"wrong secret", "whsec_other", timestamp, string(body)},
+		{"replayed timestamp", hook.Secret, timestamp - 1, string(body)},
+		{"tampered body", hook.Secret, timestamp, `{"event":"task_failed"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if Verify(tt.secret, tt.timestamp, []byte(tt.body), signature) {
+				t.Error("Verify accepted a signature it should reject")
+			}
+		})
+	}
+}
+
+func TestRetryWithBackoffOn5xx(t *testing.T) {
+	server, requests, _ := receiver(t, http.StatusServiceUnavailable, http.StatusInternalServerError)
+	hook := models.Webhook{URL: server.URL, Secret: "whsec_test"}
+	delivery := models.WebhookDelivery{Event: "task_failed", Payload: `{}`}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	wantDelays := []time.Duration{initialBackoff, 2 * initialBackoff}
+	for attempt := 1; attempt <= 3; attempt++ {
+		status, err := send(hook, delivery)
+		updates := outcome(attempt, status, err, now)
+		if attempt <= len(wantDelays) {
+			if updates["status"] != "pending" || updates["last_status_code"].(int) < 500 {
+				t.Fatalf("attempt %d: got %v, want a pending retry after a 5xx", attempt, updates)
+			}
+			if got := updates["next_attempt_at"].(time.Time).Sub(now); got != wantDelays[attempt-1] {
+				t.Errorf("attempt %d: retry in %s, want %s", attempt, got, wantDelays[attempt-1])
+			}
+			continue
+		}
+		if updates["status"] != "delivered" || updates["attempts"] != 3 {
+			t.Fatalf("attempt %d: got %v, want delivered on the third attempt", attempt, updates)
+		}
+	}
+	if len(*requests) != 3 {
+		t.Errorf("receiver got %d requests, want 3", len(*requests))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, initialBackoff},
+		{2, 2 * initialBackoff},
+		{4, 8 * initialBackoff},
+		{9, 256 * initialBackoff},
+		{10, maxBackoff},
+		{64, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestFailsAfterMaxAttempts(t *testing.T) {
+	server, _, _ := receiver(t, http.StatusBadGateway)
+	hook := models.Webhook{URL: server.URL, Secret: "whsec_test"}
+	status, err := send(hook, models.WebhookDelivery{Payload: `{}`})
+	if err == nil || status != http.StatusBadGateway {
+		t.Fatalf("send() = %d, %v, want 502 and an error", status, err)
+	}
+
+	if got := outcome(maxAttempts-1, status, err, time.Now())["status"]; got != "pending" {
+		t.Errorf("status after %d attempts = %v, want pending", maxAttempts-1, got)
+	}
+	updates := outcome(maxAttempts, status, err, time.Now())
+	if updates["status"] != "failed" {
+		t.Errorf("status after %d attempts = %v, want failed", maxAttempts, updates["status"])
+	}
+	if _, retried := updates["next_attempt_at"]; retried {
+		t.Error("a failed delivery was scheduled for another attempt")
+	}
+	if updates["last_error"] == "" {
+		t.Error("last_error is empty for a failed delivery")
+	}
+}
+
+func TestRedeliverResetsAttempts(t *testing.T) {
+	now := time.Now().UTC()
+	updates := redelivery(now)
+	if updates["status"] != "pending" || updates["attempts"] != 0 || updates["last_error"] != "" {
+		t.Fatalf("redelivery() = %v, want pending with no attempts and no error", updates)
+	}
+	if updates["next_attempt_at"] != now {
+		t.Errorf("next_attempt_at = %v, want now", updates["next_attempt_at"])
+	}
+
+	// A redelivered delivery that fails again gets the full set of retries back
+	attempts := updates["attempts"].(int) + 1
+	if got := outcome(attempts, http.StatusInternalServerError, io.ErrUnexpectedEOF, now)["status"]; got != "pending" {
+		t.Errorf("status after the first attempt of a redelivery = %v, want pending", got)
+	}
+}
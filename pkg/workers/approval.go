@@ -0,0 +1,129 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/ai"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrApprovalClosed is returned when reviewing a command that has already been approved or rejected
+var ErrApprovalClosed = errors.New("command is no longer awaiting approval")
+
+// holdForApproval parks a subtask whose generated command matched a risk rule. It stays out of every queue until
+// ApproveCommand requeues it.
+func holdForApproval(s *db.Store, task models.Task, held *ai.ApprovalRequiredError) error {
+	approval := models.CommandApproval{
+		TaskId:    task.TaskId,
+		SubtaskId: task.SubtaskId,
+		ClientId:  task.ClientId,
+		Tenant:    task.Tenant,
+		Command:   held.Command,
+		Rules:     held.Rules,
+		Status:    models.PlanAwaitingApproval,
+	}
+	if err := s.DB.Create(&approval).Error; err != nil {
+		return err
+	}
+	line := strings.TrimSpace(held.Command.Command + " " + strings.Join(held.Command.Args, " "))
+	setSubtaskStatus(s, task, models.PlanAwaitingApproval, line)
+	return nil
+}
+
+// reviewCommand locks an approval awaiting review, lets decide record the outcome, and returns the subtask it holds
+func reviewCommand(s *db.Store, approvalId uint, decide func(tx *gorm.DB, approval *models.CommandApproval) error) (*models.CommandApproval, *models.Task, error) {
+	var approval models.CommandApproval
+	var task models.Task
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&approval, approvalId).Error; err != nil {
+			return err
+		}
+		if approval.Status != models.PlanAwaitingApproval {
+			return ErrApprovalClosed
+		}
+		if err := tx.Where("task_id = ? AND subtask_id = ?", approval.TaskId, approval.SubtaskId).First(&task).Error; err != nil {
+			return err
+		}
+		return decide(tx, &approval)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &approval, &task, nil
+}
+
+// ApproveCommand requeues a held subtask carrying the approved command, which the worker then runs as-is
+func ApproveCommand(s *db.Store, approvalId uint, reviewer string, comment string) (*models.CommandApproval, error) {
+	approval, task, err := reviewCommand(s, approvalId, func(tx *gorm.DB, approval *models.CommandApproval) error {
+		now := time.Now().UTC()
+		approval.Status = models.PlanApproved
+		approval.ReviewedBy = reviewer
+		approval.ReviewedAt = &now
+		approval.Comment = comment
+		if err := tx.Save(approval).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Task{}).
+			Where("task_id = ? AND subtask_id = ?", approval.TaskId, approval.SubtaskId).
+			Update("status", "pending").Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	task.Status = "pending"
+	task.ApprovedCommand = &approval.Command
+	payload, err := json.Marshal(task)
+	if err == nil {
+		err = s.Rdb.RPush(ctx, TaskQueueKey(task.Tenant, task.TaskId), payload).Err()
+	}
+	if err != nil {
+		// Hold the command again so the review can be retried; failing that, fail the subtask rather than strand it
+		if reopenErr := reopenApproval(s, approval); reopenErr != nil {
+			setSubtaskStatus(s, *task, "failed", "approved command could not be queued")
+			checkTaskDone(s, task.TaskId)
+		}
+		return nil, err
+	}
+	PublishTaskEvent(s, models.TaskEvent{TaskId: task.TaskId, SubtaskId: task.SubtaskId, Event: "subtask_approved", Detail: comment})
+	return approval, nil
+}
+
+// reopenApproval undoes an approval whose subtask could not be queued, putting both back to awaiting review
+func reopenApproval(s *db.Store, approval *models.CommandApproval) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(approval).Updates(map[string]interface{}{
+			"status": models.PlanAwaitingApproval, "reviewed_by": "", "reviewed_at": nil, "comment": "",
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Task{}).
+			Where("task_id = ? AND subtask_id = ?", approval.TaskId, approval.SubtaskId).
+			Update("status", models.PlanAwaitingApproval).Error
+	})
+}
+
+// RejectCommand marks a held subtask rejected for good; its command never runs
+func RejectCommand(s *db.Store, approvalId uint, reviewer string, comment string) (*models.CommandApproval, error) {
+	approval, task, err := reviewCommand(s, approvalId, func(tx *gorm.DB, approval *models.CommandApproval) error {
+		now := time.Now().UTC()
+		approval.Status = models.PlanRejected
+		approval.ReviewedBy = reviewer
+		approval.ReviewedAt = &now
+		approval.Comment = comment
+		return tx.Save(approval).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	setSubtaskStatus(s, *task, "rejected", comment)
+	checkTaskDone(s, task.TaskId)
+	return approval, nil
+}
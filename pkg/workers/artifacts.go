@@ -0,0 +1,192 @@
+package workers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/arnavsurve/promise/pkg/ai"
+	"github.com/arnavsurve/promise/pkg/artifacts"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workspace"
+	"gorm.io/gorm/clause"
+)
+
+// registerArtifacts uploads the files a subtask produced to the artifact store and records them. Paths must lie in the
+// subtask's workspace, outside the inputs it was given; an artifact's name is its path relative to the workspace.
+func registerArtifacts(ctx context.Context, s *db.Store, task models.Task, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	dir, err := workspace.SubtaskDir(task.Tenant, task.TaskId, task.SubtaskId)
+	if err != nil {
+		return err
+	}
+
+	registered := map[string]bool{}
+	for _, path := range paths {
+		name, err := filepath.Rel(dir, path)
+		if err != nil || !filepath.IsLocal(name) {
+			return fmt.Errorf("artifact %s is outside the subtask's workspace", path)
+		}
+		name = filepath.ToSlash(name)
+		if strings.HasPrefix(name, workspace.InputsDir+"/") {
+			return fmt.Errorf("artifact %s is one of the subtask's inputs", name)
+		}
+		if registered[name] {
+			continue
+		}
+		registered[name] = true
+
+		artifact, err := uploadArtifact(ctx, s, task, name, path)
+		if err != nil {
+			return fmt.Errorf("storing artifact %s: %v", name, err)
+		}
+		// Retried subtasks overwrite the artifacts of their earlier attempt
+		if err := s.DB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "task_id"}, {Name: "subtask_id"}, {Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"key", "backend", "size", "sha256", "updated_at"}),
+		}).Create(artifact).Error; err != nil {
+			return fmt.Errorf("recording artifact %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// uploadArtifact hashes a file and writes it to the artifact store
+func uploadArtifact(ctx context.Context, s *db.Store, task models.Task, name string, path string) (*models.Artifact, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	key := artifacts.Key(task.Tenant, task.TaskId, task.SubtaskId, name)
+	if err := s.Artifacts.Put(ctx, key, f, size); err != nil {
+		return nil, err
+	}
+	return &models.Artifact{
+		TaskId:    task.TaskId,
+		SubtaskId: task.SubtaskId,
+		Name:      name,
+		Tenant:    task.Tenant,
+		Key:       key,
+		Backend:   s.Artifacts.Name(),
+		Size:      size,
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// prepareWorkspace gives a subtask a fresh working directory and stages the artifacts of its completed dependencies
+// into it, read-only, so runs are reproducible, can happen on a different host from the subtasks that produced the
+// artifacts, and cannot trample each other. Each artifact is staged at inputs/<producing subtask>/<name> and listed on
+// the input it belongs to; the artifacts of a map or composite dependency are those of its children.
+func prepareWorkspace(ctx context.Context, s *db.Store, task models.Task, inputs []ai.Input) error {
+	dir, err := workspace.SubtaskDir(task.Tenant, task.TaskId, task.SubtaskId)
+	if err != nil {
+		return err
+	}
+	if err := workspace.Reset(dir); err != nil {
+		return fmt.Errorf("resetting workspace: %v", err)
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	// Map each dependency and its descendants back to the input they belong to
+	owner := map[int]int{}
+	frontier := make([]int, 0, len(inputs))
+	for i, input := range inputs {
+		owner[input.SubtaskId] = i
+		frontier = append(frontier, input.SubtaskId)
+	}
+	for len(frontier) > 0 {
+		var children []models.Task
+		if err := s.DB.Select("subtask_id", "parent_id").Where("task_id = ? AND parent_id IN ?", task.TaskId, frontier).
+			Find(&children).Error; err != nil {
+			return err
+		}
+		frontier = frontier[:0]
+		for _, child := range children {
+			owner[child.SubtaskId] = owner[child.ParentId]
+			frontier = append(frontier, child.SubtaskId)
+		}
+	}
+
+	ids := make([]int, 0, len(owner))
+	for id := range owner {
+		ids = append(ids, id)
+	}
+	var found []models.Artifact
+	if err := s.DB.Where("task_id = ? AND subtask_id IN ?", task.TaskId, ids).Order("subtask_id, name").Find(&found).Error; err != nil {
+		return err
+	}
+	inputsDir := filepath.Join(dir, workspace.InputsDir)
+	for _, artifact := range found {
+		name := filepath.ToSlash(filepath.Join(workspace.InputsDir, strconv.Itoa(artifact.SubtaskId), artifact.Name))
+		if err := stageArtifact(ctx, s, artifact, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return fmt.Errorf("staging artifact %s of subtask %d: %v", artifact.Name, artifact.SubtaskId, err)
+		}
+		input := &inputs[owner[artifact.SubtaskId]]
+		input.Artifacts = append(input.Artifacts, name)
+	}
+
+	// Staged directories are read-only too, so a subtask cannot replace its inputs
+	return filepath.WalkDir(inputsDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return os.Chmod(path, 0555)
+		}
+		return nil
+	})
+}
+
+// stageArtifact downloads an artifact to path as a read-only file, checking its content against the recorded hash
+func stageArtifact(ctx context.Context, s *db.Store, artifact models.Artifact, path string) error {
+	body, err := s.Artifacts.Get(ctx, artifact.Key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0555) // Generated scripts stay executable
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != artifact.SHA256 {
+		return fmt.Errorf("content does not match the recorded sha256")
+	}
+	return nil
+}
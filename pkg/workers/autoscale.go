@@ -0,0 +1,300 @@
+package workers
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/env"
+	"github.com/arnavsurve/promise/pkg/metrics"
+)
+
+const autoscaleEventsKey = "autoscale_events" // Capped Redis list of recent scaling decisions
+const maxAutoscaleEvents = 100
+
+// ScalingSnapshot is the view of the system a policy uses to decide how many workers should be running
+type ScalingSnapshot struct {
+	QueuedTasks      int32         `json:"queued_tasks"`
+	ActiveWorkers    int32         `json:"active_workers"`
+	AvgTaskLatency   time.Duration `json:"avg_task_latency"`    // Zero until at least one subtask has completed
+	AvgTokensPerTask int64         `json:"avg_tokens_per_task"` // Zero until token usage has been observed
+}
+
+// AutoscalePolicy decides the desired number of workers for a snapshot. The result is clamped to the configured min/max.
+type AutoscalePolicy interface {
+	Name() string
+	Desired(snapshot ScalingSnapshot) int32
+}
+
+// QueueDepthPolicy runs one worker per TasksPerWorker queued subtasks
+type QueueDepthPolicy struct {
+	TasksPerWorker int32
+}
+
+func (p QueueDepthPolicy) Name() string {
+	return "queue_depth"
+}
+
+func (p QueueDepthPolicy) Desired(snapshot ScalingSnapshot) int32 {
+	perWorker := p.TasksPerWorker
+	if perWorker < 1 {
+		perWorker = 1
+	}
+	return (snapshot.QueuedTasks + perWorker - 1) / perWorker
+}
+
+// TargetLatencyPolicy runs enough workers to drain the current queue within Target, based on observed subtask latency
+type TargetLatencyPolicy struct {
+	Target time.Duration
+}
+
+func (p TargetLatencyPolicy) Name() string {
+	return "target_latency"
+}
+
+func (p TargetLatencyPolicy) Desired(snapshot ScalingSnapshot) int32 {
+	// Without latency samples, fall back to one worker per queued subtask
+	if snapshot.AvgTaskLatency == 0 || p.Target <= 0 {
+		return snapshot.QueuedTasks
+	}
+	work := float64(snapshot.QueuedTasks) * float64(snapshot.AvgTaskLatency)
+	return int32(math.Ceil(work / float64(p.Target)))
+}
+
+// TokenBudgetPolicy caps another policy so that the projected LLM token spend stays within TokensPerMinute
+type TokenBudgetPolicy struct {
+	Inner           AutoscalePolicy
+	TokensPerMinute int64
+	TokensPerTask   int64 // Estimate used until real usage has been observed
+}
+
+func (p TokenBudgetPolicy) Name() string {
+	return p.Inner.Name() + "+token_budget"
+}
+
+func (p TokenBudgetPolicy) Desired(snapshot ScalingSnapshot) int32 {
+	desired := p.Inner.Desired(snapshot)
+
+	tokensPerTask := snapshot.AvgTokensPerTask
+	if tokensPerTask == 0 {
+		tokensPerTask = p.TokensPerTask
+	}
+	if p.TokensPerMinute <= 0 || tokensPerTask <= 0 || snapshot.AvgTaskLatency == 0 {
+		return desired
+	}
+
+	// Each worker spends roughly tokensPerTask every AvgTaskLatency
+	tokensPerWorkerMinute := float64(tokensPerTask) * float64(time.Minute) / float64(snapshot.AvgTaskLatency)
+	budgetWorkers := int32(math.Max(1, math.Floor(float64(p.TokensPerMinute)/tokensPerWorkerMinute)))
+
+	return min(desired, budgetWorkers)
+}
+
+// AutoscaleConfig controls how WorkerManager sizes the worker pool
+type AutoscaleConfig struct {
+	Policy       AutoscalePolicy
+	MinWorkers   int32
+	MaxWorkers   int32
+	Cooldown     time.Duration // Minimum time between two scaling actions
+	PollInterval time.Duration // How often the queues are inspected
+	IdleTimeout  time.Duration // Workers above MinWorkers shut down after idling this long
+}
+
+// ScalingEvent records a single scaling decision
+type ScalingEvent struct {
+	Time          time.Time `json:"time"`
+	Node          string    `json:"node"`
+	Policy        string    `json:"policy"`
+	QueuedTasks   int32     `json:"queued_tasks"`
+	ActiveWorkers int32     `json:"active_workers"`
+	Desired       int32     `json:"desired"`
+	Action        string    `json:"action"` // "scale_up" or "scale_down"
+	Delta         int32     `json:"delta"`
+}
+
+// AutoscaleMetrics are process-local counters describing scaling activity
+type AutoscaleMetrics struct {
+	Policy           string        `json:"policy"`
+	ActiveWorkers    int32         `json:"active_workers"`
+	DesiredWorkers   int32         `json:"desired_workers"`
+	QueuedTasks      int32         `json:"queued_tasks"`
+	ScaleUps         int64         `json:"scale_ups"`
+	ScaleDowns       int64         `json:"scale_downs"`
+	WorkersSpawned   int64         `json:"workers_spawned"`
+	WorkersRetired   int64         `json:"workers_retired"`
+	AvgTaskLatency   time.Duration `json:"avg_task_latency"`
+	AvgTokensPerTask int64         `json:"avg_tokens_per_task"`
+	LastScaledAt     time.Time     `json:"last_scaled_at"`
+}
+
+var (
+	autoscaleConfig AutoscaleConfig
+
+	metricsMu        sync.Mutex
+	autoscaleMetrics AutoscaleMetrics
+
+	// pendingRetirements is the number of workers the manager wants to shut down. Idle workers claim them.
+	pendingRetirements int32 = 0
+)
+
+// AutoscaleConfigFromEnv builds the autoscaling configuration from AUTOSCALE_* environment variables
+func AutoscaleConfigFromEnv() AutoscaleConfig {
+	var policy AutoscalePolicy
+	switch os.Getenv("AUTOSCALE_POLICY") {
+	case "target_latency":
+		policy = TargetLatencyPolicy{Target: env.Duration("AUTOSCALE_TARGET_LATENCY", time.Minute)}
+	default:
+		policy = QueueDepthPolicy{TasksPerWorker: int32(env.Int64("AUTOSCALE_TASKS_PER_WORKER", 1))}
+	}
+
+	if tokensPerMinute := env.Int64("AUTOSCALE_TOKENS_PER_MINUTE", 0); tokensPerMinute > 0 {
+		policy = TokenBudgetPolicy{
+			Inner:           policy,
+			TokensPerMinute: tokensPerMinute,
+			TokensPerTask:   env.Int64("AUTOSCALE_TOKENS_PER_TASK", 2000),
+		}
+	}
+
+	return AutoscaleConfig{
+		Policy:       policy,
+		MinWorkers:   int32(env.Int64("AUTOSCALE_MIN_WORKERS", int64(minWorkers))),
+		MaxWorkers:   int32(env.Int64("AUTOSCALE_MAX_WORKERS", int64(maxWorkers))),
+		Cooldown:     env.Duration("AUTOSCALE_COOLDOWN", 0),
+		PollInterval: env.Duration("AUTOSCALE_POLL_INTERVAL", 5*time.Second),
+		IdleTimeout:  env.Duration("AUTOSCALE_IDLE_TIMEOUT", 10*time.Second),
+	}
+}
+
+// recordTaskLatency folds a completed subtask's duration into the moving average used by latency-aware policies
+func recordTaskLatency(d time.Duration) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if autoscaleMetrics.AvgTaskLatency == 0 {
+		autoscaleMetrics.AvgTaskLatency = d
+		return
+	}
+	// Exponentially weighted moving average, recent tasks weigh 20%
+	autoscaleMetrics.AvgTaskLatency = time.Duration(0.8*float64(autoscaleMetrics.AvgTaskLatency) + 0.2*float64(d))
+}
+
+// RecordTaskTokens folds a completed subtask's LLM token usage into the moving average used by token-aware policies
+func RecordTaskTokens(tokens int64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if autoscaleMetrics.AvgTokensPerTask == 0 {
+		autoscaleMetrics.AvgTokensPerTask = tokens
+		return
+	}
+	autoscaleMetrics.AvgTokensPerTask = int64(0.8*float64(autoscaleMetrics.AvgTokensPerTask) + 0.2*float64(tokens))
+}
+
+// GetAutoscaleMetrics returns a copy of the current scaling counters
+func GetAutoscaleMetrics() AutoscaleMetrics {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	return autoscaleMetrics
+}
+
+// GetAutoscaleConfig returns the configuration WorkerManager is running with
+func GetAutoscaleConfig() AutoscaleConfig {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	return autoscaleConfig
+}
+
+// ListScalingEvents returns the most recent scaling decisions across all nodes, newest first
+func ListScalingEvents(s *db.Store, limit int64) ([]ScalingEvent, error) {
+	raw, err := s.Rdb.LRange(ctx, autoscaleEventsKey, 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []ScalingEvent{}
+	for _, r := range raw {
+		var event ScalingEvent
+		if err := json.Unmarshal([]byte(r), &event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// publishScalingEvent logs a scaling decision and pushes it onto the capped event list in Redis
+func publishScalingEvent(s *db.Store, event ScalingEvent) {
+	metrics.ScalingEvents.WithLabelValues(event.Action).Inc()
+	s.Log.Info("Autoscaler decision", "policy", event.Policy, "action", event.Action, "delta", event.Delta,
+		"queued", event.QueuedTasks, "active", event.ActiveWorkers, "desired", event.Desired)
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	pipe := s.Rdb.TxPipeline()
+	pipe.LPush(ctx, autoscaleEventsKey, eventJSON)
+	pipe.LTrim(ctx, autoscaleEventsKey, 0, maxAutoscaleEvents-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		s.Log.Error("Autoscaler failed to publish scaling event", "error", err)
+	}
+}
+
+// clampWorkers bounds a desired worker count to the configured min and max
+func clampWorkers(desired int32, cfg AutoscaleConfig) int32 {
+	if desired < cfg.MinWorkers {
+		return cfg.MinWorkers
+	}
+	if desired > cfg.MaxWorkers {
+		return cfg.MaxWorkers
+	}
+	return desired
+}
+
+// scalingDecision returns how to move from current workers to desired ones: "scale_up" or "scale_down" and by how
+// many. It returns a zero delta while the cooldown since the last action lasts, and refuses to scale down further
+// while earlier retirements are still unclaimed.
+func scalingDecision(desired, current, pending int32, sinceLastScaled, cooldown time.Duration) (string, int32) {
+	if desired == current || sinceLastScaled < cooldown {
+		return "", 0
+	}
+	if desired > current {
+		return "scale_up", desired - current
+	}
+	if pending > 0 {
+		return "", 0
+	}
+	return "scale_down", current - desired
+}
+
+// claimRetirement lets an idle worker take one pending retirement. It returns true if the worker should shut down.
+func claimRetirement() bool {
+	for {
+		pending := atomic.LoadInt32(&pendingRetirements)
+		if pending <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&pendingRetirements, pending, pending-1) {
+			metricsMu.Lock()
+			autoscaleMetrics.WorkersRetired++
+			metricsMu.Unlock()
+			return true
+		}
+	}
+}
+
+// releaseIdleWorker decrements the active worker count unless that would drop the pool below minimum.
+// It returns true if the idle worker may shut down.
+func releaseIdleWorker(minimum int32) bool {
+	for {
+		current := atomic.LoadInt32(&activeWorkers)
+		if current <= minimum {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&activeWorkers, current, current-1) {
+			return true
+		}
+	}
+}
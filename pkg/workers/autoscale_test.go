@@ -0,0 +1,168 @@
+package workers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueueDepthPolicy(t *testing.T) {
+	tests := []struct {
+		perWorker int32
+		queued    int32
+		want      int32
+	}{
+		{1, 0, 0},
+		{1, 7, 7},
+		{5, 10, 2},
+		{5, 11, 3},
+		{5, 1, 1},
+		{0, 4, 4},
+		{-3, 4, 4},
+	}
+	for _, tt := range tests {
+		policy := QueueDepthPolicy{TasksPerWorker: tt.perWorker}
+		if got := policy.Desired(ScalingSnapshot{QueuedTasks: tt.queued}); got != tt.want {
+			t.Errorf("QueueDepthPolicy{%d}.Desired(queued %d) = %d, want %d", tt.perWorker, tt.queued, got, tt.want)
+		}
+	}
+}
+
+func TestTargetLatencyPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  time.Duration
+		queued  int32
+		latency time.Duration
+		want    int32
+	}{
+		{"no samples yet", time.Minute, 6, 0, 6},
+		{"no target", 0, 6, time.Second, 6},
+		{"drains within target", time.Minute, 12, 10 * time.Second, 2},
+		{"rounds up", time.Minute, 13, 10 * time.Second, 3},
+		{"slow subtasks", 30 * time.Second, 2, 2 * time.Minute, 8},
+		{"empty queue", time.Minute, 0, 10 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := TargetLatencyPolicy{Target: tt.target}
+			got := policy.Desired(ScalingSnapshot{QueuedTasks: tt.queued, AvgTaskLatency: tt.latency})
+			if got != tt.want {
+				t.Errorf("Desired() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBudgetPolicy(t *testing.T) {
+	inner := QueueDepthPolicy{TasksPerWorker: 1}
+	tests := []struct {
+		name     string
+		policy   TokenBudgetPolicy
+		snapshot ScalingSnapshot
+		want     int32
+	}{
+		{
+			name:     "budget caps the inner policy",
+			policy:   TokenBudgetPolicy{Inner: inner, TokensPerMinute: 6000, TokensPerTask: 1000},
+			snapshot: ScalingSnapshot{QueuedTasks: 20, AvgTaskLatency: 30 * time.Second},
+			want:     3, // Each worker spends 2000 tokens a minute
+		},
+		{
+			name:     "observed usage replaces the estimate",
+			policy:   TokenBudgetPolicy{Inner: inner, TokensPerMinute: 6000, TokensPerTask: 1000},
+			snapshot: ScalingSnapshot{QueuedTasks: 20, AvgTaskLatency: 30 * time.Second, AvgTokensPerTask: 500},
+			want:     6,
+		},
+		{
+			name:     "inner policy below the budget",
+			policy:   TokenBudgetPolicy{Inner: inner, TokensPerMinute: 6000, TokensPerTask: 1000},
+			snapshot: ScalingSnapshot{QueuedTasks: 2, AvgTaskLatency: 30 * time.Second},
+			want:     2,
+		},
+		{
+			name:     "at least one worker",
+			policy:   TokenBudgetPolicy{Inner: inner, TokensPerMinute: 10, TokensPerTask: 1000},
+			snapshot: ScalingSnapshot{QueuedTasks: 20, AvgTaskLatency: 30 * time.Second},
+			want:     1,
+		},
+		{
+			name:     "no latency samples",
+			policy:   TokenBudgetPolicy{Inner: inner, TokensPerMinute: 10, TokensPerTask: 1000},
+			snapshot: ScalingSnapshot{QueuedTasks: 20},
+			want:     20,
+		},
+		{
+			name:     "no token estimate",
+			policy:   TokenBudgetPolicy{Inner: inner, TokensPerMinute: 10},
+			snapshot: ScalingSnapshot{QueuedTasks: 20, AvgTaskLatency: 30 * time.Second},
+			want:     20,
+		},
+		{
+			name:     "wraps target latency",
+			policy:   TokenBudgetPolicy{Inner: TargetLatencyPolicy{Target: time.Minute}, TokensPerMinute: 4000, TokensPerTask: 1000},
+			snapshot: ScalingSnapshot{QueuedTasks: 12, AvgTaskLatency: 30 * time.Second},
+			want:     2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Desired(tt.snapshot); got != tt.want {
+				t.Errorf("Desired() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if got := (TokenBudgetPolicy{Inner: TargetLatencyPolicy{}}).Name(); got != "target_latency+token_budget" {
+		t.Errorf("Name() = %q", got)
+	}
+}
+
+func TestClampWorkers(t *testing.T) {
+	cfg := AutoscaleConfig{MinWorkers: 2, MaxWorkers: 10}
+	tests := []struct {
+		desired int32
+		want    int32
+	}{
+		{0, 2},
+		{2, 2},
+		{5, 5},
+		{10, 10},
+		{50, 10},
+	}
+	for _, tt := range tests {
+		if got := clampWorkers(tt.desired, cfg); got != tt.want {
+			t.Errorf("clampWorkers(%d) = %d, want %d", tt.desired, got, tt.want)
+		}
+	}
+}
+
+func TestScalingDecision(t *testing.T) {
+	tests := []struct {
+		name       string
+		desired    int32
+		current    int32
+		pending    int32
+		since      time.Duration
+		cooldown   time.Duration
+		wantAction string
+		wantDelta  int32
+	}{
+		{"steady", 4, 4, 0, time.Hour, 0, "", 0},
+		{"scale up", 7, 4, 0, time.Hour, time.Minute, "scale_up", 3},
+		{"scale down", 1, 4, 0, time.Hour, time.Minute, "scale_down", 3},
+		{"scale up during cooldown", 7, 4, 0, 30 * time.Second, time.Minute, "", 0},
+		{"scale down during cooldown", 1, 4, 0, 30 * time.Second, time.Minute, "", 0},
+		{"cooldown just elapsed", 7, 4, 0, time.Minute, time.Minute, "scale_up", 3},
+		{"no cooldown", 7, 4, 0, 0, 0, "scale_up", 3},
+		{"scale down waits for unclaimed retirements", 1, 4, 2, time.Hour, 0, "", 0},
+		{"scale up ignores unclaimed retirements", 7, 4, 2, time.Hour, 0, "scale_up", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, delta := scalingDecision(tt.desired, tt.current, tt.pending, tt.since, tt.cooldown)
+			if action != tt.wantAction || delta != tt.wantDelta {
+				t.Errorf("scalingDecision() = %q, %d, want %q, %d", action, delta, tt.wantAction, tt.wantDelta)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrSubtaskBudget is returned when growing a task's graph would take it past its budget's max_subtasks
+var ErrSubtaskBudget = errors.New("subtask budget exceeded")
+
+// BudgetStatus is the live view of a task's budget and spend
+type BudgetStatus struct {
+	models.TaskBudget
+	SpentTokens    int64   `json:"spent_tokens"`
+	SpentCost      float64 `json:"spent_cost"`
+	PausedSubtasks int64   `json:"paused_subtasks"`
+}
+
+func budgetKey(taskId uuid.UUID) string {
+	return fmt.Sprintf("task_budget:%s", taskId)
+}
+
+// pausedQueueKey holds subtasks set aside because their task ran out of budget
+func pausedQueueKey(taskId uuid.UUID) string {
+	return fmt.Sprintf("task_paused:%s", taskId)
+}
+
+// InitTaskBudget stores a task's limits in Redis where workers check them before running each subtask
+func InitTaskBudget(s *db.Store, budget models.TaskBudget) error {
+	return s.Rdb.HSet(ctx, budgetKey(budget.TaskId), map[string]interface{}{
+		"max_tokens":  budget.MaxTokens,
+		"max_cost":    budget.MaxCost,
+		"on_exceeded": budget.OnExceeded,
+	}).Err()
+}
+
+// ChargeBudget adds LLM spend to a task. It is a no-op for tasks without a budget.
+func ChargeBudget(s *db.Store, taskId uuid.UUID, tokens int64, cost float64) {
+	key := budgetKey(taskId)
+	if exists, err := s.Rdb.Exists(ctx, key).Result(); err != nil || exists == 0 {
+		return
+	}
+	pipe := s.Rdb.TxPipeline()
+	pipe.HIncrBy(ctx, key, "tokens", tokens)
+	pipe.HIncrByFloat(ctx, key, "cost", cost)
+	if _, err := pipe.Exec(ctx); err != nil {
+		s.Log.Error("Failed to charge task budget", "task_id", taskId, "error", err)
+	}
+}
+
+// budgetExceeded reports whether a task has spent its budget, and what to do with its remaining subtasks
+func budgetExceeded(s *db.Store, taskId uuid.UUID) (exceeded bool, onExceeded string) {
+	values, err := s.Rdb.HGetAll(ctx, budgetKey(taskId)).Result()
+	if err != nil || len(values) == 0 {
+		return false, ""
+	}
+
+	maxTokens, _ := strconv.ParseInt(values["max_tokens"], 10, 64)
+	maxCost, _ := strconv.ParseFloat(values["max_cost"], 64)
+	tokens, _ := strconv.ParseInt(values["tokens"], 10, 64)
+	cost, _ := strconv.ParseFloat(values["cost"], 64)
+
+	exceeded = (maxTokens > 0 && tokens >= maxTokens) || (maxCost > 0 && cost >= maxCost)
+	return exceeded, values["on_exceeded"]
+}
+
+// checkSubtaskBudget returns an error wrapping ErrSubtaskBudget when a task would hold more subtasks than its budget
+// allows. total counts the subtasks the task would have, replaced ones excluded.
+func checkSubtaskBudget(tx *gorm.DB, taskId uuid.UUID, total int) error {
+	var budget models.TaskBudget
+	if err := tx.Where("task_id = ?", taskId).First(&budget).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if budget.MaxSubtasks > 0 && total > budget.MaxSubtasks {
+		return fmt.Errorf("%w: task would have %d subtasks, budget allows %d", ErrSubtaskBudget, total, budget.MaxSubtasks)
+	}
+	return nil
+}
+
+// failSubtask records a subtask's failure and returns its new status: budget_exceeded, announced like any other
+// budget overrun, when it would have grown the task past its subtask budget, failed otherwise
+func failSubtask(s *db.Store, task models.Task, err error) string {
+	if !errors.Is(err, ErrSubtaskBudget) {
+		setSubtaskStatus(s, task, "failed", err.Error())
+		return "failed"
+	}
+	markBudgetExceeded(s, task.TaskId)
+	s.DB.Model(&models.Task{}).
+		Where("task_id = ? AND subtask_id = ?", task.TaskId, task.SubtaskId).
+		Update("status", "budget_exceeded")
+	s.Rdb.Set(ctx, SubtaskStatusKey(task.TaskId, task.SubtaskId), "budget_exceeded", 0)
+	PublishTaskEvent(s, models.TaskEvent{TaskId: task.TaskId, SubtaskId: task.SubtaskId, Event: "budget_exceeded", Detail: err.Error()})
+	return "budget_exceeded"
+}
+
+// markBudgetExceeded records the first time a task runs out of budget and notifies subscribers
+func markBudgetExceeded(s *db.Store, taskId uuid.UUID) {
+	now := time.Now().UTC()
+	first, err := s.Rdb.HSetNX(ctx, budgetKey(taskId), "exceeded_at", now.Format(time.RFC3339Nano)).Result()
+	if err != nil || !first {
+		return
+	}
+
+	s.Log.Warn("Task exceeded its budget", "task_id", taskId)
+	s.DB.Model(&models.TaskBudget{}).Where("task_id = ?", taskId).
+		Updates(map[string]interface{}{"status": "budget_exceeded", "exceeded_at": now})
+	PublishTaskEvent(s, models.TaskEvent{TaskId: taskId, Event: "budget_exceeded", Time: now})
+}
+
+// holdOverBudget sets aside a subtask whose task is out of budget. Paused subtasks can be resumed by raising the budget;
+// failed ones are marked budget_exceeded for good.
+func holdOverBudget(s *db.Store, task models.Task, payload string, onExceeded string) {
+	markBudgetExceeded(s, task.TaskId)
+
+	if onExceeded == "fail" {
+		s.DB.Model(&models.Task{}).
+			Where("task_id = ? AND subtask_id = ?", task.TaskId, task.SubtaskId).
+			Update("status", "budget_exceeded")
+		s.Rdb.Set(ctx, SubtaskStatusKey(task.TaskId, task.SubtaskId), "budget_exceeded", 0)
+		PublishTaskEvent(s, models.TaskEvent{TaskId: task.TaskId, SubtaskId: task.SubtaskId, Event: "budget_exceeded", Detail: "failed"})
+		checkTaskDone(s, task.TaskId)
+		return
+	}
+
+	s.Rdb.RPush(ctx, pausedQueueKey(task.TaskId), payload)
+	s.DB.Model(&models.Task{}).
+		Where("task_id = ? AND subtask_id = ?", task.TaskId, task.SubtaskId).
+		Update("status", "paused")
+	PublishTaskEvent(s, models.TaskEvent{TaskId: task.TaskId, SubtaskId: task.SubtaskId, Event: "budget_exceeded", Detail: "paused"})
+}
+
+// GetBudgetStatus returns a task's budget together with its current spend
+func GetBudgetStatus(s *db.Store, taskId uuid.UUID) (*BudgetStatus, error) {
+	var budget models.TaskBudget
+	if err := s.DB.Where("task_id = ?", taskId).First(&budget).Error; err != nil {
+		return nil, err
+	}
+
+	values, err := s.Rdb.HGetAll(ctx, budgetKey(taskId)).Result()
+	if err != nil {
+		return nil, err
+	}
+	tokens, _ := strconv.ParseInt(values["tokens"], 10, 64)
+	cost, _ := strconv.ParseFloat(values["cost"], 64)
+	paused, _ := s.Rdb.LLen(ctx, pausedQueueKey(taskId)).Result()
+
+	return &BudgetStatus{
+		TaskBudget:     budget,
+		SpentTokens:    tokens,
+		SpentCost:      cost,
+		PausedSubtasks: paused,
+	}, nil
+}
+
+// UpdateBudget changes a task's limits. If the task is back within budget its paused subtasks are requeued.
+func UpdateBudget(s *db.Store, taskId uuid.UUID, maxTokens int64, maxCost float64) (*BudgetStatus, error) {
+	if err := s.DB.Model(&models.TaskBudget{}).Where("task_id = ?", taskId).
+		Updates(map[string]interface{}{"max_tokens": maxTokens, "max_cost": maxCost}).Error; err != nil {
+		return nil, err
+	}
+	if err := s.Rdb.HSet(ctx, budgetKey(taskId), "max_tokens", maxTokens, "max_cost", maxCost).Err(); err != nil {
+		return nil, err
+	}
+
+	if exceeded, _ := budgetExceeded(s, taskId); !exceeded {
+		s.Rdb.HDel(ctx, budgetKey(taskId), "exceeded_at")
+		s.DB.Model(&models.TaskBudget{}).Where("task_id = ?", taskId).
+			Updates(map[string]interface{}{"status": "within_budget", "exceeded_at": nil})
+
+		var tenants []string
+		s.DB.Model(&models.Task{}).Where("task_id = ?", taskId).Limit(1).Pluck("tenant", &tenants)
+		tenant := ""
+		if len(tenants) > 0 {
+			tenant = tenants[0]
+		}
+		queueKey := TaskQueueKey(tenant, taskId)
+		resumed := 0
+		for {
+			payload, err := s.Rdb.LMove(ctx, pausedQueueKey(taskId), queueKey, "LEFT", "RIGHT").Result()
+			if err != nil {
+				break
+			}
+			var task models.Task
+			if err := json.Unmarshal([]byte(payload), &task); err == nil {
+				s.DB.Model(&models.Task{}).
+					Where("task_id = ? AND subtask_id = ?", task.TaskId, task.SubtaskId).
+					Update("status", "pending")
+			}
+			resumed++
+		}
+		if resumed > 0 {
+			PublishTaskEvent(s, models.TaskEvent{TaskId: taskId, Event: "budget_resumed", Detail: fmt.Sprintf("%d subtasks requeued", resumed)})
+		}
+	}
+
+	return GetBudgetStatus(s, taskId)
+}
@@ -0,0 +1,141 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workflow"
+	"github.com/google/uuid"
+)
+
+const (
+	taskEventsChannel = "task_events" // Redis pub/sub channel carrying models.TaskEvent payloads
+
+	// TaskEventQueue receives a copy of every event so exactly one node turns it into webhook deliveries
+	TaskEventQueue   = "task_event_queue"
+	taskEventBacklog = 10000 // Oldest events are dropped if nothing consumes the queue
+)
+
+// TaskEventProcessingKey holds the events a node has taken off TaskEventQueue but not yet turned into deliveries
+func TaskEventProcessingKey(node string) string {
+	return fmt.Sprintf("task_event_processing:%s", node)
+}
+
+// terminalStatuses are subtask states that will not change without outside intervention
+var terminalStatuses = []string{"completed", "failed", "budget_exceeded", "rejected", "skipped", "replaced"}
+
+// SubtaskStatusKey mirrors a subtask's status in Redis so workers can check its dependents' conditions without the database
+func SubtaskStatusKey(taskId uuid.UUID, subtaskId int) string {
+	return fmt.Sprintf("task_status:%s:%d", taskId, subtaskId)
+}
+
+// PublishTaskEvent announces a state transition to subscribers of the task_events channel and to the webhook queue
+func PublishTaskEvent(s *db.Store, event models.TaskEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	pipe := s.Rdb.TxPipeline()
+	pipe.Publish(ctx, taskEventsChannel, eventJSON)
+	pipe.RPush(ctx, TaskEventQueue, eventJSON)
+	pipe.LTrim(ctx, TaskEventQueue, -taskEventBacklog, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		s.Log.Error("Failed to publish task event", "event", event.Event, "task_id", event.TaskId, "job_id", event.JobId, "error", err)
+	}
+}
+
+// setSubtaskStatus records a subtask's new status and announces it as a subtask_<status> event
+func setSubtaskStatus(s *db.Store, task models.Task, status string, detail string) {
+	s.DB.Model(&models.Task{}).
+		Where("task_id = ? AND subtask_id = ?", task.TaskId, task.SubtaskId).
+		Update("status", status)
+	s.Rdb.Set(ctx, SubtaskStatusKey(task.TaskId, task.SubtaskId), status, 0)
+	PublishTaskEvent(s, models.TaskEvent{TaskId: task.TaskId, SubtaskId: task.SubtaskId, Event: "subtask_" + status, Detail: detail})
+}
+
+// startSubtask marks a pending subtask running. The update only applies to a pending row, so it serializes with a
+// replan that locks the task's rows; false means the subtask is no longer pending and must not run.
+func startSubtask(s *db.Store, task models.Task) (bool, error) {
+	result := s.DB.Model(&models.Task{}).
+		Where("task_id = ? AND subtask_id = ? AND status = ?", task.TaskId, task.SubtaskId, "pending").
+		Update("status", "running")
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	s.Rdb.Set(ctx, SubtaskStatusKey(task.TaskId, task.SubtaskId), "running", 0)
+	PublishTaskEvent(s, models.TaskEvent{TaskId: task.TaskId, SubtaskId: task.SubtaskId, Event: "subtask_running"})
+	return true, nil
+}
+
+// stopSubtask puts a running subtask back to pending so it can be started again after being requeued
+func stopSubtask(s *db.Store, task models.Task) {
+	s.DB.Model(&models.Task{}).
+		Where("task_id = ? AND subtask_id = ? AND status = ?", task.TaskId, task.SubtaskId, "running").
+		Update("status", "pending")
+}
+
+func taskDoneKey(taskId uuid.UUID) string {
+	return fmt.Sprintf("task_done:%s", taskId)
+}
+
+// unhandledFailures counts subtasks that did not complete and were not skipped or replaced, leaving out failures that
+// a dependent with a failure condition went on to handle, children whose map or composite parent completed, and
+// failures a replan took over
+func unhandledFailures(subtasks []models.Task) int {
+	status := make(map[int]string, len(subtasks))
+	for _, subtask := range subtasks {
+		status[subtask.SubtaskId] = subtask.Status
+	}
+	handled := map[int]bool{}
+	for _, subtask := range subtasks {
+		if subtask.SupersededBy != 0 {
+			handled[subtask.SubtaskId] = true
+		}
+		if subtask.ParentId != 0 && status[subtask.ParentId] == "completed" {
+			handled[subtask.SubtaskId] = true
+		}
+		for _, dep := range subtask.Dependencies {
+			if dep.Condition == workflow.ConditionFailure && subtask.Status == "completed" {
+				handled[dep.SubtaskId] = true
+			}
+		}
+	}
+
+	failed := 0
+	for id, subtaskStatus := range status {
+		if subtaskStatus != "completed" && subtaskStatus != "skipped" && subtaskStatus != "replaced" && !handled[id] {
+			failed++
+		}
+	}
+	return failed
+}
+
+// checkTaskDone publishes task_completed, or task_failed if a failure went unhandled, once every subtask of a task
+// has reached a terminal status. Map and composite subtasks whose children have all finished are settled first.
+// The Redis marker makes sure only one worker announces it.
+func checkTaskDone(s *db.Store, taskId uuid.UUID) {
+	finishParents(s, taskId)
+
+	var pending int64
+	if err := s.DB.Model(&models.Task{}).Where("task_id = ? AND status NOT IN ?", taskId, terminalStatuses).Count(&pending).Error; err != nil || pending > 0 {
+		return
+	}
+	if first, err := s.Rdb.SetNX(ctx, taskDoneKey(taskId), time.Now().UTC().Format(time.RFC3339Nano), 7*24*time.Hour).Result(); err != nil || !first {
+		return
+	}
+
+	var subtasks []models.Task
+	s.DB.Where("task_id = ?", taskId).Find(&subtasks)
+	if failed := unhandledFailures(subtasks); failed > 0 {
+		PublishTaskEvent(s, models.TaskEvent{TaskId: taskId, Event: "task_failed", Detail: fmt.Sprintf("%d subtasks did not complete", failed)})
+		return
+	}
+	PublishTaskEvent(s, models.TaskEvent{TaskId: taskId, Event: "task_completed"})
+}
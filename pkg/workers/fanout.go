@@ -0,0 +1,422 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/ai"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workflow"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// taskOutputKey holds a subtask's raw output, which map subtasks split into items
+func taskOutputKey(taskId uuid.UUID, subtaskId int) string {
+	return fmt.Sprintf("task_output:%s:%d", taskId, subtaskId)
+}
+
+// structuredOutputKey holds a subtask's structured output, validated against its output schema
+func structuredOutputKey(taskId uuid.UUID, subtaskId int) string {
+	return fmt.Sprintf("task_structured:%s:%d", taskId, subtaskId)
+}
+
+// childrenDoneKey makes sure only one worker aggregates the children of a map or composite subtask
+func childrenDoneKey(taskId uuid.UUID, subtaskId int) string {
+	return fmt.Sprintf("children_done:%s:%d", taskId, subtaskId)
+}
+
+// storeTaskOutput stores a subtask's raw output next to its result
+func storeTaskOutput(s *db.Store, task models.Task, output string) error {
+	return s.Rdb.Set(ctx, taskOutputKey(task.TaskId, task.SubtaskId), output, 0).Err()
+}
+
+// storeStructuredOutput stores a subtask's validated structured output for its dependents
+func storeStructuredOutput(s *db.Store, task models.Task, output json.RawMessage) error {
+	return s.Rdb.Set(ctx, structuredOutputKey(task.TaskId, task.SubtaskId), []byte(output), 0).Err()
+}
+
+// mapItems splits a dependency's output into items. A JSON array yields one item per element, with strings
+// unquoted; anything else yields one item per non-blank line.
+func mapItems(output string) ([]string, error) {
+	output = strings.TrimSpace(output)
+	var items []string
+	if strings.HasPrefix(output, "[") {
+		var elements []json.RawMessage
+		if err := json.Unmarshal([]byte(output), &elements); err != nil {
+			return nil, fmt.Errorf("output looks like a JSON array but does not parse: %v", err)
+		}
+		for _, element := range elements {
+			item := string(element)
+			if strings.HasPrefix(item, `"`) {
+				if err := json.Unmarshal(element, &item); err != nil {
+					return nil, err
+				}
+			}
+			items = append(items, item)
+		}
+	} else {
+		for _, line := range strings.Split(output, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				items = append(items, line)
+			}
+		}
+	}
+	if len(items) > workflow.MaxMapItems {
+		return nil, fmt.Errorf("output lists %d items, a map can spawn at most %d", len(items), workflow.MaxMapItems)
+	}
+	return items, nil
+}
+
+// shellQuote quotes s as a single POSIX shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// mapChild builds the child of a map subtask for one item. Templates without {{item}} get the item appended.
+func mapChild(parent models.Task, subtaskId int, index int, item string) models.Task {
+	spec := parent.Map
+	command := spec.Command
+	if command != "" {
+		if strings.Contains(command, workflow.ItemPlaceholder) {
+			command = strings.ReplaceAll(command, workflow.ItemPlaceholder, shellQuote(item))
+		} else {
+			command += " " + shellQuote(item)
+		}
+	}
+	prompt := spec.Prompt
+	if prompt != "" {
+		if strings.Contains(prompt, workflow.ItemPlaceholder) {
+			prompt = strings.ReplaceAll(prompt, workflow.ItemPlaceholder, item)
+		} else {
+			prompt += "\n\nItem: " + item
+		}
+	}
+	description := prompt
+	if description == "" {
+		description = command
+	}
+
+	name := parent.Name
+	if name == "" {
+		name = fmt.Sprintf("%d", parent.SubtaskId)
+	}
+	return models.Task{
+		TaskId:       parent.TaskId,
+		SubtaskId:    subtaskId,
+		Name:         fmt.Sprintf("%s[%d]", name, index),
+		Type:         spec.Type,
+		Description:  description,
+		Command:      command,
+		Dependencies: parent.Dependencies, // Already satisfied, passed on so the child sees the same context
+		OutputSchema: spec.OutputSchema,
+		ParentId:     parent.SubtaskId,
+		MapLimit:     spec.MaxConcurrency,
+		Status:       "pending",
+		Resources:    parent.Resources,
+		ClientId:     parent.ClientId,
+		TraceContext: parent.TraceContext,
+		Tags:         parent.Tags,
+		Tenant:       parent.Tenant,
+	}
+}
+
+// expandMap spawns one child per item in the output of the map's source dependency and marks the map expanded
+func expandMap(s *db.Store, task models.Task) (int, error) {
+	if task.Map == nil {
+		return 0, fmt.Errorf("map subtask has no map spec")
+	}
+	// Prefer the dependency's structured output, then its raw output, then its context
+	output, err := s.Rdb.Get(ctx, structuredOutputKey(task.TaskId, task.Map.From)).Result()
+	if errors.Is(err, redis.Nil) {
+		output, err = s.Rdb.Get(ctx, taskOutputKey(task.TaskId, task.Map.From)).Result()
+	}
+	if errors.Is(err, redis.Nil) {
+		output, err = s.Rdb.Get(ctx, fmt.Sprintf("task_result:%s:%d", task.TaskId, task.Map.From)).Result()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("reading the output of subtask %d: %v", task.Map.From, err)
+	}
+	items, err := mapItems(output)
+	if err != nil {
+		return 0, err
+	}
+
+	return spawnChildren(s, task, func(nextId int) ([]models.Task, error) {
+		children := make([]models.Task, 0, len(items))
+		for i, item := range items {
+			children = append(children, mapChild(task, nextId+i, i, item))
+		}
+		return children, nil
+	})
+}
+
+// expandComposite asks the LLM to break a composite subtask into a graph of children and splices them into the task.
+// Children inherit the composite's dependencies, and may be composite themselves until MAX_DECOMPOSITION_DEPTH.
+func expandComposite(ctx context.Context, s *db.Store, task models.Task, inputs []ai.Input) (int, error) {
+	maxDepth := ai.MaxDecompositionDepth()
+	if task.Depth >= maxDepth {
+		return 0, fmt.Errorf("composite subtask is at depth %d, the decomposition limit is %d", task.Depth, maxDepth)
+	}
+
+	// A retried composite keeps the children it already has rather than asking the LLM again
+	var existing int64
+	if err := s.DB.Model(&models.Task{}).Where("task_id = ? AND parent_id = ?", task.TaskId, task.SubtaskId).Count(&existing).Error; err != nil {
+		return 0, err
+	}
+	var subtasks []models.Subtask
+	if existing == 0 {
+		var err error
+		subtasks, err = ai.DecomposeSubtask(ctx, task, inputs, task.Depth+1 < maxDepth, ResourceNames())
+		if err != nil {
+			return 0, fmt.Errorf("decomposing: %v", err)
+		}
+		if len(subtasks) == 0 {
+			return 0, fmt.Errorf("decomposition produced no subtasks")
+		}
+		if len(subtasks) > workflow.MaxSteps {
+			return 0, fmt.Errorf("decomposition produced %d subtasks, at most %d are allowed", len(subtasks), workflow.MaxSteps)
+		}
+		allowed := []string{workflow.TypeCommand, workflow.TypeCode}
+		if task.Depth+1 < maxDepth {
+			allowed = append(allowed, workflow.TypeComposite)
+		}
+		for _, subtask := range subtasks {
+			if !slices.Contains(allowed, subtask.Type) {
+				return 0, fmt.Errorf("decomposition produced subtask %d of type %q", subtask.SubtaskId, subtask.Type)
+			}
+		}
+	}
+
+	return spawnChildren(s, task, func(nextId int) ([]models.Task, error) {
+		ids := make(map[int]int, len(subtasks))
+		for i, subtask := range subtasks {
+			ids[subtask.SubtaskId] = nextId + i
+		}
+		children := make([]models.Task, 0, len(subtasks))
+		for i, subtask := range subtasks {
+			child := compositeChild(task, nextId+i, i, subtask)
+			for _, dep := range subtask.Dependencies {
+				depId, ok := ids[dep]
+				if !ok {
+					depId = -dep // Left unresolved so ValidateGraph reports it
+				}
+				child.Dependencies = append(child.Dependencies, models.Dependency{TaskId: task.TaskId, SubtaskId: depId})
+			}
+			children = append(children, child)
+		}
+		if err := workflow.ValidateGraph(children); err != nil {
+			return nil, fmt.Errorf("decomposition produced an invalid graph: %v", err)
+		}
+		// Children see the context the composite was given, as the composite itself would have
+		for i := range children {
+			children[i].Dependencies = append(children[i].Dependencies, task.Dependencies...)
+		}
+		return children, nil
+	})
+}
+
+// compositeChild builds one child of a composite subtask from the LLM's decomposition
+func compositeChild(parent models.Task, subtaskId int, index int, subtask models.Subtask) models.Task {
+	name := parent.Name
+	if name == "" {
+		name = fmt.Sprintf("%d", parent.SubtaskId)
+	}
+	resources := append([]string{}, parent.Resources...)
+	for _, resource := range subtask.Resources {
+		if !slices.Contains(resources, resource) {
+			resources = append(resources, resource)
+		}
+	}
+	return models.Task{
+		TaskId:       parent.TaskId,
+		SubtaskId:    subtaskId,
+		Name:         fmt.Sprintf("%s.%d", name, index+1),
+		Type:         subtask.Type,
+		Description:  subtask.Description,
+		ParentId:     parent.SubtaskId,
+		Depth:        parent.Depth + 1,
+		Status:       "pending",
+		Resources:    resources,
+		ClientId:     parent.ClientId,
+		TraceContext: parent.TraceContext,
+		Tags:         parent.Tags,
+		Tenant:       parent.Tenant,
+	}
+}
+
+// queueTasks pushes stored subtasks onto their task's queue. Subtasks that already have a Redis status have been
+// queued before and are left alone, so a retried caller does not queue them twice.
+func queueTasks(s *db.Store, tasks []models.Task) error {
+	for _, task := range tasks {
+		statusKey := SubtaskStatusKey(task.TaskId, task.SubtaskId)
+		if first, err := s.Rdb.SetNX(ctx, statusKey, "pending", 0).Result(); err != nil || !first {
+			continue
+		}
+		taskJSON, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		if err := s.Rdb.RPush(ctx, TaskQueueKey(task.Tenant, task.TaskId), taskJSON).Err(); err != nil {
+			s.Rdb.Del(ctx, statusKey)
+			return fmt.Errorf("queueing subtask %d: %v", task.SubtaskId, err)
+		}
+	}
+	return nil
+}
+
+// spawnChildren stores the children build returns, numbered after the task's existing subtasks, queues them and marks
+// the parent expanded. Children that would take the task past its budget's max_subtasks are refused with
+// ErrSubtaskBudget. If an earlier attempt already stored children, build is not called and only those children
+// that never reached the queue are pushed.
+func spawnChildren(s *db.Store, parent models.Task, build func(nextId int) ([]models.Task, error)) (int, error) {
+	var children []models.Task
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		// Locking the task's rows serialises parents of the same task that expand at once, so their subtask IDs don't collide
+		var subtasks []models.Task
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("task_id = ?", parent.TaskId).
+			Order("subtask_id").Find(&subtasks).Error; err != nil {
+			return err
+		}
+		nextId := 1
+		live := 0
+		for _, subtask := range subtasks {
+			if subtask.ParentId == parent.SubtaskId {
+				children = append(children, subtask)
+			}
+			if subtask.Status != "replaced" {
+				live++
+			}
+			nextId = max(nextId, subtask.SubtaskId+1)
+		}
+		if len(children) > 0 {
+			return nil
+		}
+		var err error
+		if children, err = build(nextId); err != nil || len(children) == 0 {
+			return err
+		}
+		// Spawned children count against the task's max_subtasks like the subtasks it was submitted with
+		if err := checkSubtaskBudget(tx, parent.TaskId, live+len(children)); err != nil {
+			children = nil
+			return err
+		}
+		if err := tx.Create(&children).Error; err != nil {
+			return fmt.Errorf("storing children: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := queueTasks(s, children); err != nil {
+		return 0, err
+	}
+
+	setSubtaskStatus(s, parent, "expanded", fmt.Sprintf("%d children", len(children)))
+	return len(children), nil
+}
+
+// finishParents settles expanded map and composite subtasks of a task whose children have all finished. A parent
+// completes with a JSON array of its completed children's outputs, in order, unless a map had more failed children
+// than it tolerates, a composite had a failure none of its children handled, or the array of the children's
+// structured outputs does not match the parent's output schema. Children always have higher subtask
+// IDs than their parent, so settling in descending order finishes nested parents innermost first.
+func finishParents(s *db.Store, taskId uuid.UUID) {
+	var parents []models.Task
+	if err := s.DB.Where("task_id = ? AND type IN ? AND status = ?", taskId, []string{workflow.TypeMap, workflow.TypeComposite}, "expanded").
+		Order("subtask_id DESC").Find(&parents).Error; err != nil {
+		return
+	}
+
+	for _, parent := range parents {
+		var children []models.Task
+		if err := s.DB.Where("task_id = ? AND parent_id = ?", taskId, parent.SubtaskId).Order("subtask_id").Find(&children).Error; err != nil {
+			continue
+		}
+		if slices.ContainsFunc(children, func(child models.Task) bool { return !slices.Contains(terminalStatuses, child.Status) }) {
+			continue
+		}
+		if first, err := s.Rdb.SetNX(ctx, childrenDoneKey(taskId, parent.SubtaskId), time.Now().UTC().Format(time.RFC3339Nano), 7*24*time.Hour).Result(); err != nil || !first {
+			continue
+		}
+
+		outputs := []string{}
+		structured := []json.RawMessage{}
+		var summary strings.Builder
+		failed := 0
+		for _, child := range children {
+			switch child.Status {
+			case "completed":
+				output, _ := s.Rdb.Get(ctx, taskOutputKey(taskId, child.SubtaskId)).Result()
+				outputs = append(outputs, output)
+				// Children without a structured output contribute their raw output as a JSON string
+				value, err := s.Rdb.Get(ctx, structuredOutputKey(taskId, child.SubtaskId)).Bytes()
+				if err != nil {
+					value, _ = json.Marshal(output)
+				}
+				structured = append(structured, value)
+				result, _ := s.Rdb.Get(ctx, fmt.Sprintf("task_result:%s:%d", taskId, child.SubtaskId)).Result()
+				fmt.Fprintf(&summary, "%s: %s\n", child.Name, result)
+			case "skipped":
+			default:
+				failed++
+				fmt.Fprintf(&summary, "%s: %s\n", child.Name, child.Status)
+			}
+		}
+
+		detail := fmt.Sprintf("%d of %d children completed", len(outputs), len(children))
+		heading := fmt.Sprintf("Decomposed into %d subtasks, %s.", len(children), detail)
+		tolerated := unhandledFailures(children) == 0
+		if parent.Type == workflow.TypeMap {
+			heading = fmt.Sprintf("Mapped over %d items, %s.", len(children), detail)
+			maxFailures := 0
+			if parent.Map != nil {
+				maxFailures = parent.Map.MaxFailures
+			}
+			tolerated = failed <= maxFailures
+		}
+		if !tolerated {
+			setSubtaskStatus(s, parent, "failed", fmt.Sprintf("%s, %d failed", detail, failed))
+			continue
+		}
+
+		structuredJSON, _ := json.Marshal(structured)
+		if parent.OutputSchema != nil {
+			if err := validateStructuredOutput(parent, structuredJSON); err != nil {
+				setSubtaskStatus(s, parent, "failed", fmt.Sprintf("%s, structured output: %v", detail, err))
+				continue
+			}
+			if err := storeStructuredOutput(s, parent, structuredJSON); err != nil {
+				s.Log.Error("Failed to store aggregated structured output", "task_id", taskId, "subtask_id", parent.SubtaskId, "error", err)
+			}
+		}
+
+		outputJSON, _ := json.Marshal(outputs)
+		if err := storeTaskOutput(s, parent, string(outputJSON)); err != nil {
+			s.Log.Error("Failed to store aggregated output", "task_id", taskId, "subtask_id", parent.SubtaskId, "error", err)
+		}
+		if err := storeTaskResult(s, parent, heading+"\n"+summary.String()); err != nil {
+			s.Log.Error("Failed to store aggregated result", "task_id", taskId, "subtask_id", parent.SubtaskId, "error", err)
+		}
+		setSubtaskStatus(s, parent, "completed", detail)
+	}
+}
+
+// validateStructuredOutput checks a value against a subtask's output schema
+func validateStructuredOutput(task models.Task, value json.RawMessage) error {
+	schema, err := workflow.CompileSchema(task.OutputSchema)
+	if err != nil {
+		return fmt.Errorf("invalid output schema: %v", err)
+	}
+	return workflow.ValidateOutput(schema, value)
+}
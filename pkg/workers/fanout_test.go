@@ -0,0 +1,173 @@
+package workers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workflow"
+	"github.com/google/uuid"
+)
+
+func TestMapItems(t *testing.T) {
+	lines := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "item-%d\n", i)
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		name    string
+		output  string
+		want    []string
+		wantLen int
+		wantErr string
+	}{
+		{"lines", "a\nb\nc", []string{"a", "b", "c"}, 0, ""},
+		{"blank and padded lines", "\n  a  \n\n\tb\r\n\n", []string{"a", "b"}, 0, ""},
+		{"empty", "   \n", nil, 0, ""},
+		{"json strings are unquoted", `["a b", "it's", "line\nbreak"]`, []string{"a b", "it's", "line\nbreak"}, 0, ""},
+		{"json non-strings keep their encoding", `[1, true, null, {"k": "v"}, [2]]`, []string{"1", "true", "null", `{"k": "v"}`, "[2]"}, 0, ""},
+		{"json with surrounding whitespace", "\n  [\"x\"]  \n", []string{"x"}, 0, ""},
+		{"empty json array", "[]", nil, 0, ""},
+		{"line that is not an array", "a [b]", []string{"a [b]"}, 0, ""},
+		{"broken json array", `["a", `, nil, 0, "does not parse"},
+		{"json array at the cap", fmt.Sprintf("[%s1]", strings.Repeat("1,", workflow.MaxMapItems-1)), nil, workflow.MaxMapItems, ""},
+		{"json array over the cap", fmt.Sprintf("[%s1]", strings.Repeat("1,", workflow.MaxMapItems)), nil, 0, "at most 1000"},
+		{"lines over the cap", lines(workflow.MaxMapItems + 1), nil, 0, "at most 1000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mapItems(tt.output)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("mapItems() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mapItems() error = %v", err)
+			}
+			if tt.wantLen > 0 {
+				if len(got) != tt.wantLen {
+					t.Errorf("mapItems() returned %d items, want %d", len(got), tt.wantLen)
+				}
+			} else if !slices.Equal(got, tt.want) {
+				t.Errorf("mapItems() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", `''`},
+		{"plain", `'plain'`},
+		{"two words", `'two words'`},
+		{"it's", `'it'\''s'`},
+		{"''", `''\'''\'''`},
+		{"$(rm -rf /); `id`", "'$(rm -rf /); `id`'"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMapChild(t *testing.T) {
+	parent := models.Task{
+		TaskId:       uuid.New(),
+		SubtaskId:    3,
+		Name:         "deploy",
+		Dependencies: []models.Dependency{{SubtaskId: 1}},
+		Resources:    []string{"groq-api"},
+		ClientId:     "client",
+		Tenant:       "acme",
+	}
+	tests := []struct {
+		name            string
+		parentName      string
+		spec            models.MapSpec
+		item            string
+		wantName        string
+		wantCommand     string
+		wantDescription string
+	}{
+		{
+			name:            "command placeholder",
+			spec:            models.MapSpec{Type: workflow.TypeCommand, Command: "deploy --host {{item}} && ping {{item}}"},
+			item:            "web 1",
+			wantName:        "deploy[2]",
+			wantCommand:     "deploy --host 'web 1' && ping 'web 1'",
+			wantDescription: "deploy --host 'web 1' && ping 'web 1'",
+		},
+		{
+			name:            "command without placeholder appends",
+			spec:            models.MapSpec{Type: workflow.TypeCommand, Command: "deploy --host"},
+			item:            "it's",
+			wantName:        "deploy[2]",
+			wantCommand:     `deploy --host 'it'\''s'`,
+			wantDescription: `deploy --host 'it'\''s'`,
+		},
+		{
+			name:            "prompt placeholder is not quoted",
+			spec:            models.MapSpec{Type: workflow.TypeCode, Prompt: "Summarize {{item}}"},
+			item:            "it's",
+			wantName:        "deploy[2]",
+			wantDescription: "Summarize it's",
+		},
+		{
+			name:            "prompt without placeholder appends",
+			spec:            models.MapSpec{Type: workflow.TypeCode, Prompt: "Summarize the file"},
+			item:            "a.go",
+			wantName:        "deploy[2]",
+			wantDescription: "Summarize the file\n\nItem: a.go",
+		},
+		{
+			name:            "unnamed parent",
+			parentName:      "-",
+			spec:            models.MapSpec{Type: workflow.TypeCommand, Command: "echo"},
+			item:            "x",
+			wantName:        "3[2]",
+			wantCommand:     "echo 'x'",
+			wantDescription: "echo 'x'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parent
+			if tt.parentName == "-" {
+				p.Name = ""
+			}
+			spec := tt.spec
+			spec.MaxConcurrency = 4
+			p.Map = &spec
+
+			child := mapChild(p, 12, 2, tt.item)
+			if child.Name != tt.wantName {
+				t.Errorf("Name = %q, want %q", child.Name, tt.wantName)
+			}
+			if child.Command != tt.wantCommand {
+				t.Errorf("Command = %q, want %q", child.Command, tt.wantCommand)
+			}
+			if child.Description != tt.wantDescription {
+				t.Errorf("Description = %q, want %q", child.Description, tt.wantDescription)
+			}
+			if child.SubtaskId != 12 || child.ParentId != 3 || child.Type != spec.Type || child.MapLimit != 4 || child.Status != "pending" {
+				t.Errorf("child = %+v, want pending subtask 12 of parent 3 with the map's type and limit", child)
+			}
+			if child.TaskId != p.TaskId || child.Tenant != p.Tenant || child.ClientId != p.ClientId ||
+				!slices.Equal(child.Resources, p.Resources) || len(child.Dependencies) != 1 {
+				t.Errorf("child = %+v, want the parent's task, owner, resources and dependencies", child)
+			}
+		})
+	}
+}
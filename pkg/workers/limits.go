@@ -0,0 +1,240 @@
+package workers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/env"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// semaphoreTTL bounds how long a permit survives without being refreshed, so a crashed worker cannot hold one forever
+const semaphoreTTL = 30 * time.Second
+
+// ConcurrencyLimits caps how many subtasks may run at once across all nodes
+type ConcurrencyLimits struct {
+	PerType       map[string]int64    // Max concurrent subtasks of each type
+	PerQueue      int64               // Max concurrent subtasks within one task queue, 0 for unlimited
+	Resources     map[string]int64    // Named resources (e.g. "groq-api") and their global capacity
+	TypeResources map[string][]string // Resources every subtask of a type needs in addition to its own
+}
+
+// ResourceUsage is the current occupancy of a single semaphore
+type ResourceUsage struct {
+	Name  string `json:"name"`
+	Limit int64  `json:"limit"`
+	InUse int64  `json:"in_use"`
+}
+
+var (
+	limitsMu          sync.RWMutex
+	concurrencyLimits ConcurrencyLimits
+)
+
+// acquireScript takes a permit on a semaphore stored as a sorted set of holders scored by expiry.
+// KEYS[1] = semaphore key, ARGV = now (ms), limit, holder, expiry (ms)
+var acquireScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZSCORE', KEYS[1], ARGV[3]) then
+	redis.call('ZADD', KEYS[1], ARGV[4], ARGV[3])
+	return 1
+end
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[4], ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// parseLimits parses "name=limit,name=limit" into a map, ignoring malformed entries
+func parseLimits(value string) map[string]int64 {
+	limits := make(map[string]int64)
+	for _, entry := range strings.Split(value, ",") {
+		name, limit, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(limit), 10, 64); err == nil && n > 0 {
+			limits[strings.TrimSpace(name)] = n
+		}
+	}
+	return limits
+}
+
+// ConcurrencyLimitsFromEnv reads limits from the environment:
+//
+//	TYPE_CONCURRENCY="code_generation=5,command_execution=10"
+//	QUEUE_CONCURRENCY=3
+//	RESOURCE_LIMITS="groq-api=5,gpu-free-cpu-heavy=2"
+//	TYPE_RESOURCES="code_generation=groq-api,command_execution=groq-api+gpu-free-cpu-heavy"
+func ConcurrencyLimitsFromEnv() ConcurrencyLimits {
+	typeResources := make(map[string][]string)
+	for _, entry := range strings.Split(os.Getenv("TYPE_RESOURCES"), ",") {
+		taskType, resources, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			continue
+		}
+		taskType = strings.TrimSpace(taskType)
+		for _, resource := range strings.Split(resources, "+") {
+			if resource = strings.TrimSpace(resource); resource != "" {
+				typeResources[taskType] = append(typeResources[taskType], resource)
+			}
+		}
+	}
+
+	return ConcurrencyLimits{
+		PerType:       parseLimits(os.Getenv("TYPE_CONCURRENCY")),
+		PerQueue:      env.Int64("QUEUE_CONCURRENCY", 0),
+		Resources:     parseLimits(os.Getenv("RESOURCE_LIMITS")),
+		TypeResources: typeResources,
+	}
+}
+
+// SetConcurrencyLimits replaces the limits workers enforce before running a subtask
+func SetConcurrencyLimits(limits ConcurrencyLimits) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	concurrencyLimits = limits
+}
+
+// ResourceNames lists the configured named resources, sorted
+func ResourceNames() []string {
+	limits := getConcurrencyLimits()
+	names := make([]string, 0, len(limits.Resources))
+	for name := range limits.Resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getConcurrencyLimits() ConcurrencyLimits {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+	return concurrencyLimits
+}
+
+func semaphoreKey(name string) string {
+	return fmt.Sprintf("semaphore:%s", name)
+}
+
+// permit is a single semaphore a task needs before it can run
+type permit struct {
+	name  string
+	limit int64
+}
+
+// requiredPermits lists the semaphores a task must hold: its tenant, its type, its queue, its map, and every declared
+// or type-implied resource
+func requiredPermits(limits ConcurrencyLimits, tenant models.Tenant, task models.Task) []permit {
+	permits := []permit{tenantPermit(tenant)}
+	if limit, ok := limits.PerType[task.Type]; ok {
+		permits = append(permits, permit{name: "type:" + task.Type, limit: limit})
+	}
+	if limits.PerQueue > 0 {
+		permits = append(permits, permit{name: "queue:" + task.TaskId.String(), limit: limits.PerQueue})
+	}
+	if task.ParentId != 0 && task.MapLimit > 0 {
+		permits = append(permits, permit{name: fmt.Sprintf("map:%s:%d", task.TaskId, task.ParentId), limit: task.MapLimit})
+	}
+
+	seen := make(map[string]bool)
+	resources := append(append([]string{}, limits.TypeResources[task.Type]...), task.Resources...)
+	for _, resource := range resources {
+		limit, ok := limits.Resources[resource]
+		if !ok || seen[resource] {
+			// Resources without a configured limit are unconstrained
+			continue
+		}
+		seen[resource] = true
+		permits = append(permits, permit{name: "resource:" + resource, limit: limit})
+	}
+
+	// Acquire in a stable order so two workers never deadlock-retry each other on the same pair
+	sort.Slice(permits, func(i, j int) bool { return permits[i].name < permits[j].name })
+	return permits
+}
+
+// holderId identifies a worker's claim on a semaphore
+func holderId(workerId int32, task models.Task) string {
+	return fmt.Sprintf("%s:%s:%d", workerKey(workerId), task.TaskId, task.SubtaskId)
+}
+
+// acquirePermits tries to take every permit a task needs. It is all-or-nothing: on failure nothing is held
+// and the name of the exhausted semaphore is returned.
+func acquirePermits(s *db.Store, workerId int32, task models.Task) (held []string, exhausted string, err error) {
+	holder := holderId(workerId, task)
+	for _, p := range requiredPermits(getConcurrencyLimits(), getTenant(s, task.Tenant).tenant, task) {
+		now := time.Now()
+		ok, err := acquireScript.Run(ctx, s.Rdb, []string{semaphoreKey(p.name)},
+			now.UnixMilli(), p.limit, holder, now.Add(semaphoreTTL).UnixMilli()).Int()
+		if err != nil || ok == 0 {
+			releasePermits(s, workerId, task, held)
+			return nil, p.name, err
+		}
+		held = append(held, p.name)
+	}
+	return held, "", nil
+}
+
+// releasePermits gives back permits taken by acquirePermits
+func releasePermits(s *db.Store, workerId int32, task models.Task, held []string) {
+	holder := holderId(workerId, task)
+	for _, name := range held {
+		s.Rdb.ZRem(ctx, semaphoreKey(name), holder)
+	}
+}
+
+// refreshPermits extends held permits until stop is closed, so long-running subtasks keep their slots
+func refreshPermits(s *db.Store, workerId int32, task models.Task, held []string, stop <-chan struct{}) {
+	if len(held) == 0 {
+		return
+	}
+	holder := holderId(workerId, task)
+	ticker := time.NewTicker(semaphoreTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			expiry := float64(time.Now().Add(semaphoreTTL).UnixMilli())
+			for _, name := range held {
+				s.Rdb.ZAddXX(ctx, semaphoreKey(name), redis.Z{Score: expiry, Member: holder})
+			}
+		}
+	}
+}
+
+// ListResourceUsage returns the occupancy of every configured type and resource semaphore
+func ListResourceUsage(s *db.Store) ([]ResourceUsage, error) {
+	limits := getConcurrencyLimits()
+
+	var names []permit
+	for taskType, limit := range limits.PerType {
+		names = append(names, permit{name: "type:" + taskType, limit: limit})
+	}
+	for resource, limit := range limits.Resources {
+		names = append(names, permit{name: "resource:" + resource, limit: limit})
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].name < names[j].name })
+
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	usage := []ResourceUsage{}
+	for _, p := range names {
+		inUse, err := s.Rdb.ZCount(ctx, semaphoreKey(p.name), now, "+inf").Result()
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, ResourceUsage{Name: p.name, Limit: p.limit, InUse: inUse})
+	}
+	return usage, nil
+}
@@ -0,0 +1,131 @@
+package workers
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/google/uuid"
+)
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[string]int64
+	}{
+		{"", map[string]int64{}},
+		{"groq-api=5", map[string]int64{"groq-api": 5}},
+		{" groq-api = 5 , gpu=2 ", map[string]int64{"groq-api": 5, "gpu": 2}},
+		{"a=1,a=3", map[string]int64{"a": 3}},
+		{"a=1,,b", map[string]int64{"a": 1}},
+		{"a=0,b=-2,c=x,d=", map[string]int64{}},
+		{"a=1=2", map[string]int64{}},
+	}
+	for _, tt := range tests {
+		if got := parseLimits(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLimits(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestConcurrencyLimitsFromEnv(t *testing.T) {
+	t.Setenv("TYPE_CONCURRENCY", "code_generation=5,command_execution=10")
+	t.Setenv("QUEUE_CONCURRENCY", "3")
+	t.Setenv("RESOURCE_LIMITS", "groq-api=5,gpu=2")
+	t.Setenv("TYPE_RESOURCES", "code_generation=groq-api, command_execution = groq-api + gpu +,broken,composite=")
+
+	want := ConcurrencyLimits{
+		PerType:   map[string]int64{"code_generation": 5, "command_execution": 10},
+		PerQueue:  3,
+		Resources: map[string]int64{"groq-api": 5, "gpu": 2},
+		TypeResources: map[string][]string{
+			"code_generation":   {"groq-api"},
+			"command_execution": {"groq-api", "gpu"},
+		},
+	}
+	if got := ConcurrencyLimitsFromEnv(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ConcurrencyLimitsFromEnv() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRequiredPermits(t *testing.T) {
+	taskId := uuid.New()
+	limits := ConcurrencyLimits{
+		PerType:       map[string]int64{"code_generation": 5},
+		Resources:     map[string]int64{"groq-api": 4, "gpu": 2},
+		TypeResources: map[string][]string{"code_generation": {"groq-api"}},
+	}
+	tenant := models.Tenant{Name: "acme", MaxConcurrentSubtasks: 8}
+
+	tests := []struct {
+		name   string
+		limits ConcurrencyLimits
+		tenant models.Tenant
+		task   models.Task
+		want   []permit
+	}{
+		{
+			name:   "tenant only",
+			limits: ConcurrencyLimits{},
+			tenant: models.Tenant{Name: "acme"},
+			task:   models.Task{TaskId: taskId, Type: "command_execution"},
+			want:   []permit{{"tenant:acme", math.MaxInt32}},
+		},
+		{
+			name:   "type, queue and map",
+			limits: ConcurrencyLimits{PerType: limits.PerType, PerQueue: 3},
+			tenant: tenant,
+			task:   models.Task{TaskId: taskId, Type: "code_generation", ParentId: 2, MapLimit: 6},
+			want: []permit{
+				{"map:" + taskId.String() + ":2", 6},
+				{"queue:" + taskId.String(), 3},
+				{"tenant:acme", 8},
+				{"type:code_generation", 5},
+			},
+		},
+		{
+			name:   "map limit only applies to children",
+			limits: ConcurrencyLimits{},
+			tenant: tenant,
+			task:   models.Task{TaskId: taskId, Type: "command_execution", MapLimit: 6},
+			want:   []permit{{"tenant:acme", 8}},
+		},
+		{
+			name:   "type and declared resources are deduplicated and sorted",
+			limits: limits,
+			tenant: tenant,
+			task:   models.Task{TaskId: taskId, Type: "code_generation", Resources: []string{"gpu", "groq-api", "gpu"}},
+			want: []permit{
+				{"resource:gpu", 2},
+				{"resource:groq-api", 4},
+				{"tenant:acme", 8},
+				{"type:code_generation", 5},
+			},
+		},
+		{
+			name:   "unconfigured resource is unconstrained",
+			limits: limits,
+			tenant: tenant,
+			task:   models.Task{TaskId: taskId, Type: "command_execution", Resources: []string{"tpu", "gpu"}},
+			want:   []permit{{"resource:gpu", 2}, {"tenant:acme", 8}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := requiredPermits(tt.limits, tt.tenant, tt.task)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requiredPermits() = %v, want %v", got, tt.want)
+			}
+			// Declaration order must not change the acquisition order
+			reversed := tt.task
+			reversed.Resources = nil
+			for i := len(tt.task.Resources) - 1; i >= 0; i-- {
+				reversed.Resources = append(reversed.Resources, tt.task.Resources[i])
+			}
+			if again := requiredPermits(tt.limits, tt.tenant, reversed); !reflect.DeepEqual(again, got) {
+				t.Errorf("requiredPermits() with resources reversed = %v, want %v", again, got)
+			}
+		})
+	}
+}
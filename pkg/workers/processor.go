@@ -2,8 +2,7 @@ package workers
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"log/slog"
 	"os/exec"
 	"time"
 
@@ -19,7 +18,7 @@ func InitWorkerPool(store *db.Store, numWorkers int, retryCount int) {
 	jobChannel := make(chan string, 100) // Buffered channel for job commands
 
 	// Start worker goroutines
-	fmt.Printf("Initializing worker pool of size: %d\n", numWorkers)
+	store.Log.Info("Initializing worker pool", "size", numWorkers)
 	for i := 0; i < numWorkers; i++ {
 		go worker(ctx, store, jobChannel, i, retryCount)
 	}
@@ -28,12 +27,12 @@ func InitWorkerPool(store *db.Store, numWorkers int, retryCount int) {
 	for {
 		result, err := store.Rdb.BLPop(ctx, 0, "job_queue").Result()
 		if err != nil {
-			log.Printf("Failed to fetch job from Redis: %s\n", err)
+			store.Log.Error("Failed to fetch job from Redis", "error", err)
 			continue
 		}
 
 		command := result[1]
-		log.Printf("Dequeued job: %s\n", command)
+		store.Log.Debug("Dequeued job", "command", command)
 
 		// Push command to the job channel
 		jobChannel <- command
@@ -43,7 +42,8 @@ func InitWorkerPool(store *db.Store, numWorkers int, retryCount int) {
 // worker processes jobs received from a channel
 func worker(ctx context.Context, store *db.Store, jobChannel chan string, workerId int, maxRetries int) {
 	for command := range jobChannel {
-		log.Printf("Worker %d processing job: %s\n", workerId, command)
+		logger := store.Log.With("worker_id", workerId)
+		logger.Info("Worker processing job", "command", command)
 
 		// Fetch or initialize job
 		var job models.Job
@@ -56,7 +56,7 @@ func worker(ctx context.Context, store *db.Store, jobChannel chan string, worker
 				RetryCount:    0,
 			}
 			if err := store.DB.Create(&job).Error; err != nil {
-				log.Printf("Worker %d failed to log job in database: %s\n", workerId, err)
+				logger.Error("Worker failed to log job in database", "error", err)
 				continue
 			}
 		} else {
@@ -64,15 +64,17 @@ func worker(ctx context.Context, store *db.Store, jobChannel chan string, worker
 			job.Status = "Running"
 			job.ExecutionTime = time.Now().UTC()
 			if err := store.DB.Save(&job).Error; err != nil {
-				log.Printf("Worker %d failed to update job to 'Running': %s\n", workerId, err)
+				logger.Error("Worker failed to update job to 'Running'", "job_id", job.ID, "error", err)
 				continue
 			}
 		}
 
+		logger = logger.With("job_id", job.ID)
+
 		// Execute the command
-		err := executeCommand(command)
-		if err != nil {
-			log.Printf("Worker %d job failed: %s\n", workerId, err)
+		execErr := executeCommand(logger, command)
+		if execErr != nil {
+			logger.Warn("Worker job failed", "error", execErr)
 
 			// Retry if allowed
 			if job.RetryCount < maxRetries-1 {
@@ -80,43 +82,43 @@ func worker(ctx context.Context, store *db.Store, jobChannel chan string, worker
 				job.Status = "Retrying"
 				job.ExecutionTime = time.Now().UTC()
 				if err := store.DB.Save(&job).Error; err != nil {
-					log.Printf("Worker %d failed to update retry count for job %d: %s\n", workerId, job.ID, err)
+					logger.Error("Worker failed to update retry count for job", "error", err)
 					continue
 				}
 
 				// Requeue job after a delay
 				go func(cmd string) {
-					log.Printf("Worker %d retrying job %d in 5 seconds ... (Retry %d/%d)\n\n", workerId, job.ID, job.RetryCount, maxRetries)
+					logger.Info("Worker retrying job in 5 seconds", "retry", job.RetryCount, "max_retries", maxRetries)
 					time.Sleep(5 * time.Second)
 					if retryErr := store.Rdb.RPush(ctx, "job_queue", cmd).Err(); retryErr != nil {
-						log.Printf("Worker %d failed to requeue job %s: %s\n", workerId, cmd, retryErr)
+						logger.Error("Worker failed to requeue job", "command", cmd, "error", retryErr)
 					}
 				}(command)
 			} else {
 				// Mark job as failed if max retries reached
 				job.Status = "Failed"
 				if err := store.DB.Save(&job).Error; err != nil {
-					log.Printf("Worker %d failed to mark job %d as failed: %s\n\n", workerId, job.ID, err)
+					logger.Error("Worker failed to mark job as failed", "error", err)
 				} else {
-					log.Printf("Worker %d reached max (%d/%d) retries for job %d: %s. Marking as failed.\n\n", workerId, job.RetryCount, maxRetries, job.ID, command)
+					logger.Warn("Worker reached max retries for job, marking as failed", "retry", job.RetryCount, "max_retries", maxRetries, "command", command)
 				}
 			}
 		} else {
 			// Mark job as completed
 			job.Status = "Completed"
 			if err := store.DB.Save(&job).Error; err != nil {
-				log.Printf("Worker %d failed to mark job %d as completed: %s\n\n", workerId, job.ID, err)
+				logger.Error("Worker failed to mark job as completed", "error", err)
 			} else {
-				log.Printf("Worker %d successfully completed job %d: %s\n\n", workerId, job.ID, command)
+				logger.Info("Worker successfully completed job", "command", command)
 			}
 		}
 	}
 }
 
 // executeCommand runs a shell command and returns the result
-func executeCommand(command string) error {
+func executeCommand(logger *slog.Logger, command string) error {
 	cmd := exec.Command("sh", "-c", command)
 	output, err := cmd.CombinedOutput()
-	log.Printf("Command output: \n%s", string(output))
+	logger.Debug("Command output", "output", string(output))
 	return err
 }
@@ -0,0 +1,324 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/metrics"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	workerRegistryKey = "workers"          // Set of all registered worker keys across nodes
+	deadLetterKey     = "task_dead_letter" // Subtasks that exhausted maxRetries
+
+	heartbeatInterval = 5 * time.Second
+	heartbeatTTL      = 15 * time.Second // A worker is considered dead once its heartbeat key expires
+	reapInterval      = 10 * time.Second
+)
+
+// nodeId identifies this process so worker IDs stay unique across nodes
+var nodeId = func() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}()
+
+// NodeId returns the identifier of this process, shared by every worker it runs
+func NodeId() string {
+	return nodeId
+}
+
+// workerKey returns the registry-wide identifier for a local worker
+func workerKey(workerId int32) string {
+	return fmt.Sprintf("%s:%d", nodeId, workerId)
+}
+
+func workerInfoKey(key string) string {
+	return fmt.Sprintf("worker:%s", key)
+}
+
+func workerHeartbeatKey(key string) string {
+	return fmt.Sprintf("worker_heartbeat:%s", key)
+}
+
+// workerLeaseKey is the list holding the raw payloads of tasks a worker has taken off a queue but not yet finished
+func workerLeaseKey(key string) string {
+	return fmt.Sprintf("worker_lease:%s", key)
+}
+
+// registerWorker adds a worker to the registry and records its first heartbeat
+func registerWorker(s *db.Store, workerId int32) error {
+	key := workerKey(workerId)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	pipe := s.Rdb.TxPipeline()
+	pipe.SAdd(ctx, workerRegistryKey, key)
+	pipe.HSet(ctx, workerInfoKey(key), map[string]interface{}{
+		"node":         nodeId,
+		"started_at":   now,
+		"current_task": "",
+	})
+	pipe.Set(ctx, workerHeartbeatKey(key), now, heartbeatTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// deregisterWorker removes a worker that is shutting down cleanly. Any leftover leases are returned to their queues.
+func deregisterWorker(s *db.Store, workerId int32) {
+	key := workerKey(workerId)
+	if err := recoverLeases(s, key, false); err != nil {
+		s.Log.Error("Worker failed to return leased tasks on shutdown", "worker_id", key, "error", err)
+	}
+	s.Rdb.Del(ctx, workerInfoKey(key), workerHeartbeatKey(key), workerLeaseKey(key))
+	s.Rdb.SRem(ctx, workerRegistryKey, key)
+}
+
+// heartbeat refreshes a worker's heartbeat key until stop is closed
+func heartbeat(s *db.Store, workerId int32, stop <-chan struct{}) {
+	key := workerKey(workerId)
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.Rdb.Set(ctx, workerHeartbeatKey(key), time.Now().UTC().Format(time.RFC3339Nano), heartbeatTTL).Err(); err != nil {
+				s.Log.Warn("Worker failed to send heartbeat", "worker_id", key, "error", err)
+			}
+		}
+	}
+}
+
+// leaseTask atomically moves the next task in a queue into the worker's lease list, so it survives a worker crash
+func leaseTask(s *db.Store, workerId int32, taskQueue string) (string, error) {
+	return s.Rdb.LMove(ctx, taskQueue, workerLeaseKey(workerKey(workerId)), "LEFT", "RIGHT").Result()
+}
+
+// releaseTask drops a finished task payload from the worker's lease list
+func releaseTask(s *db.Store, workerId int32, payload string) error {
+	return s.Rdb.LRem(ctx, workerLeaseKey(workerKey(workerId)), 1, payload).Err()
+}
+
+// requeueScript moves one leased payload back to the tail of its queue in a single step, so a crash in between can
+// neither lose nor duplicate it. Nothing is queued if the lease is gone, e.g. because the reaper already recovered it.
+// KEYS[1] = lease list, KEYS[2] = task queue, ARGV[1] = payload
+var requeueScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call('RPUSH', KEYS[2], ARGV[1])
+return 1
+`)
+
+// requeueTask returns a leased task payload to the back of its queue
+func requeueTask(s *db.Store, workerId int32, taskQueue string, payload string) error {
+	return requeueScript.Run(ctx, s.Rdb, []string{workerLeaseKey(workerKey(workerId)), taskQueue}, payload).Err()
+}
+
+// setCurrentTask records which subtask a worker is executing. An empty taskRef marks the worker idle.
+func setCurrentTask(s *db.Store, workerId int32, taskRef string) {
+	s.Rdb.HSet(ctx, workerInfoKey(workerKey(workerId)), "current_task", taskRef)
+}
+
+// recoverLeases requeues every task leased by a worker. When countAttempt is set the task's attempt count is incremented,
+// and tasks that have exhausted maxRetries are marked failed instead of being requeued.
+func recoverLeases(s *db.Store, key string, countAttempt bool) error {
+	leaseKey := workerLeaseKey(key)
+	for {
+		payload, err := s.Rdb.LPop(ctx, leaseKey).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var task models.Task
+		if err := json.Unmarshal([]byte(payload), &task); err != nil {
+			s.Log.Error("Dropped unparseable lease", "worker_id", key, "error", err)
+			continue
+		}
+
+		if !countAttempt {
+			// Clean handoff, e.g. on shutdown: return the payload untouched
+			queueKey := TaskQueueKey(task.Tenant, task.TaskId)
+			if err := s.Rdb.RPush(ctx, queueKey, payload).Err(); err != nil {
+				s.Rdb.LPush(ctx, leaseKey, payload)
+				return err
+			}
+			s.Log.Info("Returned subtask to the queue", "worker_id", key, "task_id", task.TaskId, "subtask_id", task.SubtaskId)
+			metrics.SubtaskRequeues.WithLabelValues("shutdown").Inc()
+			continue
+		}
+
+		task.Attempts++
+		if task.Attempts >= maxRetries {
+			s.Log.Warn("Reaper: subtask reached max attempts, moving to dead-letter queue",
+				"worker_id", key, "task_id", task.TaskId, "subtask_id", task.SubtaskId, "attempts", task.Attempts, "max_attempts", maxRetries)
+			s.DB.Model(&models.Task{}).
+				Where("task_id = ? AND subtask_id = ?", task.TaskId, task.SubtaskId).
+				Updates(map[string]interface{}{"attempts": task.Attempts, "status": "failed"})
+			s.Rdb.Set(ctx, SubtaskStatusKey(task.TaskId, task.SubtaskId), "failed", 0)
+			if taskJSON, err := json.Marshal(task); err == nil {
+				s.Rdb.RPush(ctx, deadLetterKey, taskJSON)
+			}
+			metrics.SubtasksDeadLettered.Inc()
+			PublishTaskEvent(s, models.TaskEvent{TaskId: task.TaskId, SubtaskId: task.SubtaskId, Event: "subtask_dead_lettered",
+				Detail: fmt.Sprintf("gave up after %d attempts", task.Attempts)})
+			checkTaskDone(s, task.TaskId)
+			continue
+		}
+
+		taskJSON, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+
+		queueKey := TaskQueueKey(task.Tenant, task.TaskId)
+		if err := s.Rdb.RPush(ctx, queueKey, taskJSON).Err(); err != nil {
+			// Put the lease back so the next reap can try again
+			s.Rdb.LPush(ctx, leaseKey, payload)
+			return err
+		}
+
+		// The dead worker left the row running; the next worker only starts pending subtasks
+		stopSubtask(s, task)
+		s.DB.Model(&models.Task{}).
+			Where("task_id = ? AND subtask_id = ?", task.TaskId, task.SubtaskId).
+			Update("attempts", task.Attempts)
+
+		s.Log.Info("Reaper requeued subtask", "worker_id", key, "task_id", task.TaskId, "subtask_id", task.SubtaskId, "attempts", task.Attempts)
+		metrics.SubtaskRequeues.WithLabelValues("recovered").Inc()
+		metrics.SubtaskRetries.Inc()
+	}
+}
+
+// reapDeadWorkers removes workers whose heartbeat has expired and requeues their leased tasks
+func reapDeadWorkers(s *db.Store) {
+	keys, err := s.Rdb.SMembers(ctx, workerRegistryKey).Result()
+	if err != nil {
+		s.Log.Error("Reaper failed to list workers", "error", err)
+		return
+	}
+
+	dead := map[string]bool{}
+	for _, key := range keys {
+		alive, err := s.Rdb.Exists(ctx, workerHeartbeatKey(key)).Result()
+		if err != nil || alive > 0 {
+			continue
+		}
+
+		s.Log.Warn("Reaper: worker missed its heartbeat, recovering leased tasks", "worker_id", key)
+		if err := recoverLeases(s, key, true); err != nil {
+			s.Log.Error("Reaper failed to recover leases", "worker_id", key, "error", err)
+			continue
+		}
+
+		s.Rdb.Del(ctx, workerInfoKey(key), workerLeaseKey(key))
+		s.Rdb.SRem(ctx, workerRegistryKey, key)
+		dead[workerNode(key)] = true
+	}
+
+	for node := range dead {
+		if nodeAlive(s, node) {
+			continue
+		}
+		if err := recoverTaskEvents(s, node); err != nil {
+			s.Log.Error("Reaper failed to return task events", "node", node, "error", err)
+		}
+	}
+}
+
+// workerNode returns the node part of a worker key
+func workerNode(key string) string {
+	return key[:strings.LastIndex(key, ":")]
+}
+
+// nodeAlive reports whether any registered worker on node still has a heartbeat
+func nodeAlive(s *db.Store, node string) bool {
+	keys, err := s.Rdb.SMembers(ctx, workerRegistryKey).Result()
+	if err != nil {
+		return true
+	}
+	for _, key := range keys {
+		if workerNode(key) != node {
+			continue
+		}
+		if alive, err := s.Rdb.Exists(ctx, workerHeartbeatKey(key)).Result(); err != nil || alive > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// recoverTaskEvents returns events a dead node was turning into webhook deliveries to the front of TaskEventQueue
+func recoverTaskEvents(s *db.Store, node string) error {
+	processing := TaskEventProcessingKey(node)
+	for {
+		err := s.Rdb.LMove(ctx, processing, TaskEventQueue, "RIGHT", "LEFT").Err()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.Log.Warn("Reaper returned task event to the queue", "node", node)
+	}
+}
+
+// Reaper periodically detects expired workers on any node and recovers their in-flight tasks
+func Reaper(s *db.Store) {
+	s.Log.Info("Starting Reaper", "interval", reapInterval)
+
+	for {
+		reapDeadWorkers(s)
+		time.Sleep(reapInterval)
+	}
+}
+
+// ListWorkers returns every live worker in the registry along with its current assignment
+func ListWorkers(s *db.Store) ([]models.WorkerInfo, error) {
+	keys, err := s.Rdb.SMembers(ctx, workerRegistryKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	workers := []models.WorkerInfo{}
+	for _, key := range keys {
+		lastHeartbeat, err := s.Rdb.Get(ctx, workerHeartbeatKey(key)).Result()
+		if err != nil {
+			// Heartbeat expired, the reaper will clean this worker up
+			continue
+		}
+
+		info, err := s.Rdb.HGetAll(ctx, workerInfoKey(key)).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		leases, _ := s.Rdb.LLen(ctx, workerLeaseKey(key)).Result()
+		startedAt, _ := time.Parse(time.RFC3339Nano, info["started_at"])
+		heartbeatAt, _ := time.Parse(time.RFC3339Nano, lastHeartbeat)
+
+		workers = append(workers, models.WorkerInfo{
+			WorkerId:      key,
+			Node:          info["node"],
+			StartedAt:     startedAt,
+			LastHeartbeat: heartbeatAt,
+			CurrentTask:   info["current_task"],
+			Leases:        leases,
+		})
+	}
+
+	return workers, nil
+}
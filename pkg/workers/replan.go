@@ -0,0 +1,308 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/ai"
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/env"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workflow"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrReplanInProgress = errors.New("a replan of this task is already in progress")
+	ErrPlanNotApproved  = errors.New("the task's plan has not been approved")
+)
+
+// replanLockTTL bounds how long a crashed replan can block the next one
+const replanLockTTL = 5 * time.Minute
+
+// replaceableStatuses are the statuses of subtasks a replan supersedes because they have not started
+var replaceableStatuses = []string{"pending", "skipped"}
+
+// failureStatuses are the statuses a replan takes over responsibility for
+var failureStatuses = []string{"failed", "budget_exceeded", "rejected"}
+
+func replanLockKey(taskId uuid.UUID) string {
+	return fmt.Sprintf("replan_lock:%s", taskId)
+}
+
+// RecordInitialPlan stores version 1 of a task's plan: the graph as it is first queued
+func RecordInitialPlan(tx *gorm.DB, taskId uuid.UUID, description string, tasks []models.Task) error {
+	subtasks := make([]models.TaskResponse, 0, len(tasks))
+	added := make([]int, 0, len(tasks))
+	for _, task := range tasks {
+		subtasks = append(subtasks, task.Response())
+		added = append(added, task.SubtaskId)
+	}
+	return tx.Create(&models.PlanVersion{
+		TaskId:      taskId,
+		Version:     1,
+		Trigger:     models.PlanVersionInitial,
+		Description: description,
+		Added:       added,
+		Subtasks:    subtasks,
+	}).Error
+}
+
+// Replan asks the LLM to revise the subtasks of a task that have not started, given the task's description and the
+// state and results of its graph so far. Pending and skipped top-level subtasks are replaced by the LLM's proposal,
+// failures are marked as taken over by the new version, and the revision is stored as the task's next plan version.
+func Replan(ctx context.Context, s *db.Store, taskId uuid.UUID, trigger string, reason string, createdBy string) (*models.PlanVersion, error) {
+	if first, err := s.Rdb.SetNX(ctx, replanLockKey(taskId), time.Now().UTC().Format(time.RFC3339Nano), replanLockTTL).Result(); err != nil {
+		return nil, err
+	} else if !first {
+		return nil, ErrReplanInProgress
+	}
+	defer s.Rdb.Del(ctx, replanLockKey(taskId))
+
+	var plan models.Plan
+	if err := s.DB.Where("task_id = ?", taskId).First(&plan).Error; err == nil && plan.Status != models.PlanApproved {
+		return nil, ErrPlanNotApproved
+	}
+
+	var subtasks []models.Task
+	if err := s.DB.Where("task_id = ?", taskId).Order("subtask_id").Find(&subtasks).Error; err != nil {
+		return nil, err
+	}
+	if len(subtasks) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	first := subtasks[0]
+
+	var latest models.PlanVersion
+	description := ""
+	if err := s.DB.Where("task_id = ?", taskId).Order("version DESC").First(&latest).Error; err == nil {
+		description = latest.Description
+	}
+	if description == "" {
+		// Tasks queued before plan versions were recorded: describe the goal by its subtasks
+		descriptions := make([]string, 0, len(subtasks))
+		for _, subtask := range subtasks {
+			descriptions = append(descriptions, subtask.Description)
+		}
+		description = strings.Join(descriptions, "; ")
+	}
+
+	graph := make([]ai.GraphNode, 0, len(subtasks))
+	kept := map[int]bool{}
+	nextId := 1
+	for _, subtask := range subtasks {
+		nextId = max(nextId, subtask.SubtaskId+1)
+		if subtask.Status == "replaced" {
+			continue
+		}
+		node := ai.GraphNode{SubtaskId: subtask.SubtaskId, Type: subtask.Type, Description: subtask.Description, Status: subtask.Status}
+		for _, dep := range subtask.Dependencies {
+			node.Dependencies = append(node.Dependencies, dep.SubtaskId)
+		}
+		if subtask.Status == "completed" {
+			node.Result, _ = s.Rdb.Get(ctx, fmt.Sprintf("task_result:%s:%d", taskId, subtask.SubtaskId)).Result()
+		}
+		graph = append(graph, node)
+		if !replaceable(subtask) {
+			kept[subtask.SubtaskId] = true
+		}
+	}
+
+	allowComposite := ai.MaxDecompositionDepth() > 0
+	queryCtx, usage := ai.WithUsageCollector(ctx)
+	revision, err := ai.ReplanQuery(queryCtx, description, reason, graph, nextId, allowComposite)
+	if err := s.SaveLLMUsage(ai.UsageRecords(usage, taskId, 0, first.ClientId, "replan")); err != nil {
+		s.Log.Error("Failed to record replan LLM usage", "task_id", taskId, "error", err)
+	}
+	ChargeBudget(s, taskId, usage.TotalTokens(), usage.TotalCost())
+	ChargeTenant(s, first.Tenant, usage.TotalTokens())
+	if err != nil {
+		return nil, fmt.Errorf("replanning: %w", err)
+	}
+
+	added, err := revisedTasks(first, revision.Subtasks, kept, nextId, allowComposite)
+	if err != nil {
+		return nil, err
+	}
+
+	version := &models.PlanVersion{
+		TaskId:      taskId,
+		Trigger:     trigger,
+		Description: description,
+		Reason:      reason,
+		Rationale:   revision.Rationale,
+		CreatedBy:   createdBy,
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		// Re-read under lock: subtasks that started while the LLM was thinking are no longer replaced, and workers
+		// starting a subtask wait for this transaction, as they only start rows that are still pending
+		var locked []models.Task
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("task_id = ?", taskId).Find(&locked).Error; err != nil {
+			return err
+		}
+		var last int
+		if err := tx.Model(&models.PlanVersion{}).Where("task_id = ?", taskId).Select("COALESCE(MAX(version), 0)").
+			Scan(&last).Error; err != nil {
+			return err
+		}
+		version.Version = last + 1
+
+		var handled []int
+		live := len(added)
+		for _, subtask := range locked {
+			switch {
+			case replaceable(subtask):
+				version.Replaced = append(version.Replaced, subtask.SubtaskId)
+			case subtask.Status == "replaced":
+			default:
+				live++
+				if slices.Contains(failureStatuses, subtask.Status) && subtask.SupersededBy == 0 {
+					handled = append(handled, subtask.SubtaskId)
+				}
+			}
+		}
+		// A revision cannot grow the task past its max_subtasks, so repeated replans cannot run away
+		if err := checkSubtaskBudget(tx, taskId, live); err != nil {
+			return err
+		}
+		if len(version.Replaced) > 0 {
+			if err := tx.Model(&models.Task{}).Where("task_id = ? AND subtask_id IN ?", taskId, version.Replaced).
+				Updates(map[string]interface{}{"status": "replaced", "superseded_by": version.Version}).Error; err != nil {
+				return err
+			}
+		}
+		if len(handled) > 0 {
+			if err := tx.Model(&models.Task{}).Where("task_id = ? AND subtask_id IN ?", taskId, handled).
+				Update("superseded_by", version.Version).Error; err != nil {
+				return err
+			}
+		}
+		if len(added) > 0 {
+			if err := tx.Create(&added).Error; err != nil {
+				return err
+			}
+		}
+
+		var graph []models.Task
+		if err := tx.Where("task_id = ? AND status <> ?", taskId, "replaced").Order("subtask_id").Find(&graph).Error; err != nil {
+			return err
+		}
+		for _, task := range added {
+			version.Added = append(version.Added, task.SubtaskId)
+		}
+		for _, task := range graph {
+			version.Subtasks = append(version.Subtasks, task.Response())
+		}
+		return tx.Create(version).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Workers drop queued payloads of replaced subtasks when they see this status
+	for _, subtaskId := range version.Replaced {
+		s.Rdb.Set(ctx, SubtaskStatusKey(taskId, subtaskId), "replaced", 0)
+	}
+	if err := queueTasks(s, added); err != nil {
+		return nil, err
+	}
+	// The task may already have been announced as finished; it is running again
+	s.Rdb.Del(ctx, taskDoneKey(taskId))
+
+	PublishTaskEvent(s, models.TaskEvent{TaskId: taskId, Event: "task_replanned",
+		Detail: fmt.Sprintf("version %d: %d subtasks replaced, %d added", version.Version, len(version.Replaced), len(version.Added))})
+	checkTaskDone(s, taskId)
+	return version, nil
+}
+
+// replaceable reports whether a replan supersedes a subtask. Children of map and composite subtasks belong to their
+// parent and are left alone.
+func replaceable(task models.Task) bool {
+	return task.ParentId == 0 && slices.Contains(replaceableStatuses, task.Status)
+}
+
+// revisedTasks turns the LLM's proposed subtasks into rows numbered from nextId. Dependencies may refer to each other
+// or to kept subtasks.
+func revisedTasks(template models.Task, proposed []models.Subtask, kept map[int]bool, nextId int, allowComposite bool) ([]models.Task, error) {
+	if len(proposed) > workflow.MaxSteps {
+		return nil, fmt.Errorf("replan proposed %d subtasks, at most %d are allowed", len(proposed), workflow.MaxSteps)
+	}
+	allowed := []string{workflow.TypeCommand, workflow.TypeCode}
+	if allowComposite {
+		allowed = append(allowed, workflow.TypeComposite)
+	}
+
+	ids := make(map[int]int, len(proposed))
+	for i, subtask := range proposed {
+		if _, dup := ids[subtask.SubtaskId]; dup {
+			return nil, fmt.Errorf("replan proposed subtask %d twice", subtask.SubtaskId)
+		}
+		ids[subtask.SubtaskId] = nextId + i
+	}
+
+	tasks := make([]models.Task, 0, len(proposed))
+	graph := make([]models.Task, 0, len(proposed)+len(kept))
+	for id := range kept {
+		graph = append(graph, models.Task{TaskId: template.TaskId, SubtaskId: id})
+	}
+	for i, subtask := range proposed {
+		if !slices.Contains(allowed, subtask.Type) {
+			return nil, fmt.Errorf("replan proposed subtask %d of type %q", subtask.SubtaskId, subtask.Type)
+		}
+		task := models.Task{
+			TaskId:       template.TaskId,
+			SubtaskId:    nextId + i,
+			Type:         subtask.Type,
+			Description:  subtask.Description,
+			Status:       "pending",
+			ClientId:     template.ClientId,
+			TraceContext: template.TraceContext,
+			Tags:         template.Tags,
+			Tenant:       template.Tenant,
+		}
+		for _, dep := range subtask.Dependencies {
+			depId, isNew := ids[dep]
+			if !isNew {
+				if !kept[dep] {
+					return nil, fmt.Errorf("replan proposed subtask %d depending on unknown or replaced subtask %d", subtask.SubtaskId, dep)
+				}
+				depId = dep
+			}
+			task.Dependencies = append(task.Dependencies, models.Dependency{TaskId: template.TaskId, SubtaskId: depId})
+		}
+		tasks = append(tasks, task)
+		graph = append(graph, task)
+	}
+	if err := workflow.ValidateGraph(graph); err != nil {
+		return nil, fmt.Errorf("replan proposed an invalid graph: %v", err)
+	}
+	return tasks, nil
+}
+
+// replanAfterFailure revises a task's remaining subtasks after one of them failed, while REPLAN_ON_FAILURE allows
+// more automatic replans of the task
+func replanAfterFailure(s *db.Store, task models.Task, failure string) {
+	limit := env.Int64("REPLAN_ON_FAILURE", 0)
+	if limit <= 0 || task.ParentId != 0 {
+		return
+	}
+	var count int64
+	if err := s.DB.Model(&models.PlanVersion{}).Where("task_id = ? AND trigger = ?", task.TaskId, models.PlanVersionFailure).
+		Count(&count).Error; err != nil || count >= limit {
+		return
+	}
+
+	version, err := Replan(execCtx, s, task.TaskId, models.PlanVersionFailure, fmt.Sprintf("subtask %d failed: %s", task.SubtaskId, failure), "")
+	if err != nil {
+		s.Log.Error("Automatic replan failed", "task_id", task.TaskId, "subtask_id", task.SubtaskId, "error", err)
+		return
+	}
+	s.Log.Info("Task replanned after failure", "task_id", task.TaskId, "subtask_id", task.SubtaskId,
+		"version", version.Version, "replaced", len(version.Replaced), "added", len(version.Added))
+}
@@ -0,0 +1,128 @@
+package workers
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workflow"
+	"github.com/google/uuid"
+)
+
+func TestRevisedTasks(t *testing.T) {
+	template := models.Task{TaskId: uuid.New(), ClientId: "client", Tenant: "acme", Tags: []string{"nightly"}}
+	step := func(id int, deps ...int) models.Subtask {
+		return models.Subtask{SubtaskId: id, Description: "step", Type: workflow.TypeCommand, Dependencies: deps}
+	}
+	many := make([]models.Subtask, workflow.MaxSteps+1)
+	for i := range many {
+		many[i] = step(i + 1)
+	}
+
+	tests := []struct {
+		name           string
+		proposed       []models.Subtask
+		kept           map[int]bool
+		allowComposite bool
+		wantDeps       map[int][]int // New subtask ID -> dependency IDs
+		wantErr        string
+	}{
+		{
+			name:     "renumbers from nextId",
+			proposed: []models.Subtask{step(1), step(2, 1)},
+			wantDeps: map[int][]int{10: nil, 11: {10}},
+		},
+		{
+			name:     "depends on kept subtask",
+			proposed: []models.Subtask{step(1, 3), step(2, 1, 4)},
+			kept:     map[int]bool{3: true, 4: true},
+			wantDeps: map[int][]int{10: {3}, 11: {10, 4}},
+		},
+		{
+			name:     "proposed ID shadows kept ID",
+			proposed: []models.Subtask{step(3), step(4, 3)},
+			kept:     map[int]bool{3: true},
+			wantDeps: map[int][]int{10: nil, 11: {10}},
+		},
+		{
+			name:     "duplicate proposed ID",
+			proposed: []models.Subtask{step(1), step(1)},
+			wantErr:  "proposed subtask 1 twice",
+		},
+		{
+			name:     "depends on replaced subtask",
+			proposed: []models.Subtask{step(1, 5)},
+			kept:     map[int]bool{3: true},
+			wantErr:  "unknown or replaced subtask 5",
+		},
+		{
+			name:     "depends on unknown subtask",
+			proposed: []models.Subtask{step(1, 99)},
+			wantErr:  "unknown or replaced subtask 99",
+		},
+		{
+			name:     "composite not allowed",
+			proposed: []models.Subtask{{SubtaskId: 1, Type: workflow.TypeComposite}},
+			wantErr:  `of type "composite"`,
+		},
+		{
+			name:           "composite allowed",
+			proposed:       []models.Subtask{{SubtaskId: 1, Type: workflow.TypeComposite}},
+			allowComposite: true,
+			wantDeps:       map[int][]int{10: nil},
+		},
+		{
+			name:     "unknown type",
+			proposed: []models.Subtask{{SubtaskId: 1, Type: "deploy"}},
+			wantErr:  `of type "deploy"`,
+		},
+		{
+			name:     "cycle",
+			proposed: []models.Subtask{step(1, 2), step(2, 1)},
+			wantErr:  "invalid graph",
+		},
+		{
+			name:     "more than MaxSteps",
+			proposed: many,
+			wantErr:  "at most 500 are allowed",
+		},
+		{
+			name:     "exactly MaxSteps",
+			proposed: many[:workflow.MaxSteps],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, err := revisedTasks(template, tt.proposed, tt.kept, 10, tt.allowComposite)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("revisedTasks() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("revisedTasks() error = %v", err)
+			}
+			if len(tasks) != len(tt.proposed) {
+				t.Fatalf("revisedTasks() returned %d tasks, want %d", len(tasks), len(tt.proposed))
+			}
+			for i, task := range tasks {
+				if task.SubtaskId != 10+i || task.Status != "pending" || task.TaskId != template.TaskId ||
+					task.Tenant != template.Tenant || task.ClientId != template.ClientId {
+					t.Errorf("task %d = %+v, want pending subtask %d of the template's task", i, task, 10+i)
+				}
+				if tt.wantDeps == nil {
+					continue
+				}
+				var deps []int
+				for _, dep := range task.Dependencies {
+					deps = append(deps, dep.SubtaskId)
+				}
+				if want := tt.wantDeps[task.SubtaskId]; !slices.Equal(deps, want) {
+					t.Errorf("subtask %d depends on %v, want %v", task.SubtaskId, deps, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package workers
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// draining is set when this node should stop taking new work. Workers finish their current subtask and exit.
+	draining int32 = 0
+
+	// execCtx is passed to every subtask execution. Cancelling it aborts in-flight LLM calls and commands.
+	execCtx, cancelExec = context.WithCancel(context.Background())
+
+	// workerWg tracks running worker goroutines so shutdown can wait for them
+	workerWg sync.WaitGroup
+)
+
+// SetDraining puts this node into (or takes it out of) drain mode
+func SetDraining(drain bool) {
+	if drain {
+		if atomic.SwapInt32(&draining, 1) == 0 {
+			slog.Info("Node entering drain mode: no new subtasks will be picked up")
+		}
+		return
+	}
+	if atomic.SwapInt32(&draining, 0) == 1 {
+		slog.Info("Node leaving drain mode")
+	}
+}
+
+// IsDraining reports whether this node is in drain mode
+func IsDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// ActiveWorkers returns the number of workers running on this node
+func ActiveWorkers() int32 {
+	return atomic.LoadInt32(&activeWorkers)
+}
+
+// waitForWorkers blocks until every worker has exited or timeout elapses. It returns true if all workers exited.
+func waitForWorkers(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		workerWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Shutdown drains this node and waits up to timeout for workers to finish their current subtasks.
+// Workers still running after the deadline have their subtasks aborted and returned to the queue.
+func Shutdown(timeout time.Duration) {
+	SetDraining(true)
+
+	slog.Info("Waiting for workers to finish in-flight subtasks", "timeout", timeout, "active_workers", ActiveWorkers())
+	if waitForWorkers(timeout) {
+		slog.Info("All workers finished")
+		return
+	}
+
+	slog.Warn("Shutdown deadline reached, aborting workers and returning their subtasks to the queue", "active_workers", ActiveWorkers())
+	cancelExec()
+	if !waitForWorkers(5 * time.Second) {
+		slog.Warn("Workers did not exit in time, their leases will be recovered by the reaper", "active_workers", ActiveWorkers())
+	}
+}
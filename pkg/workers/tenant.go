@@ -0,0 +1,315 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workspace"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// tenantCacheTTL bounds how stale a node's view of tenant quotas and storage usage may be
+const tenantCacheTTL = 30 * time.Second
+
+var (
+	tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+	// ErrQuotaExceeded is returned when a submission would take a tenant over one of its quotas
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+)
+
+// cachedTenant is a tenant's quotas and storage usage as last read by this node
+type cachedTenant struct {
+	tenant       models.Tenant
+	storageBytes int64
+	loadedAt     time.Time
+}
+
+var (
+	tenantCacheMu sync.Mutex
+	tenantCache   = map[string]cachedTenant{}
+)
+
+// TenantUsage is a tenant's quotas together with its current consumption
+type TenantUsage struct {
+	models.Tenant
+	RunningSubtasks int64 `json:"running_subtasks"`
+	QueuedSubtasks  int64 `json:"queued_subtasks"`
+	TokensToday     int64 `json:"tokens_today"`
+	StorageBytes    int64 `json:"storage_bytes"`
+}
+
+// ValidateTenantName checks a tenant name is safe to use in Redis keys and directory names
+func ValidateTenantName(name string) error {
+	if !tenantNamePattern.MatchString(name) {
+		return fmt.Errorf("tenant must be 1-63 lowercase letters, digits or dashes, starting with a letter or digit")
+	}
+	return nil
+}
+
+func tenantOrDefault(tenant string) string {
+	if tenant == "" {
+		return models.DefaultTenant
+	}
+	return tenant
+}
+
+// TaskQueueKey is the Redis list holding the pending subtasks of a task
+func TaskQueueKey(tenant string, taskId uuid.UUID) string {
+	return fmt.Sprintf("task_queue:%s:%s", tenantOrDefault(tenant), taskId)
+}
+
+// tenantFromQueue extracts the tenant from a queue key. Queues created before tenants existed belong to the default tenant.
+func tenantFromQueue(queueKey string) string {
+	parts := strings.Split(queueKey, ":")
+	if len(parts) == 3 {
+		return parts[1]
+	}
+	return models.DefaultTenant
+}
+
+func tenantTokensKey(tenant string, day time.Time) string {
+	return fmt.Sprintf("tenant_tokens:%s:%s", tenantOrDefault(tenant), day.UTC().Format("2006-01-02"))
+}
+
+// tenantPermit is the semaphore counting a tenant's running subtasks. Tenants without a limit still take
+// a permit so the scheduler can see how much capacity each tenant is using.
+func tenantPermit(t models.Tenant) permit {
+	limit := t.MaxConcurrentSubtasks
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+	return permit{name: "tenant:" + t.Name, limit: limit}
+}
+
+// getTenant returns a tenant's quotas and storage usage, read through a short-lived cache.
+// Tenants that were never configured get no limits and a weight of 1.
+func getTenant(s *db.Store, name string) cachedTenant {
+	name = tenantOrDefault(name)
+
+	tenantCacheMu.Lock()
+	cached, ok := tenantCache[name]
+	tenantCacheMu.Unlock()
+	if ok && time.Since(cached.loadedAt) < tenantCacheTTL {
+		return cached
+	}
+
+	cached = cachedTenant{tenant: models.Tenant{Name: name, Weight: 1}, loadedAt: time.Now()}
+	var tenant models.Tenant
+	if err := s.DB.Where("name = ?", name).First(&tenant).Error; err == nil {
+		cached.tenant = tenant
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.Log.Error("Failed to load tenant", "tenant", name, "error", err)
+	}
+	if cached.tenant.MaxStorageBytes > 0 {
+		// Walking the workspace is only worth it when there is a quota to enforce
+		if usage, err := workspace.Usage(name); err == nil {
+			cached.storageBytes = usage
+		}
+	}
+
+	tenantCacheMu.Lock()
+	tenantCache[name] = cached
+	tenantCacheMu.Unlock()
+	return cached
+}
+
+// forgetTenant drops a tenant from this node's cache so quota changes apply immediately
+func forgetTenant(name string) {
+	tenantCacheMu.Lock()
+	delete(tenantCache, name)
+	tenantCacheMu.Unlock()
+}
+
+// ChargeTenant adds LLM tokens to a tenant's daily counter
+func ChargeTenant(s *db.Store, tenant string, tokens int64) {
+	if tokens <= 0 {
+		return
+	}
+	key := tenantTokensKey(tenant, time.Now())
+	pipe := s.Rdb.TxPipeline()
+	pipe.IncrBy(ctx, key, tokens)
+	pipe.Expire(ctx, key, 48*time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		s.Log.Error("Failed to charge tenant tokens", "tenant", tenant, "error", err)
+	}
+}
+
+func tenantTokensToday(s *db.Store, tenant string) int64 {
+	tokens, _ := s.Rdb.Get(ctx, tenantTokensKey(tenant, time.Now())).Int64()
+	return tokens
+}
+
+func tenantRunning(s *db.Store, tenant string) int64 {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	running, _ := s.Rdb.ZCount(ctx, semaphoreKey("tenant:"+tenantOrDefault(tenant)), now, "+inf").Result()
+	return running
+}
+
+func tenantQueued(s *db.Store, tenant string) int64 {
+	queues, _ := s.Rdb.Keys(ctx, fmt.Sprintf("task_queue:%s:*", tenantOrDefault(tenant))).Result()
+	total := int64(0)
+	for _, queue := range queues {
+		length, _ := s.Rdb.LLen(ctx, queue).Result()
+		total += length
+	}
+	return total
+}
+
+// tenantThrottled returns why a tenant may not start more subtasks right now, or "" if it may
+func tenantThrottled(s *db.Store, t cachedTenant) string {
+	if t.tenant.MaxTokensPerDay > 0 && tenantTokensToday(s, t.tenant.Name) >= t.tenant.MaxTokensPerDay {
+		return "daily token quota reached"
+	}
+	if t.tenant.MaxStorageBytes > 0 && t.storageBytes >= t.tenant.MaxStorageBytes {
+		return "storage quota reached"
+	}
+	return ""
+}
+
+// CheckSubmissionQuota rejects a submission that would add subtasks beyond a tenant's queue quota,
+// or that arrives after the tenant has used up its daily tokens or storage
+func CheckSubmissionQuota(s *db.Store, tenant string, subtasks int) error {
+	t := getTenant(s, tenant)
+	if reason := tenantThrottled(s, t); reason != "" {
+		return fmt.Errorf("%w: %s", ErrQuotaExceeded, reason)
+	}
+	if t.tenant.MaxQueuedSubtasks > 0 {
+		if queued := tenantQueued(s, t.tenant.Name); queued+int64(subtasks) > t.tenant.MaxQueuedSubtasks {
+			return fmt.Errorf("%w: %d subtasks queued, %d more would exceed the limit of %d",
+				ErrQuotaExceeded, queued, subtasks, t.tenant.MaxQueuedSubtasks)
+		}
+	}
+	return nil
+}
+
+// scheduleQueues orders the task queues so capacity is shared fairly between tenants. Tenants that are at their
+// concurrency limit or out of daily tokens or storage are left out.
+func scheduleQueues(s *db.Store, queues []string) []string {
+	byTenant := map[string][]string{}
+	for _, queue := range queues {
+		tenant := tenantFromQueue(queue)
+		byTenant[tenant] = append(byTenant[tenant], queue)
+	}
+
+	loads := map[string]tenantLoad{}
+	for name := range byTenant {
+		t := getTenant(s, name)
+		running := tenantRunning(s, name)
+		if t.tenant.MaxConcurrentSubtasks > 0 && running >= t.tenant.MaxConcurrentSubtasks {
+			continue
+		}
+		if tenantThrottled(s, t) != "" {
+			continue
+		}
+		loads[name] = tenantLoad{running: running, weight: t.tenant.Weight}
+	}
+	return fairShareOrder(byTenant, loads, rand.Shuffle)
+}
+
+// tenantLoad is how much of the worker capacity a tenant with queued work is using
+type tenantLoad struct {
+	running int64
+	weight  int64 // Values below 1 count as 1
+}
+
+// fairShareOrder ranks the tenants in loads by running subtasks relative to their weight, least served first, and
+// interleaves their queues in that order. Tenants missing from loads are left out. shuffle breaks ties between
+// tenants with equal shares and orders each tenant's own queues, so they take turns being first.
+func fairShareOrder(byTenant map[string][]string, loads map[string]tenantLoad, shuffle func(n int, swap func(i, j int))) []string {
+	type candidate struct {
+		queues []string
+		share  float64
+	}
+	names := make([]string, 0, len(loads))
+	for name := range loads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+
+	candidates := make([]candidate, 0, len(names))
+	for _, name := range names {
+		load := loads[name]
+		weight := load.weight
+		if weight <= 0 {
+			weight = 1
+		}
+		queues := append([]string{}, byTenant[name]...)
+		shuffle(len(queues), func(i, j int) { queues[i], queues[j] = queues[j], queues[i] })
+		candidates = append(candidates, candidate{queues: queues, share: float64(load.running) / float64(weight)})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].share < candidates[j].share })
+
+	var ordered []string
+	for round := 0; ; round++ {
+		added := false
+		for _, c := range candidates {
+			if round < len(c.queues) {
+				ordered = append(ordered, c.queues[round])
+				added = true
+			}
+		}
+		if !added {
+			return ordered
+		}
+	}
+}
+
+// ListTenants returns every configured tenant
+func ListTenants(s *db.Store) ([]models.Tenant, error) {
+	tenants := []models.Tenant{}
+	err := s.DB.Order("name").Find(&tenants).Error
+	return tenants, err
+}
+
+// SaveTenant creates a tenant or replaces its quotas
+func SaveTenant(s *db.Store, tenant models.Tenant) (models.Tenant, error) {
+	var existing models.Tenant
+	err := s.DB.Where("name = ?", tenant.Name).First(&existing).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		err = s.DB.Create(&tenant).Error
+	case err == nil:
+		tenant.Model = existing.Model
+		err = s.DB.Save(&tenant).Error
+	}
+	if err != nil {
+		return tenant, err
+	}
+	forgetTenant(tenant.Name)
+	return tenant, nil
+}
+
+// GetTenantUsage returns a tenant's quotas and live consumption
+func GetTenantUsage(s *db.Store, name string) (*TenantUsage, error) {
+	forgetTenant(tenantOrDefault(name))
+	t := getTenant(s, name)
+	storage := t.storageBytes
+	if t.tenant.MaxStorageBytes <= 0 {
+		usage, err := workspace.Usage(t.tenant.Name)
+		if err != nil {
+			return nil, err
+		}
+		storage = usage
+	}
+
+	return &TenantUsage{
+		Tenant:          t.tenant,
+		RunningSubtasks: tenantRunning(s, t.tenant.Name),
+		QueuedSubtasks:  tenantQueued(s, t.tenant.Name),
+		TokensToday:     tenantTokensToday(s, t.tenant.Name),
+		StorageBytes:    storage,
+	}, nil
+}
@@ -0,0 +1,94 @@
+package workers
+
+import (
+	"slices"
+	"testing"
+)
+
+// noShuffle keeps the order fairShareOrder starts from, so results are deterministic
+func noShuffle(int, func(i, j int)) {}
+
+// reverseShuffle reverses instead of shuffling, standing in for a shuffle that picked a different order
+func reverseShuffle(n int, swap func(i, j int)) {
+	for i := 0; i < n/2; i++ {
+		swap(i, n-1-i)
+	}
+}
+
+func TestFairShareOrder(t *testing.T) {
+	byTenant := map[string][]string{
+		"acme":    {"task_queue:acme:1", "task_queue:acme:2", "task_queue:acme:3"},
+		"globex":  {"task_queue:globex:1"},
+		"initech": {"task_queue:initech:1", "task_queue:initech:2"},
+	}
+
+	tests := []struct {
+		name    string
+		loads   map[string]tenantLoad
+		shuffle func(int, func(i, j int))
+		want    []string
+	}{
+		{
+			name:    "least served tenant first, queues interleaved",
+			loads:   map[string]tenantLoad{"acme": {running: 4, weight: 1}, "globex": {running: 1, weight: 1}, "initech": {running: 2, weight: 1}},
+			shuffle: noShuffle,
+			want: []string{
+				"task_queue:globex:1", "task_queue:initech:1", "task_queue:acme:1",
+				"task_queue:initech:2", "task_queue:acme:2",
+				"task_queue:acme:3",
+			},
+		},
+		{
+			name:    "weight scales the share",
+			loads:   map[string]tenantLoad{"acme": {running: 4, weight: 4}, "initech": {running: 2, weight: 1}},
+			shuffle: noShuffle,
+			want: []string{
+				"task_queue:acme:1", "task_queue:initech:1",
+				"task_queue:acme:2", "task_queue:initech:2",
+				"task_queue:acme:3",
+			},
+		},
+		{
+			name:    "zero weight counts as one",
+			loads:   map[string]tenantLoad{"globex": {running: 3, weight: 0}, "initech": {running: 2, weight: -5}},
+			shuffle: noShuffle,
+			want:    []string{"task_queue:initech:1", "task_queue:globex:1", "task_queue:initech:2"},
+		},
+		{
+			name:    "tenants without a load are left out",
+			loads:   map[string]tenantLoad{"globex": {weight: 1}},
+			shuffle: noShuffle,
+			want:    []string{"task_queue:globex:1"},
+		},
+		{
+			name:    "no eligible tenants",
+			loads:   map[string]tenantLoad{},
+			shuffle: noShuffle,
+			want:    nil,
+		},
+		{
+			name:    "ties follow the shuffle",
+			loads:   map[string]tenantLoad{"globex": {running: 1, weight: 1}, "initech": {running: 1, weight: 1}},
+			shuffle: reverseShuffle,
+			want:    []string{"task_queue:initech:2", "task_queue:globex:1", "task_queue:initech:1"},
+		},
+		{
+			name:    "shuffle does not override share",
+			loads:   map[string]tenantLoad{"globex": {running: 0, weight: 1}, "initech": {running: 1, weight: 1}},
+			shuffle: reverseShuffle,
+			want:    []string{"task_queue:globex:1", "task_queue:initech:2", "task_queue:initech:1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fairShareOrder(byTenant, tt.loads, tt.shuffle)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("fairShareOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := byTenant["acme"][0]; got != "task_queue:acme:1" {
+		t.Errorf("fairShareOrder() reordered the caller's queues, first acme queue = %q", got)
+	}
+}
@@ -3,14 +3,24 @@ package workers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"slices"
+	"sort"
+	"strconv"
 	"sync/atomic"
 	"time"
 
 	"github.com/arnavsurve/promise/pkg/ai"
 	"github.com/arnavsurve/promise/pkg/db"
+	"github.com/arnavsurve/promise/pkg/logging"
+	"github.com/arnavsurve/promise/pkg/metrics"
 	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/tracing"
+	"github.com/arnavsurve/promise/pkg/workflow"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -31,26 +41,68 @@ func min(a, b int32) int32 {
 	return b
 }
 
-// checkDependencies checks if all dependencies for a task have been completed.
-// It returns a map where keys are in the format 'taskid:subtaskid' and values are the dependency result (context for the next worker).
-// If any dependency is not yet complete, ok is false.
-func checkDependencies(s *db.Store, task models.Task) (depsContext map[string]string, ok bool) {
-	depsContext = make(map[string]string)
+// checkDependencies checks if all dependencies for a task have finished and whether the task should still run.
+// It returns the inputs the completed dependencies handed over, ordered by subtask ID: their plain-text context and,
+// for dependencies that declare an output schema, their structured output. If any dependency is not yet finished,
+// ready is false. If an edge condition or the task's when-clause rules the task out, skip explains why.
+func checkDependencies(s *db.Store, task models.Task) (inputs []ai.Input, ready bool, skip string) {
+	inputs = make([]ai.Input, 0, len(task.Dependencies))
+	outcomes := make(map[string]workflow.Outcome, 2*len(task.Dependencies))
 	for _, dep := range task.Dependencies {
 		// Construct a key for the dependency result
 		key := fmt.Sprintf("task_result:%s:%d", task.TaskId, dep.SubtaskId)
+		outcome := workflow.Outcome{Status: "completed"}
 		result, err := s.Rdb.Get(ctx, key).Result()
+		if err == nil {
+			outcome.Output = result
+			input := ai.Input{TaskId: dep.TaskId, SubtaskId: dep.SubtaskId, Name: dep.Name, Context: result}
+			if structured, err := s.Rdb.Get(ctx, structuredOutputKey(task.TaskId, dep.SubtaskId)).Result(); err == nil {
+				input.Output = json.RawMessage(structured)
+			}
+			inputs = append(inputs, input)
+		} else {
+			// No result: the dependency is still to run, or finished without completing
+			status, _ := s.Rdb.Get(ctx, SubtaskStatusKey(task.TaskId, dep.SubtaskId)).Result()
+			if status == "completed" || !slices.Contains(terminalStatuses, status) {
+				return nil, false, ""
+			}
+			outcome.Status = status
+		}
+
+		satisfied, err := workflow.EdgeSatisfied(dep.Condition, dep.Match, outcome)
 		if err != nil {
-			// Dependency result not yet available
-			return nil, false
+			return nil, true, fmt.Sprintf("dependency %d: %v", dep.SubtaskId, err)
+		}
+		if !satisfied {
+			condition := dep.Condition
+			if condition == "" {
+				condition = workflow.ConditionSuccess
+			}
+			return nil, true, fmt.Sprintf("dependency %d is %s, edge requires %s", dep.SubtaskId, outcome.Status, condition)
 		}
 
-		// Save dependency result in the map
-		depKey := fmt.Sprintf("%s:%d", dep.TaskId, dep.SubtaskId)
-		depsContext[depKey] = result
+		outcomes[strconv.Itoa(dep.SubtaskId)] = outcome
+		if dep.Name != "" {
+			outcomes[dep.Name] = outcome
+		}
+	}
+	sort.Slice(inputs, func(i, j int) bool { return inputs[i].SubtaskId < inputs[j].SubtaskId })
+
+	if task.When != "" {
+		when, err := workflow.ParseWhen(task.When)
+		if err != nil {
+			return nil, true, err.Error()
+		}
+		run, err := when.Eval(outcomes)
+		if err != nil {
+			return nil, true, err.Error()
+		}
+		if !run {
+			return nil, true, fmt.Sprintf("when clause is false: %s", task.When)
+		}
 	}
 
-	return depsContext, true
+	return inputs, true, ""
 }
 
 // storeTaskResult stores the result (context) of a completed task in Redis to be used by the next worker.
@@ -60,44 +112,172 @@ func storeTaskResult(s *db.Store, task models.Task, result string) error {
 	return s.Rdb.Set(ctx, key, result, 0).Err()
 }
 
+// storeSubtaskSpan saves a subtask's span context so its dependents can link to it
+func storeSubtaskSpan(s *db.Store, task models.Task, spanCtx context.Context) {
+	carrier := tracing.Inject(spanCtx)
+	if carrier == nil {
+		return
+	}
+	carrierJSON, err := json.Marshal(carrier)
+	if err != nil {
+		return
+	}
+	key := fmt.Sprintf("task_span:%s:%d", task.TaskId, task.SubtaskId)
+	s.Rdb.Set(ctx, key, carrierJSON, 24*time.Hour)
+}
+
+// dependencyLinks returns span links to the subtasks a task depends on
+func dependencyLinks(s *db.Store, task models.Task) []trace.Link {
+	var links []trace.Link
+	for _, dep := range task.Dependencies {
+		key := fmt.Sprintf("task_span:%s:%d", task.TaskId, dep.SubtaskId)
+		carrierJSON, err := s.Rdb.Get(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+		var carrier map[string]string
+		if err := json.Unmarshal([]byte(carrierJSON), &carrier); err != nil {
+			continue
+		}
+		if link, ok := tracing.Link(carrier); ok {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// observeSubtask records the outcome and duration of a subtask run
+func observeSubtask(task models.Task, outcome string, elapsed time.Duration) {
+	metrics.SubtasksProcessed.WithLabelValues(task.Type, outcome).Inc()
+	metrics.SubtaskDuration.WithLabelValues(task.Type, outcome).Observe(elapsed.Seconds())
+}
+
 // startWorker spawns a worker to process tasks. It checks dependencies and passes dependency context to the processing function.
 func startWorker(s *db.Store, workerId int32) {
-	log.Printf("Worker %d started", workerId)
-	idleTimeout := time.NewTimer(10 * time.Second) // Worker shuts down after idling for 10 seconds
+	logger := s.Log.With("worker_id", workerKey(workerId))
+	logger.Info("Worker started")
+	cfg := GetAutoscaleConfig()
+	idleTimeout := time.NewTimer(cfg.IdleTimeout) // Worker shuts down after idling, unless the pool is at its minimum
+
+	if err := registerWorker(s, workerId); err != nil {
+		logger.Error("Worker failed to register", "error", err)
+	}
+	stopHeartbeat := make(chan struct{})
+	go heartbeat(s, workerId, stopHeartbeat)
+	defer func() {
+		close(stopHeartbeat)
+		deregisterWorker(s, workerId)
+		workerWg.Done()
+	}()
 
 	for {
+		if IsDraining() {
+			atomic.AddInt32(&activeWorkers, -1)
+			logger.Info("Worker stopped for drain")
+			return
+		}
+
 		taskKeys, err := s.Rdb.Keys(ctx, "task_queue:*").Result()
+		if err == nil {
+			taskKeys = scheduleQueues(s, taskKeys)
+		}
 		if err != nil || len(taskKeys) == 0 {
 			// Wait for tasks, then check again
 			select {
 			case <-idleTimeout.C:
-				log.Printf("Worker %d terminated due to inactivity", workerId)
-				atomic.AddInt32(&activeWorkers, -1)
-				return // Exit if no task arrives within timeout
+				if releaseIdleWorker(cfg.MinWorkers) {
+					logger.Info("Worker terminated due to inactivity")
+					return // Exit if no task arrives within timeout
+				}
+				idleTimeout.Reset(cfg.IdleTimeout)
 			default:
+				if claimRetirement() {
+					atomic.AddInt32(&activeWorkers, -1)
+					logger.Info("Worker retired by autoscaler")
+					return
+				}
 				time.Sleep(2 * time.Second)
 			}
 			continue
 		}
 
 		for _, taskQueue := range taskKeys {
-			result, err := s.Rdb.LPop(ctx, taskQueue).Result()
+			if IsDraining() {
+				break
+			}
+
+			// Lease the task rather than popping it so it can be recovered if this worker dies
+			result, err := leaseTask(s, workerId, taskQueue)
 			if err != nil {
 				continue
 			}
 
 			var task models.Task
 			if err := json.Unmarshal([]byte(result), &task); err != nil {
-				log.Printf("Worker %d failed to parse task: %s\n", workerId, err)
+				logger.Error("Worker failed to parse task", "queue", taskQueue, "error", err)
+				releaseTask(s, workerId, result)
+				continue
+			}
+
+			taskLog := logger.With("task_id", task.TaskId, "subtask_id", task.SubtaskId)
+
+			// A replan superseded this subtask after it was queued
+			if status, _ := s.Rdb.Get(ctx, SubtaskStatusKey(task.TaskId, task.SubtaskId)).Result(); status == "replaced" {
+				taskLog.Debug("Dropping replaced subtask")
+				releaseTask(s, workerId, result)
 				continue
 			}
 
 			// Check if dependencies are complete
-			depsContext, ready := checkDependencies(s, task)
+			inputs, ready, skip := checkDependencies(s, task)
 			if !ready {
 				// Not all dependencies have complete, requeue the task
-				// log.Printf("Worker %d: Dependencies not complete for subtask %d in task %s - requeueing", workerId, task.SubtaskId, task.TaskId)
-				s.Rdb.RPush(ctx, taskQueue, result)
+				taskLog.Debug("Dependencies not complete, requeueing")
+				requeueTask(s, workerId, taskQueue, result)
+				metrics.SubtaskRequeues.WithLabelValues("dependencies").Inc()
+				continue
+			}
+			if skip != "" {
+				// A condition ruled the subtask out; its own dependents decide for themselves
+				taskLog.Info("Skipping subtask", "reason", skip)
+				setSubtaskStatus(s, task, "skipped", skip)
+				releaseTask(s, workerId, result)
+				observeSubtask(task, "skipped", 0)
+				checkTaskDone(s, task.TaskId)
+				continue
+			}
+
+			// Set aside subtasks whose task has run out of budget
+			if exceeded, onExceeded := budgetExceeded(s, task.TaskId); exceeded {
+				taskLog.Warn("Task is over budget, holding subtask", "on_exceeded", onExceeded)
+				holdOverBudget(s, task, result, onExceeded)
+				releaseTask(s, workerId, result)
+				continue
+			}
+
+			// Map subtasks don't run anything themselves: they spawn their children and finish once those have
+			if task.Type == workflow.TypeMap {
+				children, err := expandMap(s, task)
+				releaseTask(s, workerId, result)
+				if err != nil {
+					taskLog.Error("Failed to expand map subtask", "error", err)
+					observeSubtask(task, failSubtask(s, task, err), 0)
+				} else {
+					taskLog.Info("Expanded map subtask", "children", children)
+					observeSubtask(task, "expanded", 0)
+				}
+				checkTaskDone(s, task.TaskId)
+				continue
+			}
+
+			// Acquire type, queue and resource permits; if any is exhausted, requeue and try again later
+			permits, exhausted, err := acquirePermits(s, workerId, task)
+			if exhausted != "" {
+				if err != nil {
+					taskLog.Error("Failed to acquire permit", "permit", exhausted, "error", err)
+				}
+				requeueTask(s, workerId, taskQueue, result)
+				metrics.SubtaskRequeues.WithLabelValues("concurrency_limit").Inc()
 				continue
 			}
 
@@ -105,30 +285,153 @@ func startWorker(s *db.Store, workerId int32) {
 			if !idleTimeout.Stop() {
 				<-idleTimeout.C
 			}
-			idleTimeout.Reset(10 * time.Second)
+			idleTimeout.Reset(cfg.IdleTimeout)
+
+			// Claim the subtask; a replan may have replaced it since it was leased
+			started, err := startSubtask(s, task)
+			if err != nil || !started {
+				releasePermits(s, workerId, task, permits)
+				if err != nil {
+					taskLog.Error("Failed to start subtask, requeueing", "error", err)
+					requeueTask(s, workerId, taskQueue, result)
+				} else {
+					taskLog.Debug("Dropping subtask that is no longer pending")
+					releaseTask(s, workerId, result)
+				}
+				continue
+			}
 
 			// Process the task, passing along dependency context
-			resultContext, err := ai.ProcessTask(task, depsContext)
+			setCurrentTask(s, workerId, fmt.Sprintf("%s:%d", task.TaskId, task.SubtaskId))
+			startedAt := time.Now()
+			stopRefresh := make(chan struct{})
+			go refreshPermits(s, workerId, task, permits, stopRefresh)
+
+			// Continue the trace started at decomposition, linking to the spans of the dependencies
+			spanCtx, span := tracing.Tracer.Start(tracing.Extract(execCtx, task.TraceContext), "subtask",
+				trace.WithLinks(dependencyLinks(s, task)...),
+				trace.WithAttributes(
+					attribute.String("task.id", task.TaskId.String()),
+					attribute.Int("subtask.id", task.SubtaskId),
+					attribute.String("subtask.type", task.Type),
+					attribute.String("worker.id", workerKey(workerId)),
+					attribute.Int("subtask.attempts", task.Attempts),
+				))
+			storeSubtaskSpan(s, task, spanCtx)
+			taskCtx, usage := ai.WithUsageCollector(logging.WithLogger(spanCtx, taskLog))
+			// Composite subtasks ask the LLM for a child graph instead of doing the work themselves
+			var taskResult ai.Result
+			var children int
+			if task.Type == workflow.TypeComposite {
+				children, err = expandComposite(taskCtx, s, task, inputs)
+			} else if err = prepareWorkspace(taskCtx, s, task, inputs); err == nil {
+				taskResult, err = ai.ProcessTask(taskCtx, task, inputs)
+				if err == nil {
+					err = registerArtifacts(taskCtx, s, task, taskResult.Artifacts)
+				}
+			}
+			close(stopRefresh)
+			releasePermits(s, workerId, task, permits)
+			elapsed := time.Since(startedAt)
+			recordTaskLatency(elapsed)
+			RecordTaskTokens(usage.TotalTokens())
+			ChargeBudget(s, task.TaskId, usage.TotalTokens(), usage.TotalCost())
+			ChargeTenant(s, task.Tenant, usage.TotalTokens())
+			if err := s.SaveLLMUsage(ai.UsageRecords(usage, task.TaskId, task.SubtaskId, task.ClientId, task.Type)); err != nil {
+				taskLog.Error("Failed to record LLM usage", "error", err)
+			}
+			setCurrentTask(s, workerId, "")
+			if err != nil && execCtx.Err() != nil {
+				// Aborted by shutdown: keep the lease so deregistering returns the subtask to its queue
+				taskLog.Warn("Subtask interrupted by shutdown")
+				stopSubtask(s, task)
+				observeSubtask(task, "interrupted", elapsed)
+				span.SetStatus(codes.Error, "interrupted by shutdown")
+				span.End()
+				atomic.AddInt32(&activeWorkers, -1)
+				return
+			}
+			var held *ai.ApprovalRequiredError
+			if errors.As(err, &held) {
+				// Park the subtask with its generated command until a reviewer decides
+				taskLog.Warn("Generated command needs approval", "command", held.Command.Command, "args", held.Command.Args, "rules", held.Rules)
+				if err := holdForApproval(s, task, held); err != nil {
+					taskLog.Error("Failed to hold subtask for approval, requeueing", "error", err)
+					stopSubtask(s, task)
+					requeueTask(s, workerId, taskQueue, result)
+				} else {
+					releaseTask(s, workerId, result)
+				}
+				observeSubtask(task, "awaiting_approval", elapsed)
+				span.SetAttributes(attribute.StringSlice("subtask.risk_rules", held.Rules))
+				span.End()
+				break // Reschedule so the next pick reflects the tenants' new shares
+			}
 			if err != nil {
-				log.Printf("Worker %d: Error processing subtask %d in task %s: %v", workerId, task.SubtaskId, task.TaskId, err)
-				continue
+				taskLog.Error("Error processing subtask", "error", err)
+				observeSubtask(task, failSubtask(s, task, err), elapsed)
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "subtask failed")
+				span.End()
+				releaseTask(s, workerId, result)
+				replanAfterFailure(s, task, err.Error())
+				checkTaskDone(s, task.TaskId)
+				break // Reschedule so the next pick reflects the tenants' new shares
+			}
+
+			if task.Type == workflow.TypeComposite {
+				// Settled like a map once its children finish
+				releaseTask(s, workerId, result)
+				observeSubtask(task, "expanded", elapsed)
+				span.SetAttributes(attribute.Int("subtask.children", children))
+				span.End()
+				checkTaskDone(s, task.TaskId)
+				taskLog.Info("Decomposed composite subtask", "children", children, "duration", elapsed)
+				break // Reschedule so the next pick reflects the tenants' new shares
 			}
 
 			// Store task result (context) for dependent tasks to access
-			if err := storeTaskResult(s, task, resultContext); err != nil {
-				log.Printf("Worker %d: Failed to store result for subtask %d in task %s: %v", workerId, task.SubtaskId, task.TaskId, err)
+			if err := storeTaskOutput(s, task, taskResult.Output); err != nil {
+				taskLog.Error("Failed to store subtask output", "error", err)
 			}
+			if taskResult.Structured != nil {
+				if err := storeStructuredOutput(s, task, taskResult.Structured); err != nil {
+					taskLog.Error("Failed to store structured output", "error", err)
+				}
+			}
+			if err := storeTaskResult(s, task, taskResult.Context); err != nil {
+				taskLog.Error("Failed to store subtask result", "error", err)
+			}
+			releaseTask(s, workerId, result)
+			observeSubtask(task, "completed", elapsed)
+			span.End()
+			setSubtaskStatus(s, task, "completed", "")
+			checkTaskDone(s, task.TaskId)
 
-			log.Printf("Worker %d completed subtask %d in task %s", workerId, task.SubtaskId, task.TaskId)
+			taskLog.Info("Worker completed subtask", "duration", elapsed)
+			break // Reschedule so the next pick reflects the tenants' new shares
 		}
 	}
 }
 
-// WorkerManager dynamically adjusts the number of workers
-func WorkerManager(s *db.Store) {
-	fmt.Println("Starting Worker Manager...")
+// WorkerManager dynamically adjusts the number of workers according to the configured autoscaling policy
+func WorkerManager(s *db.Store, cfg AutoscaleConfig) {
+	s.Log.Info("Starting Worker Manager", "policy", cfg.Policy.Name(), "min_workers", cfg.MinWorkers, "max_workers", cfg.MaxWorkers)
+
+	metricsMu.Lock()
+	autoscaleConfig = cfg
+	autoscaleMetrics.Policy = cfg.Policy.Name()
+	metricsMu.Unlock()
+
+	var lastScaled time.Time
 
 	for {
+		// A draining node keeps its remaining workers but never spawns new ones
+		if IsDraining() {
+			time.Sleep(cfg.PollInterval)
+			continue
+		}
+
 		taskKeys, _ := s.Rdb.Keys(ctx, "task_queue:*").Result()
 		totalTasks := int32(0)
 
@@ -139,23 +442,63 @@ func WorkerManager(s *db.Store) {
 
 		currentWorkers := atomic.LoadInt32(&activeWorkers)
 
-		// If there are more tasks than active workers and we have not hit maxWorkers,
-		// spawn new workers
-		if totalTasks > currentWorkers && currentWorkers < maxWorkers {
-			// Spawn new workers
-			newWorkers := min(totalTasks-currentWorkers, maxWorkers-currentWorkers)
-			for i := int32(0); i < newWorkers; i++ {
-				// Generate a unique worker ID
-				id := atomic.AddInt32(&uniqueWorkerId, 1)
-				atomic.AddInt32(&activeWorkers, 1)
-				go startWorker(s, id)
+		metricsMu.Lock()
+		snapshot := ScalingSnapshot{
+			QueuedTasks:      totalTasks,
+			ActiveWorkers:    currentWorkers,
+			AvgTaskLatency:   autoscaleMetrics.AvgTaskLatency,
+			AvgTokensPerTask: autoscaleMetrics.AvgTokensPerTask,
+		}
+		metricsMu.Unlock()
+
+		desired := clampWorkers(cfg.Policy.Desired(snapshot), cfg)
+
+		metricsMu.Lock()
+		autoscaleMetrics.ActiveWorkers = currentWorkers
+		autoscaleMetrics.DesiredWorkers = desired
+		autoscaleMetrics.QueuedTasks = totalTasks
+		metricsMu.Unlock()
+
+		event := ScalingEvent{
+			Time:          time.Now().UTC(),
+			Node:          nodeId,
+			Policy:        cfg.Policy.Name(),
+			QueuedTasks:   totalTasks,
+			ActiveWorkers: currentWorkers,
+			Desired:       desired,
+		}
+
+		event.Action, event.Delta = scalingDecision(desired, currentWorkers, atomic.LoadInt32(&pendingRetirements),
+			time.Since(lastScaled), cfg.Cooldown)
+		if event.Delta > 0 {
+			if event.Action == "scale_up" {
+				// Spawn new workers, cancelling any retirements that have not been claimed yet
+				atomic.StoreInt32(&pendingRetirements, 0)
+				for i := int32(0); i < event.Delta; i++ {
+					// Generate a unique worker ID
+					id := atomic.AddInt32(&uniqueWorkerId, 1)
+					atomic.AddInt32(&activeWorkers, 1)
+					workerWg.Add(1)
+					go startWorker(s, id)
+				}
+			} else {
+				// Ask idle workers to shut down; busy workers finish their current subtask first
+				atomic.StoreInt32(&pendingRetirements, event.Delta)
+			}
+
+			lastScaled = event.Time
+			metricsMu.Lock()
+			if event.Action == "scale_up" {
+				autoscaleMetrics.ScaleUps++
+				autoscaleMetrics.WorkersSpawned += int64(event.Delta)
+			} else {
+				autoscaleMetrics.ScaleDowns++
 			}
-			log.Printf("Scaled up: Spawned %d new workers (Total: %d)\n", newWorkers, atomic.LoadInt32(&activeWorkers))
-		} else if totalTasks == 0 && currentWorkers > minWorkers {
-			// When no tasks are in queue, idle workers will eventually shut down on their own
-			log.Println("Scaled down: Waiting for idle workers to terminate.")
+			autoscaleMetrics.LastScaledAt = lastScaled
+			metricsMu.Unlock()
+			publishScalingEvent(s, event)
 		}
 
-		time.Sleep(5 * time.Second) // Check queue size every 5 seconds
+		time.Sleep(cfg.PollInterval)
 	}
 }
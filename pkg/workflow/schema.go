@@ -0,0 +1,41 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaURL names the single in-memory resource an output schema is compiled from
+const schemaURL = "output_schema.json"
+
+// CompileSchema compiles a subtask's output JSON schema. References to other documents are refused so a schema cannot
+// make the server read files or fetch URLs.
+func CompileSchema(schema map[string]interface{}) (*jsonschema.Schema, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external schema references are not allowed: %s", url)
+	}
+	if err := compiler.AddResource(schemaURL, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(schemaURL)
+}
+
+// ValidateOutput checks a JSON value against a compiled output schema
+func ValidateOutput(schema *jsonschema.Schema, value json.RawMessage) error {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return fmt.Errorf("output is not valid JSON: %v", err)
+	}
+	return schema.Validate(v)
+}
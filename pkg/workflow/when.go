@@ -0,0 +1,289 @@
+package workflow
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Edge conditions of a dependency. An empty condition means ConditionSuccess.
+const (
+	ConditionSuccess = "success" // Run only if the dependency completed
+	ConditionFailure = "failure" // Run only if the dependency failed, e.g. a fallback
+	ConditionAlways  = "always"  // Run once the dependency has finished either way, e.g. a cleanup
+)
+
+// Outcome is what a subtask's dependents see of a finished dependency
+type Outcome struct {
+	Status string // completed, failed, skipped, rejected or budget_exceeded
+	Output string // Result context; empty unless the dependency completed
+}
+
+// Failed reports whether the dependency ended without completing, other than by being skipped
+func (o Outcome) Failed() bool {
+	return o.Status != "completed" && o.Status != "skipped"
+}
+
+// EdgeSatisfied reports whether a dependency's outcome lets its dependent run. match, if set, is a regular
+// expression the output of a completed dependency must match.
+func EdgeSatisfied(condition string, match string, outcome Outcome) (bool, error) {
+	switch condition {
+	case "", ConditionSuccess:
+		if outcome.Status != "completed" {
+			return false, nil
+		}
+		if match == "" {
+			return true, nil
+		}
+		re, err := regexp.Compile(match)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(outcome.Output), nil
+	case ConditionFailure:
+		return outcome.Failed(), nil
+	case ConditionAlways:
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown condition %q", condition)
+}
+
+// validateEdge checks a dependency's condition and match pattern
+func validateEdge(condition string, match string) error {
+	switch condition {
+	case "", ConditionSuccess, ConditionFailure, ConditionAlways:
+	default:
+		return fmt.Errorf("condition must be %s, %s or %s", ConditionSuccess, ConditionFailure, ConditionAlways)
+	}
+	if match != "" {
+		if condition != "" && condition != ConditionSuccess {
+			return fmt.Errorf("match only applies to the %s condition", ConditionSuccess)
+		}
+		if _, err := regexp.Compile(match); err != nil {
+			return fmt.Errorf("invalid match pattern: %v", err)
+		}
+	}
+	return nil
+}
+
+// When is a parsed when-clause. Clauses compare the status or output of dependencies, named by step name or
+// subtask id, with quoted strings:
+//
+//	build.status == "completed" && (test.output contains "PASS" || !(3.output matches "^warn"))
+//
+// Operators are ==, !=, contains and matches (a regular expression), combined with &&, || and !.
+type When struct {
+	root node
+	Refs []string // Dependencies the clause refers to
+}
+
+type node interface {
+	eval(outcomes map[string]Outcome) (bool, error)
+}
+
+type notNode struct{ operand node }
+
+type logicNode struct {
+	and         bool
+	left, right node
+}
+
+type compareNode struct {
+	ref, field, op, value string
+	pattern               *regexp.Regexp
+}
+
+func (n notNode) eval(outcomes map[string]Outcome) (bool, error) {
+	v, err := n.operand.eval(outcomes)
+	return !v, err
+}
+
+func (n logicNode) eval(outcomes map[string]Outcome) (bool, error) {
+	left, err := n.left.eval(outcomes)
+	if err != nil {
+		return false, err
+	}
+	if left != n.and {
+		return left, nil // Short-circuit: false && x, true || x
+	}
+	return n.right.eval(outcomes)
+}
+
+func (n compareNode) eval(outcomes map[string]Outcome) (bool, error) {
+	outcome, ok := outcomes[n.ref]
+	if !ok {
+		return false, fmt.Errorf("when: %q is not a dependency", n.ref)
+	}
+	actual := outcome.Status
+	if n.field == "output" {
+		actual = outcome.Output
+	}
+	switch n.op {
+	case "==":
+		return actual == n.value, nil
+	case "!=":
+		return actual != n.value, nil
+	case "contains":
+		return strings.Contains(actual, n.value), nil
+	default:
+		return n.pattern.MatchString(actual), nil
+	}
+}
+
+// Eval evaluates the clause against the outcomes of the subtask's dependencies, keyed by name and by subtask id
+func (w *When) Eval(outcomes map[string]Outcome) (bool, error) {
+	return w.root.eval(outcomes)
+}
+
+// ParseWhen parses a when-clause
+func ParseWhen(expr string) (*When, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &whenParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("when: unexpected %q", p.tokens[p.pos])
+	}
+	return &When{root: root, Refs: p.refs}, nil
+}
+
+// tokenize splits a clause into quoted strings, operators, parentheses and references such as build.output
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(expr) && expr[j] != '"' {
+				if expr[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("when: unterminated string")
+			}
+			tokens = append(tokens, expr[i:j+1])
+			i = j + 1
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"),
+			strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "!="):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case c == '!' || c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < len(expr) && (unicode.IsLetter(rune(expr[j])) || unicode.IsDigit(rune(expr[j])) || strings.ContainsRune("_-.", rune(expr[j]))) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("when: unexpected character %q", c)
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type whenParser struct {
+	tokens []string
+	pos    int
+	refs   []string
+}
+
+func (p *whenParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *whenParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *whenParser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var right node
+		if right, err = p.parseAnd(); err == nil {
+			left = logicNode{and: false, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *whenParser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var right node
+		if right, err = p.parseUnary(); err == nil {
+			left = logicNode{and: true, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *whenParser) parseUnary() (node, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		operand, err := p.parseUnary()
+		return notNode{operand: operand}, err
+	case "(":
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("when: missing )")
+		}
+		return inner, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *whenParser) parseCompare() (node, error) {
+	field := p.next()
+	ref, attr, found := strings.Cut(field, ".")
+	if !found || ref == "" || (attr != "status" && attr != "output") {
+		return nil, fmt.Errorf("when: expected <dependency>.status or <dependency>.output, got %q", field)
+	}
+
+	op := p.next()
+	if op != "==" && op != "!=" && op != "contains" && op != "matches" {
+		return nil, fmt.Errorf("when: expected ==, !=, contains or matches after %s, got %q", field, op)
+	}
+
+	literal := p.next()
+	value, err := strconv.Unquote(literal)
+	if err != nil || !strings.HasPrefix(literal, `"`) {
+		return nil, fmt.Errorf("when: expected a quoted string after %s %s", field, op)
+	}
+
+	n := compareNode{ref: ref, field: attr, op: op, value: value}
+	if op == "matches" {
+		if n.pattern, err = regexp.Compile(value); err != nil {
+			return nil, fmt.Errorf("when: invalid pattern %q: %v", value, err)
+		}
+	}
+	p.refs = append(p.refs, ref)
+	return n, nil
+}
@@ -0,0 +1,118 @@
+package workflow
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWhenEval(t *testing.T) {
+	outcomes := map[string]Outcome{
+		"build": {Status: "completed", Output: "built ok"},
+		"test":  {Status: "failed"},
+		"3":     {Status: "completed", Output: `warn: "quoted" \ path`},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`build.status == "completed"`, true},
+		{`build.status != "completed"`, false},
+		{`build.output contains "ok"`, true},
+		{`3.output matches "^warn"`, true},
+		{`test.output matches "."`, false},
+
+		// && binds tighter than ||
+		{`build.status == "failed" && test.status == "failed" || build.output contains "built"`, true},
+		{`build.output contains "built" || build.status == "failed" && test.status == "completed"`, true},
+		{`(build.output contains "built" || build.status == "failed") && test.status == "completed"`, false},
+
+		// ! applies to the comparison or group right after it
+		{`!test.status == "completed"`, true},
+		{`!build.status == "completed" || test.status == "failed"`, true},
+		{`!(build.status == "completed" || test.status == "failed")`, false},
+		{`!!build.status == "completed"`, true},
+
+		// Quoted strings keep escapes and operators literal
+		{`3.output contains "\"quoted\""`, true},
+		{`3.output contains "\\ path"`, true},
+		{`build.output == "a && b || !c"`, false},
+		{`build.output != "(unbalanced"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			when, err := ParseWhen(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseWhen: %v", err)
+			}
+			got, err := when.Eval(outcomes)
+			if err != nil {
+				t.Fatalf("Eval: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWhenShortCircuits(t *testing.T) {
+	outcomes := map[string]Outcome{"build": {Status: "completed"}}
+	tests := []struct {
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{`build.status == "completed" || missing.status == "completed"`, true, false},
+		{`build.status == "failed" && missing.status == "completed"`, false, false},
+		{`build.status == "completed" && missing.status == "completed"`, false, true},
+	}
+	for _, tt := range tests {
+		when, err := ParseWhen(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseWhen(%q): %v", tt.expr, err)
+		}
+		got, err := when.Eval(outcomes)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Eval(%q) = %v, %v; want %v, error %v", tt.expr, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseWhenRefs(t *testing.T) {
+	when, err := ParseWhen(`build.status == "completed" && (3.output contains "x" || !build.output matches "y")`)
+	if err != nil {
+		t.Fatalf("ParseWhen: %v", err)
+	}
+	if want := []string{"build", "3", "build"}; !slices.Equal(when.Refs, want) {
+		t.Errorf("Refs = %v, want %v", when.Refs, want)
+	}
+}
+
+func TestParseWhenRejectsBadInput(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"empty", ``},
+		{"unterminated string", `build.status == "completed`},
+		{"unquoted value", `build.status == completed`},
+		{"single quotes", `build.status == 'completed'`},
+		{"unknown field", `build.exit_code == "0"`},
+		{"missing field", `build == "completed"`},
+		{"unknown operator", `build.status = "completed"`},
+		{"missing value", `build.status ==`},
+		{"bad pattern", `build.output matches "("`},
+		{"missing )", `(build.status == "completed"`},
+		{"stray )", `build.status == "completed")`},
+		{"dangling &&", `build.status == "completed" &&`},
+		{"dangling !", `!`},
+		{"bad character", `build.status == "completed" ; rm`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseWhen(tt.expr); err == nil {
+				t.Errorf("ParseWhen(%q) accepted bad input", tt.expr)
+			}
+		})
+	}
+}
@@ -0,0 +1,455 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/arnavsurve/promise/pkg/workspace"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// MaxSteps bounds the size of a single workflow
+const MaxSteps = 500
+
+// MaxMapItems bounds how many children a single map subtask can spawn
+const MaxMapItems = 1000
+
+// Subtask types a step can run as
+const (
+	TypeCommand   = "command_execution"
+	TypeCode      = "code_generation"
+	TypeMap       = "map"       // Fans out into one child per item of a dependency's output
+	TypeComposite = "composite" // Decomposed into a graph of children by the LLM when it runs
+)
+
+// ItemPlaceholder is replaced by the current item in a map's command and prompt
+const ItemPlaceholder = "{{item}}"
+
+var stepNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Step is one subtask of a workflow. Steps refer to each other by name in depends_on and when.
+type Step struct {
+	Name        string           `json:"name"`
+	Type        string           `json:"type"`                  // command_execution, code_generation, composite or map
+	Description string           `json:"description,omitempty"` // Human-readable summary, defaults to the command or prompt
+	Command     string           `json:"command,omitempty"`     // Shell command run as-is; command_execution only
+	Prompt      string           `json:"prompt,omitempty"`      // Instructions for the LLM when no command is given
+	DependsOn   []StepDependency `json:"depends_on,omitempty"`
+	When        string           `json:"when,omitempty"` // Clause over the dependencies' results; the step is skipped when false
+	Resources   []string         `json:"resources,omitempty"`
+	Map         *MapStep         `json:"map,omitempty"` // Required for map steps
+	// OutputSchema is a JSON schema the step's structured output must match. Dependents receive the validated value.
+	OutputSchema map[string]interface{} `json:"output_schema,omitempty"`
+	// Artifacts are files the step produces, relative to its working directory. They are kept in the artifact store and
+	// staged read-only into the working directories of dependents; the step fails if one is missing.
+	Artifacts []string `json:"artifacts,omitempty"`
+}
+
+// MapStep spawns one child per item listed in the output of the step named in from, which must be a dependency.
+// Any step depending on the map runs once every child has finished, and sees their outputs as a JSON array.
+type MapStep struct {
+	From           string `json:"from"`
+	Type           string `json:"type"`                      // command_execution, code_generation or composite
+	Command        string `json:"command,omitempty"`         // {{item}} is replaced by the shell-quoted item
+	Prompt         string `json:"prompt,omitempty"`          // {{item}} is replaced by the item
+	MaxConcurrency int64  `json:"max_concurrency,omitempty"` // Children running at once, 0 for unlimited
+	MaxFailures    int    `json:"max_failures,omitempty"`    // Children that may fail before the map fails
+	// OutputSchema is the JSON schema every child's structured output must match
+	OutputSchema map[string]interface{} `json:"output_schema,omitempty"`
+}
+
+// StepDependency is an edge to another step. It is written as the step's name, or as an object to add a condition.
+type StepDependency struct {
+	Step      string `json:"step"`
+	Condition string `json:"condition,omitempty"` // success (default), failure or always
+	Match     string `json:"match,omitempty"`     // Regular expression the dependency's output must match
+}
+
+// UnmarshalJSON accepts either "name" or {"step": "name", ...}
+func (d *StepDependency) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*d = StepDependency{Step: name}
+		return nil
+	}
+	type plain StepDependency
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*plain)(d))
+}
+
+// Definition is a declarative subtask DAG submitted without LLM decomposition
+type Definition struct {
+	Name  string `json:"name,omitempty"`
+	Steps []Step `json:"steps"`
+}
+
+// Error reports which field of a definition is invalid, e.g. steps[2].depends_on
+type Error struct {
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func invalid(field string, format string, args ...interface{}) error {
+	return &Error{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// YAMLToJSON converts a YAML document to JSON so it can be decoded with the same strict rules as a JSON body
+func YAMLToJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// Validate checks step names, types and bodies, and that depends_on forms an acyclic graph of existing steps
+func (d *Definition) Validate() error {
+	if len(d.Steps) == 0 {
+		return invalid("steps", "a workflow needs at least one step")
+	}
+	if len(d.Steps) > MaxSteps {
+		return invalid("steps", "a workflow can have at most %d steps", MaxSteps)
+	}
+
+	index := make(map[string]int, len(d.Steps))
+	for i, step := range d.Steps {
+		field := fmt.Sprintf("steps[%d]", i)
+		if !stepNamePattern.MatchString(step.Name) {
+			return invalid(field+".name", "step names must be 1-63 lowercase letters, digits, dashes or underscores")
+		}
+		if _, dup := index[step.Name]; dup {
+			return invalid(field+".name", "duplicate step name %q", step.Name)
+		}
+		index[step.Name] = i
+
+		if step.Type == TypeMap {
+			if step.Map == nil {
+				return invalid(field+".map", "step %q: map steps need a map block", step.Name)
+			}
+			if step.Command != "" || step.Prompt != "" {
+				return invalid(field, "step %q: map steps take their command or prompt inside the map block", step.Name)
+			}
+			if step.Map.Type == TypeMap {
+				return invalid(field+".map.type", "step %q: a map cannot spawn maps", step.Name)
+			}
+			if err := validateBody(step.Map.Type, step.Map.Command, step.Map.Prompt); err != nil {
+				return invalid(field+".map", "step %q: %s", step.Name, err)
+			}
+			if step.Map.MaxConcurrency < 0 || step.Map.MaxFailures < 0 {
+				return invalid(field+".map", "step %q: max_concurrency and max_failures cannot be negative", step.Name)
+			}
+			if !slices.ContainsFunc(step.DependsOn, func(dep StepDependency) bool { return dep.Step == step.Map.From }) {
+				return invalid(field+".map.from", "step %q maps over %q, which is not a dependency", step.Name, step.Map.From)
+			}
+			if step.Map.OutputSchema != nil {
+				if _, err := CompileSchema(step.Map.OutputSchema); err != nil {
+					return invalid(field+".map.output_schema", "step %q: invalid output schema: %s", step.Name, err)
+				}
+			}
+		} else if step.Map != nil {
+			return invalid(field+".map", "step %q: only map steps take a map block", step.Name)
+		} else if err := validateBody(step.Type, step.Command, step.Prompt); err != nil {
+			return invalid(field, "step %q: %s", step.Name, err)
+		}
+
+		if step.OutputSchema != nil {
+			if _, err := CompileSchema(step.OutputSchema); err != nil {
+				return invalid(field+".output_schema", "step %q: invalid output schema: %s", step.Name, err)
+			}
+		}
+		if len(step.Artifacts) > 0 && (step.Type == TypeMap || step.Type == TypeComposite) {
+			return invalid(field+".artifacts", "step %q: %s steps produce artifacts through their children", step.Name, step.Type)
+		}
+		for _, artifact := range step.Artifacts {
+			if err := ValidateArtifactPath(artifact); err != nil {
+				return invalid(field+".artifacts", "step %q: %s", step.Name, err)
+			}
+		}
+	}
+
+	for i, step := range d.Steps {
+		field := fmt.Sprintf("steps[%d]", i)
+		deps := make([]string, 0, len(step.DependsOn))
+		for _, dep := range step.DependsOn {
+			if _, ok := index[dep.Step]; !ok {
+				return invalid(field+".depends_on", "step %q depends on unknown step %q", step.Name, dep.Step)
+			}
+			if dep.Step == step.Name {
+				return invalid(field+".depends_on", "step %q depends on itself", step.Name)
+			}
+			if err := validateEdge(dep.Condition, dep.Match); err != nil {
+				return invalid(field+".depends_on", "step %q, dependency %q: %s", step.Name, dep.Step, err)
+			}
+			deps = append(deps, dep.Step)
+		}
+		if err := validateWhen(step.When, deps); err != nil {
+			return invalid(field+".when", "step %q: %s", step.Name, err)
+		}
+	}
+
+	names := make([]string, len(d.Steps))
+	deps := make([][]int, len(d.Steps))
+	for i, step := range d.Steps {
+		names[i] = step.Name
+		for _, dep := range step.DependsOn {
+			deps[i] = append(deps[i], index[dep.Step])
+		}
+	}
+	if cycle := findCycle(names, deps); cycle != nil {
+		return invalid("steps", "dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// validateBody checks that a command_execution step has a command or a prompt, and code_generation and composite
+// steps a prompt
+func validateBody(taskType string, command string, prompt string) error {
+	command, prompt = strings.TrimSpace(command), strings.TrimSpace(prompt)
+	switch taskType {
+	case TypeCommand:
+		if (command == "") == (prompt == "") {
+			return fmt.Errorf("needs exactly one of command or prompt")
+		}
+	case TypeCode, TypeComposite:
+		if command != "" {
+			return fmt.Errorf("only command_execution steps take a command")
+		}
+		if prompt == "" {
+			return fmt.Errorf("needs a prompt")
+		}
+	default:
+		return fmt.Errorf("type must be %s, %s, %s or %s", TypeCommand, TypeCode, TypeComposite, TypeMap)
+	}
+	return nil
+}
+
+// validateWhen checks a when-clause parses and only refers to the given dependencies
+func validateWhen(when string, deps []string) error {
+	if strings.TrimSpace(when) == "" {
+		return nil
+	}
+	parsed, err := ParseWhen(when)
+	if err != nil {
+		return err
+	}
+	for _, ref := range parsed.Refs {
+		if !slices.Contains(deps, ref) {
+			return fmt.Errorf("when refers to %q, which is not a dependency", ref)
+		}
+	}
+	return nil
+}
+
+// ValidateGraph checks that the subtasks of one task have unique IDs, that their dependencies refer to subtasks
+// of the same task without forming a cycle, and that their conditions and when-clauses are valid
+func ValidateGraph(tasks []models.Task) error {
+	index := make(map[int]int, len(tasks))
+	for i, task := range tasks {
+		if _, dup := index[task.SubtaskId]; dup {
+			return invalid("subtask_id", "duplicate subtask id %d", task.SubtaskId)
+		}
+		index[task.SubtaskId] = i
+	}
+
+	names := make([]string, len(tasks))
+	deps := make([][]int, len(tasks))
+	for i, task := range tasks {
+		names[i] = strconv.Itoa(task.SubtaskId)
+		for _, dep := range task.Dependencies {
+			j, ok := index[dep.SubtaskId]
+			if !ok || dep.TaskId != task.TaskId {
+				return invalid("dependencies", "subtask %d depends on unknown subtask %d", task.SubtaskId, dep.SubtaskId)
+			}
+			if j == i {
+				return invalid("dependencies", "subtask %d depends on itself", task.SubtaskId)
+			}
+			if err := validateEdge(dep.Condition, dep.Match); err != nil {
+				return invalid("dependencies", "subtask %d, dependency %d: %s", task.SubtaskId, dep.SubtaskId, err)
+			}
+			deps[i] = append(deps[i], j)
+		}
+		if err := validateWhen(task.When, DependencyRefs(task)); err != nil {
+			return invalid("when", "subtask %d: %s", task.SubtaskId, err)
+		}
+		if task.Map != nil {
+			if !slices.ContainsFunc(task.Dependencies, func(dep models.Dependency) bool { return dep.SubtaskId == task.Map.From }) {
+				return invalid("map", "subtask %d maps over subtask %d, which is not a dependency", task.SubtaskId, task.Map.From)
+			}
+		}
+	}
+	if cycle := findCycle(names, deps); cycle != nil {
+		return invalid("dependencies", "dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// DependencyRefs lists the names a when-clause may use for a subtask's dependencies: their subtask ids and step names
+func DependencyRefs(task models.Task) []string {
+	refs := make([]string, 0, 2*len(task.Dependencies))
+	for _, dep := range task.Dependencies {
+		refs = append(refs, strconv.Itoa(dep.SubtaskId))
+		if dep.Name != "" {
+			refs = append(refs, dep.Name)
+		}
+	}
+	return refs
+}
+
+// findCycle returns the names along a dependency cycle, or nil if the graph is acyclic.
+// deps[i] holds the indices of the nodes node i depends on.
+func findCycle(names []string, deps [][]int) []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(names))
+	var path []int
+
+	var visit func(i int) []string
+	visit = func(i int) []string {
+		state[i] = visiting
+		path = append(path, i)
+		for _, j := range deps[i] {
+			switch state[j] {
+			case visiting:
+				// Trim the path to the start of the cycle and close it
+				var cycle []string
+				for k := len(path) - 1; k >= 0; k-- {
+					if path[k] == j {
+						for _, n := range path[k:] {
+							cycle = append(cycle, names[n])
+						}
+						return append(cycle, names[j])
+					}
+				}
+			case unvisited:
+				if cycle := visit(j); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = done
+		return nil
+	}
+
+	for i := range names {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// Summary describes a workflow step by step, standing in for the free-text goal of a decomposed task when replanning
+func (d *Definition) Summary() string {
+	var summary strings.Builder
+	name := d.Name
+	if name == "" {
+		name = "unnamed"
+	}
+	fmt.Fprintf(&summary, "Workflow %q with steps:", name)
+	for _, step := range d.Steps {
+		description := step.Description
+		if description == "" {
+			description = step.Prompt
+		}
+		if description == "" {
+			description = step.Command
+		}
+		fmt.Fprintf(&summary, "\n- %s (%s): %s", step.Name, step.Type, description)
+	}
+	return summary.String()
+}
+
+// Tasks converts a validated definition into subtasks of taskId. Subtask IDs follow step order, starting at 1.
+func (d *Definition) Tasks(taskId uuid.UUID) []models.Task {
+	ids := make(map[string]int, len(d.Steps))
+	for i, step := range d.Steps {
+		ids[step.Name] = i + 1
+	}
+
+	tasks := make([]models.Task, 0, len(d.Steps))
+	for i, step := range d.Steps {
+		var dependencies []models.Dependency
+		for _, dep := range step.DependsOn {
+			dependencies = append(dependencies, models.Dependency{
+				TaskId:    taskId,
+				SubtaskId: ids[dep.Step],
+				Name:      dep.Step,
+				Condition: dep.Condition,
+				Match:     dep.Match,
+			})
+		}
+
+		// The worker prompts the LLM with the description, so a prompt always wins over the summary
+		description := step.Prompt
+		if description == "" {
+			description = step.Description
+		}
+		if description == "" {
+			description = step.Command
+		}
+
+		var spec *models.MapSpec
+		if step.Map != nil {
+			spec = &models.MapSpec{
+				From:           ids[step.Map.From],
+				Type:           step.Map.Type,
+				Command:        step.Map.Command,
+				Prompt:         step.Map.Prompt,
+				MaxConcurrency: step.Map.MaxConcurrency,
+				MaxFailures:    step.Map.MaxFailures,
+				OutputSchema:   step.Map.OutputSchema,
+			}
+			if description == "" {
+				description = fmt.Sprintf("Map over the output of %s", step.Map.From)
+			}
+		}
+
+		tasks = append(tasks, models.Task{
+			TaskId:       taskId,
+			SubtaskId:    i + 1,
+			Name:         step.Name,
+			Type:         step.Type,
+			Description:  description,
+			Command:      step.Command,
+			Dependencies: dependencies,
+			When:         step.When,
+			OutputSchema: step.OutputSchema,
+			Map:          spec,
+			Artifacts:    step.Artifacts,
+			Resources:    step.Resources,
+			Status:       "pending",
+		})
+	}
+	return tasks
+}
+
+// ValidateArtifactPath checks that an artifact path stays inside a subtask's working directory and outside the inputs
+// staged there
+func ValidateArtifactPath(artifact string) error {
+	if artifact == "" || filepath.IsAbs(artifact) || !filepath.IsLocal(artifact) {
+		return fmt.Errorf("artifact %q must be a relative path inside the working directory", artifact)
+	}
+	if first, _, _ := strings.Cut(filepath.ToSlash(filepath.Clean(artifact)), "/"); first == workspace.InputsDir {
+		return fmt.Errorf("artifact %q is inside the read-only %s directory", artifact, workspace.InputsDir)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package workflow
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// cmd is a command step depending on the named steps
+func cmd(name string, deps ...string) Step {
+	step := Step{Name: name, Type: TypeCommand, Command: "echo " + name}
+	for _, dep := range deps {
+		step.DependsOn = append(step.DependsOn, StepDependency{Step: dep})
+	}
+	return step
+}
+
+// mapOver is a map step over the output of from, which it depends on unless deps say otherwise
+func mapOver(name string, from string, deps ...string) Step {
+	if deps == nil {
+		deps = []string{from}
+	}
+	step := cmd(name, deps...)
+	step.Type, step.Command = TypeMap, ""
+	step.Map = &MapStep{From: from, Type: TypeCommand, Command: "echo {{item}}"}
+	return step
+}
+
+func TestDefinitionValidate(t *testing.T) {
+	withMap := func(step Step, edit func(*MapStep)) Step {
+		m := *step.Map
+		edit(&m)
+		step.Map = &m
+		return step
+	}
+	tests := []struct {
+		name        string
+		steps       []Step
+		wantField   string // Empty when the definition is valid
+		wantMessage string
+	}{
+		{name: "chain", steps: []Step{cmd("a"), cmd("b", "a"), cmd("c", "a", "b")}},
+		{name: "map", steps: []Step{cmd("list"), mapOver("each", "list"), cmd("sum", "each")}},
+
+		{name: "no steps", wantField: "steps", wantMessage: "at least one step"},
+		{name: "duplicate name", steps: []Step{cmd("a"), cmd("a")}, wantField: "steps[1].name", wantMessage: "duplicate"},
+
+		// Unknown dependencies
+		{name: "unknown dependency", steps: []Step{cmd("a"), cmd("b", "missing")}, wantField: "steps[1].depends_on", wantMessage: `unknown step "missing"`},
+		{name: "self dependency", steps: []Step{cmd("a", "a")}, wantField: "steps[0].depends_on", wantMessage: "depends on itself"},
+		{
+			name: "when refers to a non-dependency", wantField: "steps[2].when", wantMessage: `"a", which is not a dependency`,
+			steps: []Step{cmd("a"), cmd("b"), func() Step { s := cmd("c", "b"); s.When = `a.status == "completed"`; return s }()},
+		},
+
+		// Cycles
+		{name: "two-step cycle", steps: []Step{cmd("a", "b"), cmd("b", "a")}, wantField: "steps", wantMessage: "dependency cycle"},
+		{name: "longer cycle", steps: []Step{cmd("start"), cmd("a", "start", "c"), cmd("b", "a"), cmd("c", "b")}, wantField: "steps", wantMessage: "a -> c -> b -> a"},
+
+		// Map checks
+		{name: "map without block", steps: []Step{cmd("list"), {Name: "each", Type: TypeMap, DependsOn: []StepDependency{{Step: "list"}}}}, wantField: "steps[1].map", wantMessage: "need a map block"},
+		{name: "map from a non-dependency", steps: []Step{cmd("list"), cmd("other"), mapOver("each", "list", "other")}, wantField: "steps[2].map.from", wantMessage: "not a dependency"},
+		{name: "map of maps", steps: []Step{cmd("list"), withMap(mapOver("each", "list"), func(m *MapStep) { m.Type = TypeMap })}, wantField: "steps[1].map.type"},
+		{name: "map without a body", steps: []Step{cmd("list"), withMap(mapOver("each", "list"), func(m *MapStep) { m.Command = "" })}, wantField: "steps[1].map"},
+		{name: "negative max_failures", steps: []Step{cmd("list"), withMap(mapOver("each", "list"), func(m *MapStep) { m.MaxFailures = -1 })}, wantField: "steps[1].map"},
+		{
+			name: "command on a map step", wantField: "steps[1]", wantMessage: "inside the map block",
+			steps: []Step{cmd("list"), func() Step { s := mapOver("each", "list"); s.Command = "echo"; return s }()},
+		},
+		{
+			name: "map block on a command step", wantField: "steps[1].map", wantMessage: "only map steps",
+			steps: []Step{cmd("list"), func() Step { s := cmd("each", "list"); s.Map = &MapStep{From: "list"}; return s }()},
+		},
+		{
+			name: "artifacts on a map step", wantField: "steps[1].artifacts",
+			steps: []Step{cmd("list"), func() Step { s := mapOver("each", "list"); s.Artifacts = []string{"out.txt"}; return s }()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Definition{Steps: tt.steps}).Validate()
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			var werr *Error
+			if !errors.As(err, &werr) {
+				t.Fatalf("Validate() = %v, want a *workflow.Error", err)
+			}
+			if werr.Field != tt.wantField {
+				t.Errorf("field = %q, want %q (%s)", werr.Field, tt.wantField, werr.Message)
+			}
+			if !strings.Contains(werr.Message, tt.wantMessage) {
+				t.Errorf("message = %q, want it to contain %q", werr.Message, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package workspace
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/arnavsurve/promise/pkg/models"
+	"github.com/google/uuid"
+)
+
+// Root is the directory under which every tenant's files are written, ~/promise
+func Root() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "promise"), nil
+}
+
+// TenantDir is the directory holding the files of every task in a tenant
+func TenantDir(tenant string) (string, error) {
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+	root, err := Root()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, tenant), nil
+}
+
+// TaskDir is the directory holding the files generated by a task's subtasks
+func TaskDir(tenant string, taskId uuid.UUID) (string, error) {
+	dir, err := TenantDir(tenant)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, taskId.String()), nil
+}
+
+// InputsDir is the directory of a subtask's workspace its dependencies' artifacts are staged into, read-only, one
+// subdirectory per dependency
+const InputsDir = "inputs"
+
+// SubtaskDir is a subtask's own working directory inside its task's directory. Commands run in it and the artifacts it
+// declares are relative to it.
+func SubtaskDir(tenant string, taskId uuid.UUID, subtaskId int) (string, error) {
+	dir, err := TaskDir(tenant, taskId)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.Itoa(subtaskId)), nil
+}
+
+// Reset empties dir, creating it if needed, so each run of a subtask starts from a fresh directory. Read-only
+// directories left by staging are made writable first so they can be removed.
+func Reset(dir string) error {
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() {
+			os.Chmod(path, 0755)
+		}
+		return nil
+	})
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// Usage returns the number of bytes stored in a tenant's directory. A tenant without files uses 0 bytes.
+func Usage(tenant string) (int64, error) {
+	dir, err := TenantDir(tenant)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return nil // Removed while walking
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}